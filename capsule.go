@@ -5,18 +5,57 @@ import (
 	"encoding/json"
 )
 
+// MetadataKeyTimeout is the metadata key of the deadline of handling a capsule,
+// the value is a duration string such as "30s", it overrides the HandlerTimeout
+// of the digger.
+const MetadataKeyTimeout = "timeout"
+
 type TimeCapsule[P any] struct {
-	Payload   P     `json:"payload"`
-	DugOutAt  int64 `json:"-"`
-	base64Str string
+	ID       string            `json:"id,omitempty"`
+	Version  int               `json:"version,omitempty"`
+	Kind     string            `json:"kind,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Attempts int               `json:"attempts,omitempty"`
+	Payload  P                 `json:"payload"`
+	DugOutAt int64             `json:"-"`
+	// ScheduledAt is the unix milli timestamp the capsule was buried until,
+	// it is set once the capsule is dug out.
+	ScheduledAt int64 `json:"-"`
+	base64Str   string
+	// member is the raw member the capsule was dug out from, which is either the
+	// base64 string of the capsule itself or a claim-check reference.
+	member string
+	// leaseDeadline is the unix milli timestamp the lease of the dug out capsule
+	// expires at.
+	leaseDeadline int64
+	// acked reports whether the capsule was destroyed in the transaction of the
+	// handler, see the TxHandlerFunc of the dataloaders.
+	acked bool
 }
 
+// NewTimeCapsule creates a new capsule of the payload stamped with the current
+// schema version of P.
+func NewTimeCapsule[P any](payload P) *TimeCapsule[P] {
+	return &TimeCapsule[P]{
+		Version: currentSchemaVersion[P](),
+		Payload: payload,
+	}
+}
+
+// NewTimeCapsuleFromBase64String decodes the capsule from the base64 string, the
+// payload will be migrated by the upcasters registered with RegisterUpcaster if
+// the capsule was stamped with an older schema version.
 func NewTimeCapsuleFromBase64String[P any](base64Str string) (*TimeCapsule[P], error) {
 	decodedData, err := base64.StdEncoding.DecodeString(base64Str)
 	if err != nil {
 		return nil, err
 	}
 
+	decodedData, err = upcast[P](decodedData)
+	if err != nil {
+		return nil, err
+	}
+
 	var capsule TimeCapsule[P]
 
 	err = json.Unmarshal(decodedData, &capsule)
@@ -39,3 +78,29 @@ func (c *TimeCapsule[any]) Base64String() string {
 
 	return c.base64Str
 }
+
+// ensureID assigns a random ID to the capsule if it has none.
+func (c *TimeCapsule[any]) ensureID() error {
+	if c.ID != "" {
+		return nil
+	}
+
+	capsuleID, err := newCapsuleID()
+	if err != nil {
+		return err
+	}
+
+	c.ID = capsuleID
+	c.base64Str = ""
+
+	return nil
+}
+
+// memberString returns the member of the capsule in the sorted set.
+func (c *TimeCapsule[any]) memberString() string {
+	if c.member != "" {
+		return c.member
+	}
+
+	return c.Base64String()
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -32,3 +33,127 @@ func TestNewTimeCapsuleFromBase64String(t *testing.T) {
 func TestBase64String(t *testing.T) {
 
 }
+
+func TestNewTimeCapsuleFromBase64StringWithUpcasters(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	type reminderV1 struct {
+		Text string `json:"text"`
+	}
+
+	type reminder struct {
+		Title string `json:"title"`
+		Body  string `json:"body"`
+	}
+
+	legacyCapsule := TimeCapsule[reminderV1]{
+		Payload: reminderV1{Text: "hello"},
+	}
+
+	RegisterSchemaVersion[reminder](2)
+	RegisterUpcaster[reminder](0, func(payload json.RawMessage) (json.RawMessage, error) {
+		var v1 reminderV1
+
+		err := json.Unmarshal(payload, &v1)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(map[string]string{"title": v1.Text})
+	})
+	RegisterUpcaster[reminder](1, func(payload json.RawMessage) (json.RawMessage, error) {
+		var v2 map[string]string
+
+		err := json.Unmarshal(payload, &v2)
+		if err != nil {
+			return nil, err
+		}
+
+		v2["body"] = v2["title"] + ", world"
+
+		return json.Marshal(v2)
+	})
+
+	decodedCapsule, err := NewTimeCapsuleFromBase64String[reminder](legacyCapsule.Base64String())
+	require.NoError(err)
+
+	assert.Equal(2, decodedCapsule.Version)
+	assert.Equal(reminder{Title: "hello", Body: "hello, world"}, decodedCapsule.Payload)
+	assert.Equal(legacyCapsule.Base64String(), decodedCapsule.Base64String())
+
+	newCapsule := NewTimeCapsule(reminder{Title: "hi"})
+	assert.Equal(2, newCapsule.Version)
+
+	decodedCapsule, err = NewTimeCapsuleFromBase64String[reminder](newCapsule.Base64String())
+	require.NoError(err)
+	assert.Equal(reminder{Title: "hi"}, decodedCapsule.Payload)
+
+	type missingUpcasterReminder struct{}
+
+	RegisterSchemaVersion[missingUpcasterReminder](1)
+
+	_, err = NewTimeCapsuleFromBase64String[missingUpcasterReminder](NewTimeCapsule(missingUpcasterReminder{}).Base64String())
+	require.NoError(err)
+
+	_, err = NewTimeCapsuleFromBase64String[missingUpcasterReminder]((&TimeCapsule[missingUpcasterReminder]{}).Base64String())
+	require.Error(err)
+}
+
+func TestUpcastWithoutSchemaVersion(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	type unversionedReminder struct{}
+
+	// the header is not read without a schema version registered
+	data, err := upcast[unversionedReminder]([]byte("not json"))
+	require.NoError(err)
+	assert.Equal([]byte("not json"), data)
+
+	type versionedReminder struct{}
+
+	RegisterSchemaVersion[versionedReminder](1)
+
+	_, err = upcast[versionedReminder]([]byte("not json"))
+	require.Error(err)
+}
+
+func TestUpcastCallingBackIntoSchemas(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	type reentrantReminder struct {
+		Title string `json:"title"`
+	}
+
+	type registeredReminder struct{}
+
+	RegisterSchemaVersion[reentrantReminder](1)
+	RegisterUpcaster[reentrantReminder](0, func(payload json.RawMessage) (json.RawMessage, error) {
+		// the upcaster is invoked without holding the lock of the schemas
+		RegisterSchemaVersion[registeredReminder](currentSchemaVersion[reentrantReminder]())
+
+		return json.Marshal(map[string]string{"title": "hello"})
+	})
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		decodedCapsule, err := NewTimeCapsuleFromBase64String[reentrantReminder]((&TimeCapsule[reentrantReminder]{}).Base64String())
+		if !assert.NoError(err) {
+			return
+		}
+
+		assert.Equal(reentrantReminder{Title: "hello"}, decodedCapsule.Payload)
+		assert.Equal(1, currentSchemaVersion[registeredReminder]())
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow("upcaster deadlocked calling back into the schemas")
+	}
+}
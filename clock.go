@@ -0,0 +1,214 @@
+package timecapsule
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Clock tells the time of the digger and the dataloaders, and creates the
+// timers the digger sleeps with. It can be replaced with a FakeClock in tests
+// to control when the capsules are due.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the timer created by Clock, which works like time.Timer since Go
+// 1.23: Reset and Stop discard the pending tick.
+type Timer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+var _ Clock = realClock{}
+
+// realClock is the clock backed by the time package.
+type realClock struct{}
+
+// RealClock returns the clock backed by the time package, which is the default
+// clock of the digger and the dataloaders.
+func RealClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{Timer: time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t *realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+var _ Clock = (*FakeClock)(nil)
+
+// FakeClock is a clock which only moves once Advance or Set is called, the
+// timers fire once the clock reaches their deadline. It is safe for concurrent
+// use.
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+	// Closed and replaced once the timers waiting change
+	changed chan struct{}
+}
+
+// NewFakeClock creates a FakeClock which stands still at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:     now,
+		timers:  make(map[*fakeTimer]struct{}),
+		changed: make(chan struct{}),
+	}
+}
+
+// Now returns the time the clock stands at.
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// NewTimer creates a timer which fires once the clock is advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	timer := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	timer.Reset(d)
+
+	return timer
+}
+
+// Advance moves the clock forward by d, and fires the timers which are due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.setLocked(c.now.Add(d))
+}
+
+// Set moves the clock to now, and fires the timers which are due. The clock
+// never moves backwards, an earlier now is ignored.
+func (c *FakeClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if now.Before(c.now) {
+		return
+	}
+
+	c.setLocked(now)
+}
+
+// Timers returns the number of the timers which are waiting to fire.
+func (c *FakeClock) Timers() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.timers)
+}
+
+// BlockUntil blocks until at least n timers are waiting to fire or ctx is done,
+// which tells that the digger has finished digging and is sleeping again.
+func (c *FakeClock) BlockUntil(ctx context.Context, n int) error {
+	for {
+		c.mutex.Lock()
+		waiting := len(c.timers)
+		changed := c.changed
+		c.mutex.Unlock()
+
+		if waiting >= n {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (c *FakeClock) setLocked(now time.Time) {
+	c.now = now
+
+	for timer := range c.timers {
+		if !timer.deadline.After(now) {
+			timer.fireLocked()
+		}
+	}
+}
+
+// notifyLocked wakes up the callers of BlockUntil.
+func (c *FakeClock) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	active := t.stopLocked()
+
+	t.deadline = t.clock.now.Add(d)
+	if d <= 0 {
+		t.c <- t.deadline
+		return active
+	}
+
+	t.clock.timers[t] = struct{}{}
+	t.clock.notifyLocked()
+
+	return active
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	return t.stopLocked()
+}
+
+// stopLocked stops the timer and discards the pending tick, it reports whether
+// the timer was waiting to fire.
+func (t *fakeTimer) stopLocked() bool {
+	select {
+	case <-t.c:
+	default:
+	}
+
+	_, active := t.clock.timers[t]
+	if active {
+		delete(t.clock.timers, t)
+		t.clock.notifyLocked()
+	}
+
+	return active
+}
+
+func (t *fakeTimer) fireLocked() {
+	delete(t.clock.timers, t)
+	t.clock.notifyLocked()
+
+	t.c <- t.deadline
+}
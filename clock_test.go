@@ -0,0 +1,111 @@
+package timecapsule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestFakeClock(t *testing.T) {
+	t.Run("Advance", func(t *testing.T) {
+		assert := assert.New(t)
+
+		now := time.Now()
+		clock := NewFakeClock(now)
+		assert.Equal(now, clock.Now())
+
+		clock.Advance(time.Minute)
+		assert.Equal(now.Add(time.Minute), clock.Now())
+
+		clock.Set(now)
+		assert.Equal(now.Add(time.Minute), clock.Now())
+
+		clock.Set(now.Add(time.Hour))
+		assert.Equal(now.Add(time.Hour), clock.Now())
+	})
+
+	t.Run("Timer", func(t *testing.T) {
+		assert := assert.New(t)
+
+		now := time.Now()
+		clock := NewFakeClock(now)
+
+		timer := clock.NewTimer(time.Second)
+		assert.Equal(1, clock.Timers())
+
+		clock.Advance(999 * time.Millisecond)
+		assert.Empty(timer.C())
+
+		clock.Advance(time.Millisecond)
+		assert.Equal(0, clock.Timers())
+
+		select {
+		case firedAt := <-timer.C():
+			assert.Equal(now.Add(time.Second), firedAt)
+		default:
+			assert.Fail("timer should fire once the clock reaches its deadline")
+		}
+
+		// fires only once
+		clock.Advance(time.Hour)
+		assert.Empty(timer.C())
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		assert := assert.New(t)
+
+		clock := NewFakeClock(time.Now())
+
+		timer := clock.NewTimer(time.Second)
+		clock.Advance(time.Second)
+
+		// the pending tick is discarded
+		assert.False(timer.Reset(time.Minute))
+		assert.Empty(timer.C())
+		assert.Equal(1, clock.Timers())
+
+		assert.True(timer.Reset(0))
+		assert.Len(timer.C(), 1)
+		assert.Equal(0, clock.Timers())
+
+		assert.False(timer.Stop())
+		assert.Empty(timer.C())
+	})
+
+	t.Run("Stop", func(t *testing.T) {
+		assert := assert.New(t)
+
+		clock := NewFakeClock(time.Now())
+
+		timer := clock.NewTimer(time.Second)
+		assert.True(timer.Stop())
+		assert.Equal(0, clock.Timers())
+
+		clock.Advance(time.Minute)
+		assert.Empty(timer.C())
+	})
+
+	t.Run("BlockUntil", func(t *testing.T) {
+		require := require.New(t)
+
+		clock := NewFakeClock(time.Now())
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			clock.NewTimer(time.Second)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		require.NoError(clock.BlockUntil(ctx, 1))
+
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		require.ErrorIs(clock.BlockUntil(ctx, 2), context.DeadlineExceeded)
+	})
+}
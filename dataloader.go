@@ -1,11 +1,27 @@
 package timecapsule
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"golang.org/x/net/context"
 )
 
+// ErrFatal is wrapped by the errors of dataloaders which can not be recovered
+// by digging again, such as a closed client, the digger stops once Dig returns
+// such an error.
+var ErrFatal = errors.New("fatal dataloader error")
+
+// ErrQuarantined is wrapped by the errors of Dig when the dug out capsule can
+// not be decoded and has been moved to quarantine, digging again digs the next
+// capsule.
+var ErrQuarantined = errors.New("moved to quarantine")
+
+// Dataloader is the storage of the capsules of a topic. The dataloaders may
+// implement the optional interfaces, such as Releaser and Subscriber, to enable
+// more features of the digger, which falls back to the behavior without them
+// otherwise.
 type Dataloader[P any] interface {
 	Type() string
 
@@ -16,3 +32,202 @@ type Dataloader[P any] interface {
 	Destroy(ctx context.Context, capsule *TimeCapsule[P]) error
 	DestroyAll(ctx context.Context) error
 }
+
+// CapsuleBurier is implemented by the dataloaders which are able to bury a
+// capsule with fields other than the payload, such as Kind, it is required by
+// TimeCapsuleDigger.BuryCapsule and FailurePolicyRetry.
+type CapsuleBurier[P any] interface {
+	// BuryCapsule buries the capsule until the given timestamp.
+	BuryCapsule(ctx context.Context, capsule *TimeCapsule[P], utilUnixMilliTimestamp int64) error
+}
+
+// BulkBurier is implemented by the dataloaders which are able to bury capsules
+// in bulk, TimeCapsuleDigger.BuryMany buries the entries one by one otherwise.
+type BulkBurier[P any] interface {
+	// BuryMany buries the payloads of the entries, errs reports the error of
+	// each entry in order, and err summarizes them.
+	BuryMany(ctx context.Context, entries []Entry[P]) (errs []error, err error)
+}
+
+// Prefetcher is implemented by the dataloaders which are able to lease capsules
+// before they are due, see TimeCapsuleDiggerOption.PrefetchWindow.
+type Prefetcher[P any] interface {
+	// DigUtil digs the earliest capsule which is due until the given timestamp.
+	DigUtil(ctx context.Context, utilUnixMilliTimestamp int64) (capsules *TimeCapsule[P], err error)
+}
+
+// SchedulePeeker is implemented by the dataloaders which are able to tell when
+// the earliest capsule is due, the digger sleeps until then with
+// TimeCapsuleDiggerOption.MaxIdleInterval, or for the dig interval otherwise.
+type SchedulePeeker interface {
+	// NextScheduledAt returns the timestamp the earliest capsule is due at, ok
+	// is false if there are no capsules.
+	NextScheduledAt(ctx context.Context) (utilUnixMilliTimestamp int64, ok bool, err error)
+}
+
+// ServerClock is implemented by the dataloaders which are able to tell the time
+// the scores of the capsules are compared against, such as the TIME of Redis
+// with DataloaderOption.ServerTime. The digger tells how long to sleep and
+// whether the prefetched capsules are due by it, by its own clock otherwise.
+type ServerClock interface {
+	// ServerNow returns the unix milli timestamp the scores are compared
+	// against now.
+	ServerNow(ctx context.Context) (unixMilliTimestamp int64, err error)
+}
+
+// Subscriber is implemented by the dataloaders which are able to notify the
+// diggers once a capsule is buried, the digger wakes up by polling otherwise.
+type Subscriber interface {
+	// Subscribe returns the channel of the timestamps the buried capsules are
+	// due at, when they are earlier than the earliest buried capsule. The
+	// channel is closed once ctx is done.
+	Subscribe(ctx context.Context) (notifications <-chan int64, err error)
+}
+
+// Releaser is implemented by the dataloaders which lease the dug out capsules,
+// the capsules which the digger gives up are handed back to be dug out again.
+// Without it, such capsules are left to the dataloader.
+type Releaser[P any] interface {
+	// Release hands the leased capsule back to be dug out again.
+	Release(ctx context.Context, capsule *TimeCapsule[P]) error
+}
+
+// DeadLetterer is implemented by the dataloaders which are able to keep the
+// capsules which could not be handled, such capsules are logged and dropped
+// otherwise.
+type DeadLetterer[P any] interface {
+	// DeadLetter moves the capsule to the dead letters with the reason.
+	DeadLetter(ctx context.Context, capsule *TimeCapsule[P], reason error) error
+}
+
+// Entry is a payload to be buried by BuryMany.
+type Entry[P any] struct {
+	Payload P
+	// UtilUnixMilliTimestamp is the unix milli timestamp the payload is buried
+	// until.
+	UtilUnixMilliTimestamp int64
+}
+
+// buryManyBatchSize is the max number of entries buried by BuryMany in one
+// script.
+const buryManyBatchSize = 1000
+
+// buryManyError summarizes the errors of the entries which failed to be buried
+// by BuryMany, nil if all the entries were buried.
+func buryManyError(errs []error) error {
+	var firstErr error
+
+	failed := 0
+
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		failed++
+	}
+
+	if failed == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("failed to bury %d of %d entries: %w", failed, len(errs), firstErr)
+}
+
+// DataloaderOption is the option for the Redis based dataloaders.
+type DataloaderOption struct {
+	// ClaimCheckThreshold is the size in bytes of an encoded capsule above which
+	// the capsule will be stored in a separate hash keyed by capsule ID, leaving
+	// only a small reference in the sorted set. Zero disables claim-check.
+	ClaimCheckThreshold int
+	// LeaseDuration is how long a dug out capsule is leased to the digger, the
+	// capsule will be handed back to be dug out again if it is neither
+	// destroyed nor released before the lease expires.
+	LeaseDuration time.Duration
+	// Shards splits the topic across the given number of sorted sets to spread
+	// the load over the nodes of Redis Cluster, the capsules are routed to the
+	// shards by the hash of their IDs, and dug out across the shards by the
+	// earliest due time. The pause flag and the leadership stay with the topic.
+	// Once capsules are buried, the number of shards should only be changed
+	// along with Reshard to migrate the pending capsules. Zero or one disables
+	// sharding.
+	Shards int
+	// Clock tells the time capsules are buried for and dug out at, it should
+	// be the same clock as the one of the digger.
+	Clock Clock
+	// ServerTime makes Dig compare the scores against the TIME of Redis
+	// instead of the clock of the dataloader, so that the diggers with skewed
+	// clocks share the clock of Redis. The due timestamp of DigUtil and the
+	// lease deadline are shifted by the skew between the clocks, and the digger
+	// sleeps until the capsules are due by the clock of Redis as well. BuryFor
+	// and the other timestamps still follow the clock of the dataloader.
+	ServerTime bool
+}
+
+// DefaultDataloaderOption returns the default option for the Redis based dataloaders.
+func DefaultDataloaderOption() DataloaderOption {
+	return DataloaderOption{
+		LeaseDuration: 5 * time.Minute,
+		Clock:         RealClock(),
+	}
+}
+
+// mergeDataloaderOption merges the options.
+func mergeDataloaderOption(original *DataloaderOption, options ...DataloaderOption) DataloaderOption {
+	if len(options) == 0 {
+		return *original
+	}
+
+	option := options[0]
+	if option.ClaimCheckThreshold > 0 {
+		original.ClaimCheckThreshold = option.ClaimCheckThreshold
+	}
+	if option.LeaseDuration > 0 {
+		original.LeaseDuration = option.LeaseDuration
+	}
+	if option.Shards > 0 {
+		original.Shards = option.Shards
+	}
+	if option.Clock != nil {
+		original.Clock = option.Clock
+	}
+	if option.ServerTime {
+		original.ServerTime = true
+	}
+
+	return *original
+}
+
+// QuarantinedCapsule is a capsule which was dug out but could not be decoded,
+// it is moved to the quarantine of the topic instead of being lost.
+type QuarantinedCapsule struct {
+	// Member is the raw member of the capsule in the sorted set, which is
+	// either the base64 string of the capsule or a claim-check reference.
+	Member string `json:"-"`
+	// Content is the base64 string of the capsule, empty if the payload of the
+	// claim-check reference is missing.
+	Content string `json:"-"`
+	// ScheduledAt is the unix milli timestamp the capsule was buried until.
+	ScheduledAt int64 `json:"scheduledAt"`
+	// Error is the decode error.
+	Error string `json:"error"`
+	// QuarantinedAt is the unix milli timestamp the capsule was quarantined at.
+	QuarantinedAt int64 `json:"quarantinedAt"`
+}
+
+// DeadLetteredCapsule is a capsule which could not be handled and was moved to
+// the dead letters of the topic.
+type DeadLetteredCapsule struct {
+	// Member is the raw member of the capsule in the sorted set, which is
+	// either the base64 string of the capsule or a claim-check reference.
+	Member string `json:"-"`
+	// Content is the base64 string of the capsule.
+	Content string `json:"content,omitempty"`
+	// Reason is the reason why the capsule was dead lettered.
+	Reason string `json:"reason"`
+	// DeadLetteredAt is the unix milli timestamp the capsule was dead lettered at.
+	DeadLetteredAt int64 `json:"deadLetteredAt"`
+}
@@ -0,0 +1,770 @@
+package timecapsule
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// memorySubscriptionBufferSize is the size of the buffer of the notifications
+// of a subscription, the notifications are dropped once the buffer is full.
+const memorySubscriptionBufferSize = 100
+
+// MemoryDataloader is a dataloader that keeps the capsules in memory with a
+// min-heap ordered by the scheduled timestamps, it is safe for concurrent use
+// by the diggers of the same process.
+//
+// It behaves the same as the Redis based dataloaders, including leases, the
+// pause flag, leader election, shard ownership, claim-check references,
+// quarantine and dead letters, so that it can stand in for them in tests and
+// single-process deployments. The capsules are kept encoded as they are in
+// Redis, which is why identical capsules are deduplicated and the upcasters
+// apply. With DataloaderOption.Shards, the capsules are routed to the shards by
+// their IDs the same way, each shard being a heap of its own.
+type MemoryDataloader[P any] struct {
+	option DataloaderOption
+
+	mutex sync.Mutex
+	// Buried capsules of each shard ordered by the scheduled timestamps,
+	// indexed by member
+	buried        []memoryHeap
+	buriedMembers map[string]*memoryEntry
+	// Encoded capsules of the claim-check references by capsule ID
+	payloads map[string]string
+	// Lease deadlines of the dug out capsules by member
+	inFlight map[string]int64
+	// Quarantine and dead letter records by member
+	quarantine  map[string]string
+	deadLetters map[string]string
+	paused      bool
+	// Subscriptions to the notifications of buried capsules
+	subscriptions map[chan int64]struct{}
+
+	leaderHolderID      string
+	leaderFencingToken  int64
+	leaderLeaseDeadline time.Time
+	fencingToken        int64
+	// Membership deadlines by member ID
+	members map[string]time.Time
+}
+
+// static check implementation.
+var _ Dataloader[any] = (*MemoryDataloader[any])(nil)
+var _ CapsuleBurier[any] = (*MemoryDataloader[any])(nil)
+var _ BulkBurier[any] = (*MemoryDataloader[any])(nil)
+var _ Prefetcher[any] = (*MemoryDataloader[any])(nil)
+var _ SchedulePeeker = (*MemoryDataloader[any])(nil)
+var _ Subscriber = (*MemoryDataloader[any])(nil)
+var _ Releaser[any] = (*MemoryDataloader[any])(nil)
+var _ DeadLetterer[any] = (*MemoryDataloader[any])(nil)
+var _ LeaderElector = (*MemoryDataloader[any])(nil)
+var _ ShardCoordinator = (*MemoryDataloader[any])(nil)
+
+// NewMemoryDataloader creates a new MemoryDataloader.
+func NewMemoryDataloader[P any](options ...DataloaderOption) *MemoryDataloader[P] {
+	dataloader := &MemoryDataloader[P]{
+		option:        DefaultDataloaderOption(),
+		buriedMembers: make(map[string]*memoryEntry),
+		payloads:      make(map[string]string),
+		inFlight:      make(map[string]int64),
+		quarantine:    make(map[string]string),
+		deadLetters:   make(map[string]string),
+		subscriptions: make(map[chan int64]struct{}),
+		members:       make(map[string]time.Time),
+	}
+
+	mergeDataloaderOption(&dataloader.option, options...)
+
+	dataloader.buried = make([]memoryHeap, max(dataloader.option.Shards, 1))
+
+	return dataloader
+}
+
+// Type returns the type of the dataloader.
+func (m *MemoryDataloader[P]) Type() string {
+	return "Memory"
+}
+
+// BuryFor buries the payload into memory for the given duration.
+func (m *MemoryDataloader[P]) BuryFor(ctx context.Context, payload P, forTimeRange time.Duration) error {
+	utilUnixMilliTimestamp := m.option.Clock.Now().UTC().Add(forTimeRange).UnixMilli()
+	return m.BuryUtil(ctx, payload, utilUnixMilliTimestamp)
+}
+
+// BuryUtil buries the payload into memory util the given timestamp, the
+// subscribers will be notified if it is earlier than the earliest capsule, see
+// Subscribe.
+func (m *MemoryDataloader[P]) BuryUtil(ctx context.Context, payload P, utilUnixMilliTimestamp int64) error {
+	return m.BuryCapsule(ctx, NewTimeCapsule(payload), utilUnixMilliTimestamp)
+}
+
+// BuryCapsule buries the capsule into memory util the given timestamp, it
+// allows to bury a capsule with fields other than the payload, such as Kind.
+//
+// The capsule larger than DataloaderOption.ClaimCheckThreshold is buried as a
+// claim-check reference, and a random ID is assigned to the capsule if it has
+// none when the topic is sharded, the same as the Redis based dataloaders.
+func (m *MemoryDataloader[P]) BuryCapsule(ctx context.Context, capsule *TimeCapsule[P], utilUnixMilliTimestamp int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	earlier, err := m.buryCapsule(capsule, utilUnixMilliTimestamp)
+	if err != nil {
+		return err
+	}
+	if earlier {
+		m.publish(utilUnixMilliTimestamp)
+	}
+
+	return nil
+}
+
+// BuryMany buries the payloads of the entries in bulk, it returns the errors of
+// the entries by index, nil for the entries which were buried, and an error
+// summarizing the failed entries if there is any.
+func (m *MemoryDataloader[P]) BuryMany(ctx context.Context, entries []Entry[P]) ([]error, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	errs := make([]error, len(entries))
+	if len(entries) == 0 {
+		return errs, nil
+	}
+
+	earliest := int64(0)
+	published := false
+
+	for i, entry := range entries {
+		var earlier bool
+
+		earlier, errs[i] = m.buryCapsule(NewTimeCapsule(entry.Payload), entry.UtilUnixMilliTimestamp)
+		if earlier && (!published || entry.UtilUnixMilliTimestamp < earliest) {
+			earliest = entry.UtilUnixMilliTimestamp
+			published = true
+		}
+	}
+	if published {
+		m.publish(earliest)
+	}
+
+	return errs, buryManyError(errs)
+}
+
+// buryCapsule buries the capsule or its claim-check reference, and reports
+// whether it is buried earlier than the earliest member of its shard. The
+// caller should hold the mutex.
+func (m *MemoryDataloader[P]) buryCapsule(capsule *TimeCapsule[P], utilUnixMilliTimestamp int64) (bool, error) {
+	if len(m.buried) > 1 {
+		err := capsule.ensureID()
+		if err != nil {
+			return false, err
+		}
+	}
+	if m.option.ClaimCheckThreshold <= 0 || len(capsule.Base64String()) <= m.option.ClaimCheckThreshold {
+		return m.bury(capsule.Base64String(), utilUnixMilliTimestamp), nil
+	}
+
+	err := capsule.ensureID()
+	if err != nil {
+		return false, err
+	}
+
+	m.payloads[capsule.ID] = capsule.Base64String()
+
+	return m.bury(claimCheckReference(capsule.ID), utilUnixMilliTimestamp), nil
+}
+
+// bury adds the member with the score, or updates the score if the member is
+// already buried, the same as ZADD. It reports whether the score is earlier
+// than the earliest member the shard had. The caller should hold the mutex.
+func (m *MemoryDataloader[P]) bury(member string, score int64) bool {
+	entry, ok := m.buriedMembers[member]
+	if !ok {
+		entry = &memoryEntry{member: member, shard: m.shardOf(member)}
+	}
+
+	earlier := m.buried[entry.shard].Len() == 0 || score < m.buried[entry.shard][0].score
+	entry.score = score
+
+	if ok {
+		heap.Fix(&m.buried[entry.shard], entry.index)
+		return earlier
+	}
+
+	m.buriedMembers[member] = entry
+	heap.Push(&m.buried[entry.shard], entry)
+
+	return earlier
+}
+
+// unbury removes the member if it is buried, the same as ZREM. The caller
+// should hold the mutex.
+func (m *MemoryDataloader[P]) unbury(member string) {
+	entry, ok := m.buriedMembers[member]
+	if !ok {
+		return
+	}
+
+	delete(m.buriedMembers, member)
+	heap.Remove(&m.buried[entry.shard], entry.index)
+}
+
+// shardOf returns the shard the member is routed to by the ID of its capsule,
+// the members which fail to decode are routed to shard 0.
+func (m *MemoryDataloader[P]) shardOf(member string) int {
+	if len(m.buried) == 1 {
+		return 0
+	}
+
+	capsuleID, ok := claimCheckCapsuleID(member)
+	if !ok {
+		capsule, err := NewTimeCapsuleFromBase64String[P](member)
+		if err != nil {
+			return 0
+		}
+
+		capsuleID = capsule.ID
+	}
+
+	return shardOf(capsuleID, len(m.buried))
+}
+
+// earliestShard returns the shard with the earliest buried member among the
+// shards to be dug by ctx, ok is false if they are all empty. The caller should
+// hold the mutex.
+func (m *MemoryDataloader[P]) earliestShard(ctx context.Context) (int, bool) {
+	earliest := 0
+	found := false
+
+	for _, shard := range shardsFromContext(ctx, len(m.buried)) {
+		if shard >= len(m.buried) || m.buried[shard].Len() == 0 {
+			continue
+		}
+		if !found || m.buried[shard][0].before(m.buried[earliest][0]) {
+			earliest = shard
+			found = true
+		}
+	}
+
+	return earliest, found
+}
+
+// publish notifies the subscribers of the scheduled timestamp of a buried
+// capsule. The caller should hold the mutex.
+func (m *MemoryDataloader[P]) publish(utilUnixMilliTimestamp int64) {
+	for subscription := range m.subscriptions {
+		select {
+		case subscription <- utilUnixMilliTimestamp:
+		default:
+		}
+	}
+}
+
+// Dig digs the time capsule from the dataloader, see DigUtil.
+func (m *MemoryDataloader[P]) Dig(ctx context.Context) (*TimeCapsule[P], error) {
+	return m.DigUtil(ctx, m.option.Clock.Now().UTC().UnixMilli())
+}
+
+// DigUtil digs the earliest time capsule which is due util the given timestamp,
+// with the same flow as the Redis based dataloaders: the capsules with expired
+// leases are handed back first, then the earliest capsule is leased to the
+// caller until DataloaderOption.LeaseDuration elapses. Nothing is dug out while
+// the topic is paused, and ErrNotLeader is returned if ctx carries a fencing
+// token which is not the one of the current leader.
+//
+// Capsules which fail to decode are moved to the quarantine instead of being
+// lost, see Quarantined.
+func (m *MemoryDataloader[P]) DigUtil(ctx context.Context, utilUnixMilliTimestamp int64) (*TimeCapsule[P], error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.option.Clock.Now().UTC()
+
+	fencingToken, ok := fencingTokenFromContext(ctx)
+	if ok && (!m.leading(now) || m.leaderFencingToken != fencingToken) {
+		return nil, ErrNotLeader
+	}
+	if m.paused {
+		return nil, nil
+	}
+
+	for member, leaseDeadline := range m.inFlight {
+		if leaseDeadline <= now.UnixMilli() {
+			delete(m.inFlight, member)
+			m.bury(member, now.UnixMilli())
+		}
+	}
+
+	shard, ok := m.earliestShard(ctx)
+	if !ok || m.buried[shard][0].score > utilUnixMilliTimestamp {
+		return nil, nil
+	}
+
+	head := m.buried[shard][0]
+	leaseDeadline := now.Add(m.option.LeaseDuration).UnixMilli()
+
+	m.unbury(head.member)
+	m.inFlight[head.member] = leaseDeadline
+
+	content := head.member
+	if capsuleID, ok := claimCheckCapsuleID(head.member); ok {
+		content, ok = m.payloads[capsuleID]
+		if !ok {
+			return nil, m.quarantineLocked(head.member, head.score, fmt.Errorf("payload of claim-check reference %s is missing", head.member), now)
+		}
+	}
+
+	capsule, err := NewTimeCapsuleFromBase64String[P](content)
+	if err != nil {
+		return nil, m.quarantineLocked(head.member, head.score, err, now)
+	}
+
+	capsule.member = head.member
+	capsule.leaseDeadline = leaseDeadline
+	capsule.DugOutAt = now.UnixMilli()
+	capsule.ScheduledAt = head.score
+
+	return capsule, nil
+}
+
+// quarantineLocked moves the leased member which failed to decode to the
+// quarantine. The caller should hold the mutex.
+func (m *MemoryDataloader[P]) quarantineLocked(member string, scheduledAt int64, decodeErr error, now time.Time) error {
+	delete(m.inFlight, member)
+	m.quarantine[member] = newQuarantineRecord(scheduledAt, decodeErr, now.UnixMilli())
+
+	return fmt.Errorf("failed to decode capsule, %w: %w", ErrQuarantined, decodeErr)
+}
+
+// Quarantined lists the capsules which failed to decode when digging.
+func (m *MemoryDataloader[P]) Quarantined(ctx context.Context) ([]*QuarantinedCapsule, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	quarantinedCapsules := make([]*QuarantinedCapsule, 0, len(m.quarantine))
+
+	for member, record := range m.quarantine {
+		quarantinedCapsule, err := parseQuarantineRecord(member, record)
+		if err != nil {
+			return nil, err
+		}
+
+		capsuleID, ok := claimCheckCapsuleID(member)
+		if ok {
+			quarantinedCapsule.Content = m.payloads[capsuleID]
+		}
+
+		quarantinedCapsules = append(quarantinedCapsules, quarantinedCapsule)
+	}
+
+	return quarantinedCapsules, nil
+}
+
+// DeleteQuarantined deletes the quarantined capsule of the given member, along
+// with the payload of the claim-check reference.
+func (m *MemoryDataloader[P]) DeleteQuarantined(ctx context.Context, member string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.quarantine, member)
+
+	capsuleID, ok := claimCheckCapsuleID(member)
+	if ok {
+		delete(m.payloads, capsuleID)
+	}
+
+	return nil
+}
+
+// NextScheduledAt returns the timestamp when the earliest capsule is scheduled
+// to be dug out, ok is false if there is no capsule buried. Only the shards
+// owned by the digger are peeked when the topic is sharded.
+func (m *MemoryDataloader[P]) NextScheduledAt(ctx context.Context) (int64, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	shard, ok := m.earliestShard(ctx)
+	if !ok {
+		return 0, false, nil
+	}
+
+	return m.buried[shard][0].score, true, nil
+}
+
+// Subscribe subscribes to the notifications of buried capsules, the scheduled
+// timestamps of the capsules buried earlier than the earliest buried one will
+// be sent to the returned channel, which will be closed once ctx is done. The
+// notifications are dropped while the buffer of the channel is full.
+func (m *MemoryDataloader[P]) Subscribe(ctx context.Context) (<-chan int64, error) {
+	notifications := make(chan int64, memorySubscriptionBufferSize)
+
+	m.mutex.Lock()
+	m.subscriptions[notifications] = struct{}{}
+	m.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		delete(m.subscriptions, notifications)
+		close(notifications)
+	}()
+
+	return notifications, nil
+}
+
+// Release hands the leased capsule back to be dug out again once it is due,
+// which is immediately unless the capsule was leased before it is due, it does
+// nothing if the capsule is no longer leased.
+func (m *MemoryDataloader[P]) Release(ctx context.Context, capsule *TimeCapsule[P]) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	member := capsule.memberString()
+
+	_, ok := m.inFlight[member]
+	if !ok {
+		return nil
+	}
+
+	delete(m.inFlight, member)
+	m.bury(member, releaseScore(capsule, m.option.Clock.Now().UTC().UnixMilli()))
+
+	return nil
+}
+
+// Destroy destroys the given capsule, whether it is buried or dug out, along
+// with the payload of its claim-check reference.
+func (m *MemoryDataloader[P]) Destroy(ctx context.Context, capsule *TimeCapsule[P]) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.destroy(capsule.memberString())
+
+	return nil
+}
+
+// destroy removes the member and the payload of the claim-check reference. The
+// caller should hold the mutex.
+func (m *MemoryDataloader[P]) destroy(member string) {
+	m.unbury(member)
+	delete(m.inFlight, member)
+
+	capsuleID, ok := claimCheckCapsuleID(member)
+	if ok {
+		delete(m.payloads, capsuleID)
+	}
+}
+
+// MemoryTxHandlerFunc is the function to handle the capsules dug out by the
+// digger, which returns its writes as commit instead of applying them, see
+// TxHandlerFunc.
+type MemoryTxHandlerFunc[P any] func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) (commit func(), err error)
+
+// TxHandlerFunc returns the handler to be set by SetHandlerFunc of the digger,
+// which runs commit returned by txHandlerFunc and destroys the capsule
+// atomically with the mutex of the dataloader held, so that the capsule is
+// never dug out again once commit has run. Nothing is committed if
+// txHandlerFunc returns an error. commit must not call the dataloader.
+func (m *MemoryDataloader[P]) TxHandlerFunc(txHandlerFunc MemoryTxHandlerFunc[P]) HandlerFunc[P] {
+	return func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error {
+		commit, err := txHandlerFunc(ctx, digger, capsule)
+		if err != nil {
+			return err
+		}
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		if commit != nil {
+			commit()
+		}
+
+		m.destroy(capsule.memberString())
+		capsule.acked = true
+
+		return nil
+	}
+}
+
+// DestroyAll destroys all the buried and dug out capsules, the quarantine and
+// the dead letters are kept.
+func (m *MemoryDataloader[P]) DestroyAll(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.buried = make([]memoryHeap, len(m.buried))
+	m.buriedMembers = make(map[string]*memoryEntry)
+	m.payloads = make(map[string]string)
+	m.inFlight = make(map[string]int64)
+
+	return nil
+}
+
+// DeadLetter moves the capsule which could not be handled to the dead letters.
+func (m *MemoryDataloader[P]) DeadLetter(ctx context.Context, capsule *TimeCapsule[P], reason error) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	member := capsule.memberString()
+	m.deadLetters[member] = newDeadLetterRecord(member, capsule.Base64String(), reason, m.option.Clock.Now().UTC().UnixMilli())
+
+	return nil
+}
+
+// DeadLetters lists the capsules which were dead lettered.
+func (m *MemoryDataloader[P]) DeadLetters(ctx context.Context) ([]*DeadLetteredCapsule, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	deadLetteredCapsules := make([]*DeadLetteredCapsule, 0, len(m.deadLetters))
+
+	for member, record := range m.deadLetters {
+		deadLetteredCapsule, err := parseDeadLetterRecord(member, record)
+		if err != nil {
+			return nil, err
+		}
+
+		deadLetteredCapsules = append(deadLetteredCapsules, deadLetteredCapsule)
+	}
+
+	return deadLetteredCapsules, nil
+}
+
+// DeleteDeadLetter deletes the dead lettered capsule of the given member.
+func (m *MemoryDataloader[P]) DeleteDeadLetter(ctx context.Context, member string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.deadLetters, member)
+
+	return nil
+}
+
+// Pause pauses the topic for all the diggers digging from the dataloader, no
+// capsules will be dug out until Resume is called, burying capsules keeps
+// working.
+func (m *MemoryDataloader[P]) Pause(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.paused = true
+
+	return nil
+}
+
+// Resume resumes the topic paused by Pause.
+func (m *MemoryDataloader[P]) Resume(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.paused = false
+
+	return nil
+}
+
+// Paused reports whether the topic is paused.
+func (m *MemoryDataloader[P]) Paused(ctx context.Context) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.paused, nil
+}
+
+// AcquireLeadership acquires the leadership of the topic for the holder, or
+// renews it if the holder is already the leader. The leadership lapses after the
+// lease duration unless it is renewed, a new fencing token is issued every time
+// the leadership is acquired.
+func (m *MemoryDataloader[P]) AcquireLeadership(ctx context.Context, holderID string, leaseDuration time.Duration) (int64, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.option.Clock.Now().UTC()
+
+	if m.leading(now) && m.leaderHolderID != holderID {
+		return 0, false, nil
+	}
+	if !m.leading(now) {
+		m.fencingToken++
+		m.leaderHolderID = holderID
+		m.leaderFencingToken = m.fencingToken
+	}
+
+	m.leaderLeaseDeadline = now.Add(leaseDuration)
+
+	return m.leaderFencingToken, true, nil
+}
+
+// ResignLeadership gives up the leadership of the topic if the holder is the
+// leader, so that another digger can take over without waiting for the lease to
+// lapse.
+func (m *MemoryDataloader[P]) ResignLeadership(ctx context.Context, holderID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.leading(m.option.Clock.Now().UTC()) && m.leaderHolderID == holderID {
+		m.leaderHolderID = ""
+		m.leaderFencingToken = 0
+		m.leaderLeaseDeadline = time.Time{}
+	}
+
+	return nil
+}
+
+// leading reports whether there is a leader whose lease has not lapsed. The
+// caller should hold the mutex.
+func (m *MemoryDataloader[P]) leading(now time.Time) bool {
+	return m.leaderHolderID != "" && now.Before(m.leaderLeaseDeadline)
+}
+
+// Heartbeat registers the member of the topic or extends its membership for the
+// TTL, and returns the shards owned by the member. The shards are distributed
+// among the live members when the topic is sharded, otherwise the single shard
+// 0 is owned by all the members.
+func (m *MemoryDataloader[P]) Heartbeat(ctx context.Context, memberID string, ttl time.Duration) ([]int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.option.Clock.Now().UTC()
+
+	for member, deadline := range m.members {
+		if deadline.Before(now) {
+			delete(m.members, member)
+		}
+	}
+
+	m.members[memberID] = now.Add(ttl)
+
+	if len(m.buried) == 1 {
+		return []int{0}, nil
+	}
+
+	members := make([]string, 0, len(m.members))
+	for member := range m.members {
+		members = append(members, member)
+	}
+
+	return ownedShards(members, memberID, len(m.buried)), nil
+}
+
+// Leave removes the member of the topic.
+func (m *MemoryDataloader[P]) Leave(ctx context.Context, memberID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.members, memberID)
+
+	return nil
+}
+
+// Reshard changes the number of the shards of the topic to toShards, and moves
+// the buried capsules to the shards they are routed to in the new layout, it
+// returns the number of the moved capsules. Unlike the Redis based
+// dataloaders, which migrate the capsules of the layout of fromShards into the
+// layout of DataloaderOption.Shards, the capsules only live in this
+// dataloader, so the layout is changed in place. A random ID is assigned to the
+// capsules which have none, and the leased capsules are routed once they are
+// handed back. The diggers pick the new shards up on their next heartbeats.
+func (m *MemoryDataloader[P]) Reshard(ctx context.Context, toShards int) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entries := make([]*memoryEntry, 0, len(m.buriedMembers))
+	for _, entry := range m.buriedMembers {
+		entries = append(entries, entry)
+	}
+
+	m.option.Shards = toShards
+	m.buried = make([]memoryHeap, max(toShards, 1))
+	m.buriedMembers = make(map[string]*memoryEntry, len(entries))
+
+	moved := 0
+	errs := make([]error, 0)
+
+	for _, entry := range entries {
+		member := entry.member
+
+		capsule, err := NewTimeCapsuleFromBase64String[P](member)
+		if err == nil && capsule.ID == "" && len(m.buried) > 1 {
+			err = capsule.ensureID()
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				member = capsule.Base64String()
+			}
+		}
+
+		m.bury(member, entry.score)
+
+		if m.buriedMembers[member].shard != entry.shard {
+			moved++
+		}
+	}
+
+	return moved, errors.Join(errs...)
+}
+
+// memoryEntry is a buried member of MemoryDataloader.
+type memoryEntry struct {
+	member string
+	score  int64
+	// shard the member is routed to, and the index of the entry in the heap of
+	// the shard, maintained by memoryHeap
+	shard int
+	index int
+}
+
+// before reports whether the entry is ordered before the other one, by the
+// scores and then the members, the same as the order of a Redis sorted set.
+func (e *memoryEntry) before(other *memoryEntry) bool {
+	if e.score != other.score {
+		return e.score < other.score
+	}
+
+	return e.member < other.member
+}
+
+// memoryHeap is the min-heap of the buried members, ordered by the scores and
+// then the members, the same as the order of a Redis sorted set.
+type memoryHeap []*memoryEntry
+
+var _ heap.Interface = (*memoryHeap)(nil)
+
+func (h memoryHeap) Len() int {
+	return len(h)
+}
+
+func (h memoryHeap) Less(i, j int) bool {
+	return h[i].before(h[j])
+}
+
+func (h memoryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *memoryHeap) Push(x any) {
+	entry, ok := x.(*memoryEntry)
+	if !ok {
+		panic(errors.New("memoryHeap: invalid entry"))
+	}
+
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *memoryHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+
+	return entry
+}
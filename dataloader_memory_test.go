@@ -0,0 +1,694 @@
+package timecapsule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryDataloader(t *testing.T) {
+	t.Run("Type", func(t *testing.T) {
+		assert.Equal(t, "Memory", NewMemoryDataloader[any]().Type())
+	})
+
+	t.Run("BuryFor", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+
+		err := d.BuryFor(context.Background(), "test", time.Minute)
+		require.NoError(err)
+
+		scheduledAt, ok, err := d.NextScheduledAt(context.Background())
+		require.NoError(err)
+		require.True(ok)
+
+		now := time.Now().UTC()
+		assert.GreaterOrEqual(now.Add(time.Minute).UnixMilli(), scheduledAt)
+		assert.Less(now.UnixMilli(), scheduledAt)
+	})
+
+	t.Run("Dig", func(t *testing.T) {
+		t.Run("DugOutCorrectCapsule", func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			d := NewMemoryDataloader[any]()
+
+			err := d.BuryUtil(context.Background(), "shouldBeDugOut", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+			require.NoError(err)
+
+			err = d.BuryUtil(context.Background(), "shouldNotBeDugOut", time.Now().UTC().Add(time.Minute).UnixMilli())
+			require.NoError(err)
+
+			capsule, err := d.Dig(context.Background())
+			require.NoError(err)
+			require.NotNil(capsule)
+
+			now := time.Now().UTC()
+
+			assert.Equal("shouldBeDugOut", capsule.Payload)
+			assert.GreaterOrEqual(now.UnixMilli(), capsule.DugOutAt)
+
+			capsule, err = d.Dig(context.Background())
+			require.NoError(err)
+			assert.Nil(capsule)
+		})
+
+		t.Run("DugOutInOrder", func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			d := NewMemoryDataloader[any]()
+			now := time.Now().UTC()
+
+			for _, i := range []int{3, 1, 4, 0, 2} {
+				err := d.BuryUtil(context.Background(), fmt.Sprintf("capsule %d", i), now.Add(time.Duration(i-10)*time.Millisecond).UnixMilli())
+				require.NoError(err)
+			}
+
+			for i := 0; i < 5; i++ {
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal(fmt.Sprintf("capsule %d", i), capsule.Payload)
+			}
+		})
+
+		t.Run("Deduplicated", func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			d := NewMemoryDataloader[any]()
+			scheduledAt := time.Now().UTC().Add(time.Minute).UnixMilli()
+
+			err := d.BuryUtil(context.Background(), "same", scheduledAt+1000)
+			require.NoError(err)
+
+			err = d.BuryUtil(context.Background(), "same", scheduledAt)
+			require.NoError(err)
+
+			assert.Equal(1, len(d.buriedMembers))
+
+			nextScheduledAt, ok, err := d.NextScheduledAt(context.Background())
+			require.NoError(err)
+			assert.True(ok)
+			assert.Equal(scheduledAt, nextScheduledAt)
+		})
+	})
+
+	t.Run("DigUtil", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+		scheduledAt := time.Now().UTC().Add(time.Minute).UnixMilli()
+
+		err := d.BuryUtil(context.Background(), "shouldBePrefetched", scheduledAt)
+		require.NoError(err)
+
+		capsule, err := d.Dig(context.Background())
+		require.NoError(err)
+		assert.Nil(capsule)
+
+		capsule, err = d.DigUtil(context.Background(), scheduledAt)
+		require.NoError(err)
+		require.NotNil(capsule)
+		assert.Equal("shouldBePrefetched", capsule.Payload)
+		assert.Equal(scheduledAt, capsule.ScheduledAt)
+
+		err = d.Release(context.Background(), capsule)
+		require.NoError(err)
+
+		nextScheduledAt, ok, err := d.NextScheduledAt(context.Background())
+		require.NoError(err)
+		assert.True(ok)
+		assert.Equal(scheduledAt, nextScheduledAt)
+	})
+
+	t.Run("Subscribe", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		notifications, err := d.Subscribe(ctx)
+		require.NoError(err)
+
+		scheduledAt := time.Now().UTC().Add(time.Minute).UnixMilli()
+
+		err = d.BuryUtil(context.Background(), "shouldBeNotified", scheduledAt)
+		require.NoError(err)
+
+		// not notified, the capsule buried earlier is due before them
+		err = d.BuryUtil(context.Background(), "shouldNotBeNotified", scheduledAt+1000)
+		require.NoError(err)
+
+		_, err = d.BuryMany(context.Background(), []Entry[any]{
+			{Payload: "later", UtilUnixMilliTimestamp: scheduledAt - 1000},
+			{Payload: "earlier", UtilUnixMilliTimestamp: scheduledAt - 2000},
+		})
+		require.NoError(err)
+
+		for _, expected := range []int64{scheduledAt, scheduledAt - 2000} {
+			select {
+			case notifiedScheduledAt := <-notifications:
+				assert.Equal(expected, notifiedScheduledAt)
+			case <-time.After(5 * time.Second):
+				require.Fail("buried capsule should be notified")
+			}
+		}
+
+		cancel()
+
+		select {
+		case _, ok := <-notifications:
+			assert.False(ok)
+		case <-time.After(5 * time.Second):
+			require.Fail("notifications should be closed once ctx is done")
+		}
+
+		// burying keeps working after the subscription is gone
+		err = d.BuryUtil(context.Background(), "shouldNotBeNotified", scheduledAt)
+		require.NoError(err)
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+
+		err := d.BuryUtil(context.Background(), "shouldBeReleased", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+		require.NoError(err)
+
+		capsule, err := d.Dig(context.Background())
+		require.NoError(err)
+		require.NotNil(capsule)
+		assert.Len(d.inFlight, 1)
+
+		err = d.Release(context.Background(), capsule)
+		require.NoError(err)
+		assert.Empty(d.inFlight)
+
+		releasedCapsule, err := d.Dig(context.Background())
+		require.NoError(err)
+		require.NotNil(releasedCapsule)
+		assert.Equal("shouldBeReleased", releasedCapsule.Payload)
+
+		err = d.Destroy(context.Background(), releasedCapsule)
+		require.NoError(err)
+
+		err = d.Release(context.Background(), releasedCapsule)
+		require.NoError(err)
+
+		capsule, err = d.Dig(context.Background())
+		require.NoError(err)
+		assert.Nil(capsule)
+	})
+
+	t.Run("LeaseExpiry", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any](DataloaderOption{LeaseDuration: time.Millisecond})
+
+		err := d.BuryUtil(context.Background(), "shouldBeLeasedAgain", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+		require.NoError(err)
+
+		capsule, err := d.Dig(context.Background())
+		require.NoError(err)
+		require.NotNil(capsule)
+
+		time.Sleep(5 * time.Millisecond)
+
+		capsule, err = d.Dig(context.Background())
+		require.NoError(err)
+		require.NotNil(capsule)
+		assert.Equal("shouldBeLeasedAgain", capsule.Payload)
+	})
+
+	t.Run("Pause", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+
+		err := d.Pause(context.Background())
+		require.NoError(err)
+
+		paused, err := d.Paused(context.Background())
+		require.NoError(err)
+		assert.True(paused)
+
+		err = d.BuryUtil(context.Background(), "shouldBeDugOutOnceResumed", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+		require.NoError(err)
+
+		capsule, err := d.Dig(context.Background())
+		require.NoError(err)
+		assert.Nil(capsule)
+
+		err = d.Resume(context.Background())
+		require.NoError(err)
+
+		paused, err = d.Paused(context.Background())
+		require.NoError(err)
+		assert.False(paused)
+
+		capsule, err = d.Dig(context.Background())
+		require.NoError(err)
+		require.NotNil(capsule)
+		assert.Equal("shouldBeDugOutOnceResumed", capsule.Payload)
+	})
+
+	t.Run("LeaderElection", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+
+		fencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+		require.NoError(err)
+		assert.True(acquired)
+
+		_, acquired, err = d.AcquireLeadership(context.Background(), "holder-b", time.Minute)
+		require.NoError(err)
+		assert.False(acquired)
+
+		renewedFencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+		require.NoError(err)
+		assert.True(acquired)
+		assert.Equal(fencingToken, renewedFencingToken)
+
+		err = d.BuryUtil(context.Background(), "shouldBeDugOutByLeader", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+		require.NoError(err)
+
+		capsule, err := d.Dig(withFencingToken(context.Background(), fencingToken))
+		require.NoError(err)
+		require.NotNil(capsule)
+		assert.Equal("shouldBeDugOutByLeader", capsule.Payload)
+
+		// the lease of holder-a lapses
+		_, _, err = d.AcquireLeadership(context.Background(), "holder-a", -time.Minute)
+		require.NoError(err)
+
+		takenOverFencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-b", time.Minute)
+		require.NoError(err)
+		assert.True(acquired)
+		assert.Greater(takenOverFencingToken, fencingToken)
+
+		err = d.BuryUtil(context.Background(), "shouldNotBeDugOutByStaleLeader", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+		require.NoError(err)
+
+		capsule, err = d.Dig(withFencingToken(context.Background(), fencingToken))
+		require.ErrorIs(err, ErrNotLeader)
+		assert.Nil(capsule)
+
+		err = d.ResignLeadership(context.Background(), "holder-a")
+		require.NoError(err)
+
+		_, acquired, err = d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+		require.NoError(err)
+		assert.False(acquired)
+
+		err = d.ResignLeadership(context.Background(), "holder-b")
+		require.NoError(err)
+
+		reacquiredFencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+		require.NoError(err)
+		assert.True(acquired)
+		assert.Greater(reacquiredFencingToken, takenOverFencingToken)
+
+		capsule, err = d.Dig(withFencingToken(context.Background(), reacquiredFencingToken))
+		require.NoError(err)
+		require.NotNil(capsule)
+		assert.Equal("shouldNotBeDugOutByStaleLeader", capsule.Payload)
+	})
+
+	t.Run("Heartbeat", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+
+		for _, memberID := range []string{"member-a", "member-b"} {
+			owned, err := d.Heartbeat(context.Background(), memberID, time.Minute)
+			require.NoError(err)
+			assert.Equal([]int{0}, owned)
+		}
+
+		assert.Len(d.members, 2)
+
+		err := d.Leave(context.Background(), "member-b")
+		require.NoError(err)
+		assert.Len(d.members, 1)
+	})
+
+	t.Run("ShardOwnership", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any](DataloaderOption{Shards: 4})
+
+		owned, err := d.Heartbeat(context.Background(), "member-a", time.Minute)
+		require.NoError(err)
+		assert.Equal([]int{0, 1, 2, 3}, owned)
+
+		owned, err = d.Heartbeat(context.Background(), "member-b", time.Minute)
+		require.NoError(err)
+		assert.Equal([]int{1, 3}, owned)
+
+		owned, err = d.Heartbeat(context.Background(), "member-a", time.Minute)
+		require.NoError(err)
+		assert.Equal([]int{0, 2}, owned)
+
+		for i := 0; i < 8; i++ {
+			err = d.BuryUtil(context.Background(), fmt.Sprintf("capsule %d", i), time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+			require.NoError(err)
+		}
+
+		for {
+			capsule, err := d.Dig(withShards(context.Background(), owned))
+			require.NoError(err)
+
+			if capsule == nil {
+				break
+			}
+
+			assert.Contains(owned, shardOf(capsule.ID, 4))
+		}
+
+		_, ok, err := d.NextScheduledAt(context.Background())
+		require.NoError(err)
+		assert.True(ok, "capsules of the shards owned by member-b should be left")
+
+		_, ok, err = d.NextScheduledAt(withShards(context.Background(), owned))
+		require.NoError(err)
+		assert.False(ok)
+
+		// the membership of member-b lapses
+		_, err = d.Heartbeat(context.Background(), "member-b", -time.Minute)
+		require.NoError(err)
+
+		owned, err = d.Heartbeat(context.Background(), "member-a", time.Minute)
+		require.NoError(err)
+		assert.Equal([]int{0, 1, 2, 3}, owned)
+	})
+
+	t.Run("Reshard", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+		payloads := make([]any, 0, 6)
+
+		for i := 0; i < 6; i++ {
+			payloads = append(payloads, fmt.Sprintf("capsule %d", i))
+
+			err := d.BuryUtil(context.Background(), fmt.Sprintf("capsule %d", i), time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+			require.NoError(err)
+		}
+
+		_, err := d.Reshard(context.Background(), 4)
+		require.NoError(err)
+		require.Len(d.buried, 4)
+
+		for _, entry := range d.buriedMembers {
+			capsule, err := NewTimeCapsuleFromBase64String[any](entry.member)
+			require.NoError(err)
+			assert.NotEmpty(capsule.ID, "IDs should be assigned to route the capsules")
+			assert.Equal(shardOf(capsule.ID, 4), entry.shard)
+		}
+
+		_, err = d.Reshard(context.Background(), 2)
+		require.NoError(err)
+		require.Len(d.buried, 2)
+
+		dugPayloads := make([]any, 0, 6)
+
+		for {
+			capsule, err := d.Dig(context.Background())
+			require.NoError(err)
+
+			if capsule == nil {
+				break
+			}
+
+			assert.Less(shardOf(capsule.ID, 2), 2)
+			dugPayloads = append(dugPayloads, capsule.Payload)
+
+			err = d.Destroy(context.Background(), capsule)
+			require.NoError(err)
+		}
+
+		assert.ElementsMatch(payloads, dugPayloads)
+	})
+
+	t.Run("BuryMany", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+		now := time.Now().UTC()
+		entries := make([]Entry[any], 0, 5)
+
+		for i := 0; i < 5; i++ {
+			entries = append(entries, Entry[any]{Payload: fmt.Sprintf("capsule %d", i), UtilUnixMilliTimestamp: now.Add(time.Duration(i-10) * time.Millisecond).UnixMilli()})
+		}
+
+		errs, err := d.BuryMany(context.Background(), entries)
+		require.NoError(err)
+		require.Len(errs, len(entries))
+
+		for _, err := range errs {
+			assert.NoError(err)
+		}
+
+		for i := 0; i < 5; i++ {
+			capsule, err := d.Dig(context.Background())
+			require.NoError(err)
+			require.NotNil(capsule)
+			assert.Equal(entries[i].Payload, capsule.Payload)
+		}
+
+		errs, err = d.BuryMany(context.Background(), nil)
+		require.NoError(err)
+		assert.Empty(errs)
+	})
+
+	t.Run("Destroy", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+
+		capsule := NewTimeCapsule[any]("shouldBeDestroyed")
+
+		err := d.BuryCapsule(context.Background(), capsule, time.Now().UTC().Add(time.Minute).UnixMilli())
+		require.NoError(err)
+
+		err = d.Destroy(context.Background(), capsule)
+		require.NoError(err)
+
+		_, ok, err := d.NextScheduledAt(context.Background())
+		require.NoError(err)
+		assert.False(ok)
+	})
+
+	t.Run("Quarantine", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+		scheduledAt := time.Now().UTC().Add(-5 * time.Millisecond).UnixMilli()
+
+		d.bury("not-a-capsule", scheduledAt)
+
+		capsule, err := d.Dig(context.Background())
+		require.ErrorIs(err, ErrQuarantined)
+		require.Nil(capsule)
+		assert.Zero(len(d.buriedMembers))
+		assert.Empty(d.inFlight)
+
+		quarantinedCapsules, err := d.Quarantined(context.Background())
+		require.NoError(err)
+		require.Len(quarantinedCapsules, 1)
+		assert.Equal("not-a-capsule", quarantinedCapsules[0].Member)
+		assert.Equal("not-a-capsule", quarantinedCapsules[0].Content)
+		assert.Equal(scheduledAt, quarantinedCapsules[0].ScheduledAt)
+		assert.NotEmpty(quarantinedCapsules[0].Error)
+		assert.NotZero(quarantinedCapsules[0].QuarantinedAt)
+
+		err = d.DeleteQuarantined(context.Background(), quarantinedCapsules[0].Member)
+		require.NoError(err)
+
+		quarantinedCapsules, err = d.Quarantined(context.Background())
+		require.NoError(err)
+		assert.Empty(quarantinedCapsules)
+	})
+
+	t.Run("ClaimCheck", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any](DataloaderOption{ClaimCheckThreshold: 1})
+
+		err := d.BuryUtil(context.Background(), "shouldBeClaimChecked", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+		require.NoError(err)
+
+		require.Len(d.buriedMembers, 1)
+		for member := range d.buriedMembers {
+			assert.True(strings.HasPrefix(member, claimCheckReferencePrefix))
+		}
+
+		assert.Len(d.payloads, 1)
+
+		capsule, err := d.Dig(context.Background())
+		require.NoError(err)
+		require.NotNil(capsule)
+		assert.Equal("shouldBeClaimChecked", capsule.Payload)
+
+		err = d.Destroy(context.Background(), capsule)
+		require.NoError(err)
+		assert.Empty(d.payloads)
+
+		// the payload of the reference is missing
+		d.bury(claimCheckReference("missing"), time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+
+		capsule, err = d.Dig(context.Background())
+		require.ErrorIs(err, ErrQuarantined)
+		require.Nil(capsule)
+
+		quarantinedCapsules, err := d.Quarantined(context.Background())
+		require.NoError(err)
+		require.Len(quarantinedCapsules, 1)
+		assert.Equal(claimCheckReference("missing"), quarantinedCapsules[0].Member)
+	})
+
+	t.Run("DeadLetter", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+
+		err := d.BuryUtil(context.Background(), "shouldBeDeadLettered", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+		require.NoError(err)
+
+		capsule, err := d.Dig(context.Background())
+		require.NoError(err)
+		require.NotNil(capsule)
+
+		err = d.DeadLetter(context.Background(), capsule, errors.New("failed to handle"))
+		require.NoError(err)
+
+		deadLetteredCapsules, err := d.DeadLetters(context.Background())
+		require.NoError(err)
+		require.Len(deadLetteredCapsules, 1)
+		assert.Equal(capsule.Base64String(), deadLetteredCapsules[0].Member)
+		assert.Equal(capsule.Base64String(), deadLetteredCapsules[0].Content)
+		assert.Equal("failed to handle", deadLetteredCapsules[0].Reason)
+		assert.NotZero(deadLetteredCapsules[0].DeadLetteredAt)
+
+		err = d.DeleteDeadLetter(context.Background(), deadLetteredCapsules[0].Member)
+		require.NoError(err)
+
+		deadLetteredCapsules, err = d.DeadLetters(context.Background())
+		require.NoError(err)
+		assert.Empty(deadLetteredCapsules)
+	})
+
+	t.Run("DestroyAll", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+
+		err := d.BuryUtil(context.Background(), "shouldBeDugOut", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+		require.NoError(err)
+
+		err = d.BuryUtil(context.Background(), "shouldBeBuried", time.Now().UTC().Add(time.Minute).UnixMilli())
+		require.NoError(err)
+
+		capsule, err := d.Dig(context.Background())
+		require.NoError(err)
+		require.NotNil(capsule)
+
+		err = d.DestroyAll(context.Background())
+		require.NoError(err)
+
+		_, ok, err := d.NextScheduledAt(context.Background())
+		require.NoError(err)
+		assert.False(ok)
+		assert.Empty(d.inFlight)
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		d := NewMemoryDataloader[any]()
+
+		var waitGroup sync.WaitGroup
+
+		for i := 0; i < 10; i++ {
+			waitGroup.Add(1)
+
+			go func() {
+				defer waitGroup.Done()
+
+				for j := 0; j < 100; j++ {
+					err := d.BuryUtil(context.Background(), fmt.Sprintf("capsule %d-%d", i, j), time.Now().UTC().Add(-time.Millisecond).UnixMilli())
+					assert.NoError(err)
+				}
+			}()
+		}
+
+		waitGroup.Wait()
+
+		var mutex sync.Mutex
+		dugPayloads := make(map[any]int)
+
+		for i := 0; i < 5; i++ {
+			waitGroup.Add(1)
+
+			go func() {
+				defer waitGroup.Done()
+
+				for {
+					capsule, err := d.Dig(context.Background())
+					assert.NoError(err)
+
+					if capsule == nil {
+						return
+					}
+
+					mutex.Lock()
+					dugPayloads[capsule.Payload]++
+					mutex.Unlock()
+
+					err = d.Destroy(context.Background(), capsule)
+					assert.NoError(err)
+				}
+			}()
+		}
+
+		waitGroup.Wait()
+
+		require.Len(dugPayloads, 1000)
+
+		for _, count := range dugPayloads {
+			assert.Equal(1, count)
+		}
+	})
+}
@@ -2,7 +2,9 @@ package timecapsule
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,18 +15,92 @@ import (
 // RedisDataloader is a dataloader that loads data from redis.
 type RedisDataloader[P any] struct {
 	sortedSetKey string
-	redisClient  *redis.Client
+	redisClient  redis.UniversalClient
+	option       DataloaderOption
+
+	// Shards of the topic when DataloaderOption.Shards is set, nil otherwise
+	shards []*RedisDataloader[P]
+	// Sorted set key of the sharded topic when the dataloader is one of its
+	// shards, empty otherwise
+	shardedSortedSetKey string
 }
 
 // static check implementation.
 var _ Dataloader[any] = (*RedisDataloader[any])(nil)
+var _ CapsuleBurier[any] = (*RedisDataloader[any])(nil)
+var _ BulkBurier[any] = (*RedisDataloader[any])(nil)
+var _ Prefetcher[any] = (*RedisDataloader[any])(nil)
+var _ SchedulePeeker = (*RedisDataloader[any])(nil)
+var _ ServerClock = (*RedisDataloader[any])(nil)
+var _ Subscriber = (*RedisDataloader[any])(nil)
+var _ Releaser[any] = (*RedisDataloader[any])(nil)
+var _ DeadLetterer[any] = (*RedisDataloader[any])(nil)
+var _ LeaderElector = (*RedisDataloader[any])(nil)
+var _ ShardCoordinator = (*RedisDataloader[any])(nil)
+
+var (
+	redisBuryScript           = redis.NewScript(buryScriptSource)
+	redisBuryClaimCheckScript = redis.NewScript(buryClaimCheckScriptSource)
+	redisDigScript            = redis.NewScript(digScriptSource)
+	redisReleaseScript        = redis.NewScript(releaseScriptSource)
+	redisDestroyScript        = redis.NewScript(destroyScriptSource)
+	redisQuarantineScript     = redis.NewScript(quarantineScriptSource)
+
+	redisDeleteQuarantinedScript = redis.NewScript(deleteQuarantinedScriptSource)
+	redisAcquireLeadershipScript = redis.NewScript(acquireLeadershipScriptSource)
+	redisResignLeadershipScript  = redis.NewScript(resignLeadershipScriptSource)
+	redisHeartbeatScript         = redis.NewScript(heartbeatScriptSource)
+)
 
 // NewRedisDataloader creates a new RedisDataloader.
-func NewRedisDataloader[P any](sortedSetKey string, redisClient *redis.Client) *RedisDataloader[P] {
-	return &RedisDataloader[P]{
+//
+// redisClient can be a *redis.Client, a *redis.ClusterClient, or a client of
+// Sentinel created by redis.NewFailoverClient, as well as any client created by
+// redis.NewUniversalClient. All the keys of the topic are hash-tagged into the
+// slot of sortedSetKey, so that the scripts touching several keys work in
+// cluster mode. In cluster mode, sortedSetKey should not contain '}' without a
+// hash tag, see NewRedisDataloaderE.
+func NewRedisDataloader[P any](sortedSetKey string, redisClient redis.UniversalClient, options ...DataloaderOption) *RedisDataloader[P] {
+	dataloader := &RedisDataloader[P]{
 		sortedSetKey: sortedSetKey,
 		redisClient:  redisClient,
+		option:       DefaultDataloaderOption(),
+	}
+
+	mergeDataloaderOption(&dataloader.option, options...)
+
+	if dataloader.option.Shards > 1 {
+		shardOption := dataloader.option
+		shardOption.Shards = 0
+
+		dataloader.shards = make([]*RedisDataloader[P], dataloader.option.Shards)
+		for i := range dataloader.shards {
+			dataloader.shards[i] = &RedisDataloader[P]{
+				sortedSetKey:        shardKey(sortedSetKey, i),
+				redisClient:         redisClient,
+				option:              shardOption,
+				shardedSortedSetKey: sortedSetKey,
+			}
+		}
+	}
+
+	return dataloader
+}
+
+// NewRedisDataloaderE creates a new RedisDataloader the same as
+// NewRedisDataloader, and returns an error when redisClient is a
+// *redis.ClusterClient and sortedSetKey contains '}' without a hash tag, since
+// the keys of the topic could not be hash-tagged into its slot then. Such keys
+// work with the other clients, where hash slots do not matter.
+func NewRedisDataloaderE[P any](sortedSetKey string, redisClient redis.UniversalClient, options ...DataloaderOption) (*RedisDataloader[P], error) {
+	if _, ok := redisClient.(*redis.ClusterClient); ok {
+		err := validateSortedSetKey(sortedSetKey)
+		if err != nil {
+			return nil, err
+		}
 	}
+
+	return NewRedisDataloader[P](sortedSetKey, redisClient, options...), nil
 }
 
 // Type returns the type of the dataloader.
@@ -34,144 +110,949 @@ func (r *RedisDataloader[P]) Type() string {
 
 // BuryFor buries the payload into the ground for the given duration
 //
-// Equivalent to redis command:
+// Equivalent to redis commands, executed atomically as a script:
 //
 //	ZADD sortedSetKey <now timestamp + forTimeRange> <capsule base64 string>
+//	PUBLISH sortedSetKey/buried <now timestamp + forTimeRange>, if earlier than the earliest member
 func (r *RedisDataloader[P]) BuryFor(ctx context.Context, payload P, forTimeRange time.Duration) error {
-	utilUnixMilliTimestamp := time.Now().UTC().Add(forTimeRange).UnixMilli()
+	utilUnixMilliTimestamp := r.option.Clock.Now().UTC().Add(forTimeRange).UnixMilli()
 	return r.BuryUtil(ctx, payload, utilUnixMilliTimestamp)
 }
 
 // BuryUtil buries the payload into the ground util the given timestamp
 //
-// Equivalent to redis command:
+// Equivalent to redis commands, executed atomically as a script:
 //
 //	ZADD sortedSetKey utilUnixMilliTimestamp <capsule base64 string>
+//	PUBLISH sortedSetKey/buried utilUnixMilliTimestamp, if earlier than the earliest member
+//
+// When the encoded capsule is larger than DataloaderOption.ClaimCheckThreshold,
+// it is equivalent to redis commands, executed atomically as a script:
+//
+//	HSET sortedSetKey/payloads <capsule id> <capsule base64 string>
+//	ZADD sortedSetKey utilUnixMilliTimestamp ref:<capsule id>
+//	PUBLISH sortedSetKey/buried utilUnixMilliTimestamp, if earlier than the earliest member
+//
+// The diggers subscribing to the sortedSetKey/buried channel will be woken up
+// if the capsule is due earlier than they are going to dig, see Subscribe. A
+// failure to publish is not an error, since the capsule is buried anyway and
+// the diggers still dig it out by polling.
 func (r *RedisDataloader[P]) BuryUtil(ctx context.Context, payload P, utilUnixMilliTimestamp int64) error {
-	newCapsule := TimeCapsule[P]{Payload: payload}
-	return r.bury(ctx, newCapsule.Base64String(), utilUnixMilliTimestamp)
+	return r.BuryCapsule(ctx, NewTimeCapsule(payload), utilUnixMilliTimestamp)
+}
+
+func (r *RedisDataloader[P]) payloadsKey() string {
+	return topicKey(r.sortedSetKey, "payloads")
+}
+
+func (r *RedisDataloader[P]) inFlightKey() string {
+	return topicKey(r.sortedSetKey, "in-flight")
+}
+
+func (r *RedisDataloader[P]) quarantineKey() string {
+	return topicKey(r.sortedSetKey, "quarantine")
+}
+
+func (r *RedisDataloader[P]) deadLettersKey() string {
+	return topicKey(r.sortedSetKey, "dead-letters")
+}
+
+func (r *RedisDataloader[P]) pausedKey() string {
+	return topicKey(r.sortedSetKey, "paused")
+}
+
+func (r *RedisDataloader[P]) leaderKey() string {
+	return topicKey(r.sortedSetKey, "leader")
+}
+
+func (r *RedisDataloader[P]) fencingTokenKey() string {
+	return topicKey(r.sortedSetKey, "fencing-token")
+}
+
+func (r *RedisDataloader[P]) membersKey() string {
+	return topicKey(r.sortedSetKey, "members")
+}
+
+// digKeys returns the keys of the dig script. The shards omit the paused flag
+// and the leader keys, which are checked against the sharded topic by
+// digShards once per dig, since the ones of the shards are never written.
+func (r *RedisDataloader[P]) digKeys() []string {
+	if r.shardedSortedSetKey != "" {
+		return []string{r.sortedSetKey, r.payloadsKey(), r.inFlightKey()}
+	}
+
+	return []string{r.sortedSetKey, r.payloadsKey(), r.inFlightKey(), r.pausedKey(), r.leaderKey()}
+}
+
+// buriedChannel returns the channel the buried capsules are published to, the
+// shards publish to the channel of the sharded topic.
+func (r *RedisDataloader[P]) buriedChannel() string {
+	if r.shardedSortedSetKey != "" {
+		return topicKey(r.shardedSortedSetKey, "buried")
+	}
+
+	return topicKey(r.sortedSetKey, "buried")
+}
+
+// shardOf returns the shard the capsule is routed to.
+func (r *RedisDataloader[P]) shardOf(capsule *TimeCapsule[P]) *RedisDataloader[P] {
+	return r.shards[shardOf(capsule.ID, len(r.shards))]
+}
+
+// BuryCapsule buries the capsule into the ground util the given timestamp, it
+// allows to bury a capsule with fields other than the payload, such as Kind.
+//
+// See BuryUtil for the equivalent redis commands. When the topic is sharded, a
+// random ID is assigned to the capsule if it has none, and the capsule is buried
+// into the shard sortedSetKey/shards/<shard> routed by the hash of its ID.
+func (r *RedisDataloader[P]) BuryCapsule(ctx context.Context, capsule *TimeCapsule[P], utilUnixMilliTimestamp int64) error {
+	if len(r.shards) > 0 {
+		err := capsule.ensureID()
+		if err != nil {
+			return err
+		}
+
+		return r.shardOf(capsule).BuryCapsule(ctx, capsule, utilUnixMilliTimestamp)
+	}
+	if r.option.ClaimCheckThreshold <= 0 || len(capsule.Base64String()) <= r.option.ClaimCheckThreshold {
+		return r.bury(ctx, capsule.Base64String(), utilUnixMilliTimestamp)
+	}
+
+	err := capsule.ensureID()
+	if err != nil {
+		return err
+	}
+
+	capsuleID := capsule.ID
+
+	return invoke0(ctx, func() error {
+		return redisBuryClaimCheckScript.Run(
+			ctx,
+			r.redisClient,
+			[]string{r.sortedSetKey, r.payloadsKey()},
+			utilUnixMilliTimestamp,
+			claimCheckReference(capsuleID),
+			capsuleID,
+			capsule.Base64String(),
+			r.buriedChannel(),
+		).Err()
+	})
 }
 
 func (r *RedisDataloader[P]) bury(ctx context.Context, capsuleBase64String string, utilUnixMilliTimestamp int64) error {
 	return invoke0(ctx, func() error {
-		err := r.redisClient.ZAdd(ctx, r.sortedSetKey, redis.Z{Score: float64(utilUnixMilliTimestamp), Member: capsuleBase64String}).Err()
+		return redisBuryScript.Run(
+			ctx,
+			r.redisClient,
+			[]string{r.sortedSetKey},
+			r.buriedChannel(),
+			utilUnixMilliTimestamp,
+			capsuleBase64String,
+		).Err()
+	})
+}
+
+// BuryMany buries the payloads of the entries in bulk, in one pipeline with up
+// to 1000 entries per script. It returns the errors of the entries by index, nil
+// for the entries which were buried, and an error summarizing the failed entries
+// if there is any.
+//
+// Equivalent to redis commands, executed atomically as a script per batch:
+//
+//	ZADD sortedSetKey <timestamp> <capsule base64 string> [<timestamp> <capsule base64 string> ...]
+//	PUBLISH sortedSetKey/buried <earliest timestamp>, if earlier than the earliest member
+//
+// The entries larger than DataloaderOption.ClaimCheckThreshold are buried with
+// the claim-check script of BuryUtil in the same pipeline. When the topic is
+// sharded, the entries are routed to the shards the same way as BuryCapsule,
+// and the batches of all the shards are sent in the same pipeline as well.
+func (r *RedisDataloader[P]) BuryMany(ctx context.Context, entries []Entry[P]) ([]error, error) {
+	capsules := make([]*TimeCapsule[P], len(entries))
+	utilUnixMilliTimestamps := make([]int64, len(entries))
+	errs := make([]error, len(entries))
+	indicesOfShards := make([][]int, max(len(r.shards), 1))
+
+	for i, entry := range entries {
+		capsules[i] = NewTimeCapsule(entry.Payload)
+		utilUnixMilliTimestamps[i] = entry.UtilUnixMilliTimestamp
+
+		if len(r.shards) == 0 {
+			indicesOfShards[0] = append(indicesOfShards[0], i)
+			continue
+		}
+
+		err := capsules[i].ensureID()
 		if err != nil {
-			return err
+			errs[i] = err
+			continue
+		}
+
+		shard := shardOf(capsules[i].ID, len(r.shards))
+		indicesOfShards[shard] = append(indicesOfShards[shard], i)
+	}
+
+	cmds := make([]redisBuryManyCmd, 0)
+
+	_, _ = r.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for shard, indices := range indicesOfShards {
+			loader := r
+			if len(r.shards) > 0 {
+				loader = r.shards[shard]
+			}
+
+			cmds = append(cmds, loader.queueBuryMany(ctx, pipe, capsules, utilUnixMilliTimestamps, indices, errs)...)
 		}
 
 		return nil
 	})
+
+	// the errors are read from the commands, the capsules are buried even if
+	// the other commands of the pipeline failed
+	for _, cmd := range cmds {
+		for _, i := range cmd.indices {
+			errs[i] = cmd.cmd.Err()
+		}
+	}
+
+	return errs, buryManyError(errs)
+}
+
+// BuryInTx queues the commands burying the capsule until the given timestamp
+// into pipe, so that the capsule is buried atomically along with the other
+// commands of the caller once pipe is created by TxPipeline and executed.
+//
+// Equivalent to redis commands queued into pipe as a script:
+//
+//	ZADD sortedSetKey utilUnixMilliTimestamp <capsule base64 string>
+//	PUBLISH sortedSetKey/buried utilUnixMilliTimestamp, if earlier than the earliest member
+//
+// When the encoded capsule is larger than DataloaderOption.ClaimCheckThreshold,
+// it is equivalent to redis commands queued into pipe as a script:
+//
+//	HSET sortedSetKey/payloads <capsule id> <capsule base64 string>
+//	ZADD sortedSetKey utilUnixMilliTimestamp ref:<capsule id>
+//	PUBLISH sortedSetKey/buried utilUnixMilliTimestamp, if earlier than the earliest member
+//
+// The errors of the queued commands are returned by executing pipe.
+//
+// When the topic is sharded, the capsule is buried into its shard the same way
+// as BuryCapsule. In Redis Cluster, the keys of the other commands of the
+// transaction should be in the slot of sortedSetKey, or of the shard the
+// capsule is routed to when the topic is sharded.
+func (r *RedisDataloader[P]) BuryInTx(ctx context.Context, pipe redis.Pipeliner, capsule *TimeCapsule[P], utilUnixMilliTimestamp int64) error {
+	if len(r.shards) > 0 {
+		err := capsule.ensureID()
+		if err != nil {
+			return err
+		}
+
+		return r.shardOf(capsule).BuryInTx(ctx, pipe, capsule, utilUnixMilliTimestamp)
+	}
+
+	if r.option.ClaimCheckThreshold <= 0 || len(capsule.Base64String()) <= r.option.ClaimCheckThreshold {
+		redisBuryScript.Eval(
+			ctx,
+			pipe,
+			[]string{r.sortedSetKey},
+			r.buriedChannel(),
+			utilUnixMilliTimestamp,
+			capsule.Base64String(),
+		)
+
+		return nil
+	}
+
+	err := capsule.ensureID()
+	if err != nil {
+		return err
+	}
+
+	redisBuryClaimCheckScript.Eval(
+		ctx,
+		pipe,
+		[]string{r.sortedSetKey, r.payloadsKey()},
+		utilUnixMilliTimestamp,
+		claimCheckReference(capsule.ID),
+		capsule.ID,
+		capsule.Base64String(),
+		r.buriedChannel(),
+	)
+
+	return nil
+}
+
+// redisBuryManyCmd is a command queued by BuryMany, along with the indices of
+// the entries it buries.
+type redisBuryManyCmd struct {
+	cmd     *redis.Cmd
+	indices []int
+}
+
+// queueBuryMany queues the scripts burying the capsules of the given indices
+// into pipe, in batches of up to buryManyBatchSize capsules, and records the
+// errors of the capsules which can not be buried into errs by index. The
+// scripts are sent with EVAL instead of EVALSHA, so that they are not loaded
+// beforehand in another round trip.
+func (r *RedisDataloader[P]) queueBuryMany(ctx context.Context, pipe redis.Pipeliner, capsules []*TimeCapsule[P], utilUnixMilliTimestamps []int64, indices []int, errs []error) []redisBuryManyCmd {
+	cmds := make([]redisBuryManyCmd, 0)
+
+	for start := 0; start < len(indices); start += buryManyBatchSize {
+		batch := indices[start:min(start+buryManyBatchSize, len(indices))]
+		args := make([]any, 0, 1+2*len(batch))
+		args = append(args, r.buriedChannel())
+		inlined := make([]int, 0, len(batch))
+
+		for _, i := range batch {
+			capsule := capsules[i]
+
+			if r.option.ClaimCheckThreshold <= 0 || len(capsule.Base64String()) <= r.option.ClaimCheckThreshold {
+				args = append(args, utilUnixMilliTimestamps[i], capsule.Base64String())
+				inlined = append(inlined, i)
+
+				continue
+			}
+
+			err := capsule.ensureID()
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+
+			cmds = append(cmds, redisBuryManyCmd{
+				cmd: redisBuryClaimCheckScript.Eval(
+					ctx,
+					pipe,
+					[]string{r.sortedSetKey, r.payloadsKey()},
+					utilUnixMilliTimestamps[i],
+					claimCheckReference(capsule.ID),
+					capsule.ID,
+					capsule.Base64String(),
+					r.buriedChannel(),
+				),
+				indices: []int{i},
+			})
+		}
+
+		if len(inlined) > 0 {
+			cmds = append(cmds, redisBuryManyCmd{
+				cmd:     redisBuryScript.Eval(ctx, pipe, []string{r.sortedSetKey}, args...),
+				indices: inlined,
+			})
+		}
+	}
+
+	return cmds
 }
 
 // Dig digs the time capsule from the dataloader
 //
-// Equivalent to redis command flow:
+// Equivalent to redis command flow, executed atomically as a script:
 //
-//	     ZRANGEBYSCORE sortedSetKey 0 <now timestamp>
+//	HGET sortedSetKey/leader token, fail if the fencing token in ctx is stale
+//	                            |
+//	EXISTS sortedSetKey/paused, return if the topic is paused
+//	                            |
+//	move members of sortedSetKey/in-flight with expired leases back to sortedSetKey
+//	                            |
+//	ZRANGEBYSCORE sortedSetKey -inf <now timestamp> WITHSCORES LIMIT 0 1
 //	                            |
 //	                      got elements?
 //	                            |
 //	                   -------------------
 //	                   |                 |
-//	        ZPOPMIN sortedSetKey 1     return
+//	      ZREM sortedSetKey <member>   return
+//	                   |
+//	ZADD sortedSetKey/in-flight <lease deadline> <member>
 //	                   |
-//	            dut to execute?
+//	        claim-check reference?
 //	                   |
 //	           -----------------
 //	           |               |
-//	return TimeCapsule     return
+//	HGET sortedSetKey/payloads |
+//	           |               |
+//	           -----------------
+//	                   |
+//	          return TimeCapsule
+//
+// The dug out capsule is leased to the caller until DataloaderOption.LeaseDuration
+// elapses, it should be either destroyed or released before the lease expires,
+// otherwise it will be handed back to be dug out again.
+//
+// Capsules which fail to decode are moved to the quarantine hash with the decode
+// error attached instead of being lost, see Quarantined.
+//
+// When the topic is sharded, the pause flag, the fencing token and the earliest
+// members of the shards are read in one pipeline first, then the shards with
+// due capsules are dug with the flow above in the order of the earliest due
+// time, until a capsule is dug out:
+//
+//	EXISTS sortedSetKey/paused
+//	HGET sortedSetKey/leader token
+//	ZRANGE sortedSetKey/shards/<shard> 0 0 WITHSCORES
+//	ZRANGE {sortedSetKey/shards/<shard>}/in-flight 0 0 WITHSCORES
+//
+// The fencing token is not checked atomically with digging the shards then.
+//
+// With DataloaderOption.ServerTime, <now timestamp> is taken from TIME inside the
+// script, and TIME is read after the earliest members of the shards:
+//
+//	TIME
 func (r *RedisDataloader[P]) Dig(ctx context.Context) (*TimeCapsule[P], error) {
-	now := time.Now().UTC()
+	return r.DigUtil(ctx, r.option.Clock.Now().UTC().UnixMilli())
+}
+
+// DigUtil digs the earliest capsule which is due until the given timestamp, it
+// allows to lease the capsules before they are due, such as prefetching.
+//
+// See Dig for the equivalent redis commands.
+func (r *RedisDataloader[P]) DigUtil(ctx context.Context, utilUnixMilliTimestamp int64) (*TimeCapsule[P], error) {
+	if len(r.shards) > 0 {
+		return r.digShards(ctx, utilUnixMilliTimestamp)
+	}
 
-	members, err := r.redisClient.ZCount(ctx, r.sortedSetKey, "0", strconv.FormatInt(now.UnixMilli(), 10)).Result()
+	now := r.option.Clock.Now().UTC()
+	leaseDeadline := now.Add(r.option.LeaseDuration).UnixMilli()
+
+	result, err := redisDigScript.Run(
+		ctx,
+		r.redisClient,
+		r.digKeys(),
+		now.UnixMilli(),
+		claimCheckReferencePrefix,
+		leaseDeadline,
+		leaseRequeueLimit,
+		utilUnixMilliTimestamp,
+		fencingTokenArg(ctx),
+		serverTimeArg(r.option.ServerTime),
+	).Slice()
 	if err != nil {
 		if err == redis.Nil {
 			return nil, nil
 		}
+		if errors.Is(err, redis.ErrClosed) || redis.HasErrorPrefix(err, "WRONGTYPE") {
+			return nil, fmt.Errorf("%w: %w", ErrFatal, err)
+		}
+		if redis.HasErrorPrefix(err, notLeaderErrorPrefix) {
+			return nil, fmt.Errorf("%w: %w", ErrNotLeader, err)
+		}
 
 		return nil, err
 	}
-	if members == 0 {
-		return nil, nil
+	if len(result) != 5 {
+		return nil, errors.New("invalid dig result")
 	}
 
-	capsulesList, err := r.redisClient.ZPopMin(ctx, r.sortedSetKey, 1).Result()
+	member, ok := result[0].(string)
+	if !ok {
+		return nil, errors.New("invalid capsule content")
+	}
+
+	// now and the lease deadline follow the clock of Redis with ServerTime
+	dugOutAt, _ := result[3].(int64)
+	leaseDeadline, _ = result[4].(int64)
+
+	score, _ := result[1].(string)
+
+	scheduledAt, err := parseScore(score)
 	if err != nil {
-		if err == redis.Nil {
-			return nil, nil
+		return nil, err
+	}
+
+	capsuleContent, ok := result[2].(string)
+	if !ok {
+		return nil, r.quarantine(ctx, member, scheduledAt, fmt.Errorf("payload of claim-check reference %s is missing", member))
+	}
+
+	capsule, err := NewTimeCapsuleFromBase64String[P](capsuleContent)
+	if err != nil {
+		return nil, r.quarantine(ctx, member, scheduledAt, err)
+	}
+
+	capsule.member = member
+	capsule.leaseDeadline = leaseDeadline
+	capsule.DugOutAt = dugOutAt
+	capsule.ScheduledAt = scheduledAt
+
+	return capsule, nil
+}
+
+// digShards digs the earliest due capsule across the shards of the topic.
+func (r *RedisDataloader[P]) digShards(ctx context.Context, utilUnixMilliTimestamp int64) (*TimeCapsule[P], error) {
+	now := r.option.Clock.Now().UTC().UnixMilli()
+	fencingToken, fenced := fencingTokenFromContext(ctx)
+
+	shards := shardsFromContext(ctx, len(r.shards))
+
+	var pausedCmd *redis.IntCmd
+	var fencingTokenCmd *redis.StringCmd
+
+	scheduledCmds := make([]*redis.ZSliceCmd, len(shards))
+	leasedCmds := make([]*redis.ZSliceCmd, len(shards))
+
+	_, err := r.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pausedCmd = pipe.Exists(ctx, r.pausedKey())
+		if fenced {
+			fencingTokenCmd = pipe.HGet(ctx, r.leaderKey(), "token")
+		}
+		for i, shard := range shards {
+			scheduledCmds[i] = pipe.ZRangeWithScores(ctx, r.shards[shard].sortedSetKey, 0, 0)
+			leasedCmds[i] = pipe.ZRangeWithScores(ctx, r.shards[shard].inFlightKey(), 0, 0)
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		if errors.Is(err, redis.ErrClosed) || redis.HasErrorPrefix(err, "WRONGTYPE") {
+			return nil, fmt.Errorf("%w: %w", ErrFatal, err)
 		}
 
 		return nil, err
 	}
-	if len(capsulesList) == 0 {
+	if pausedCmd.Val() == 1 {
 		return nil, nil
 	}
+	if fenced && fencingTokenCmd.Val() != strconv.FormatInt(fencingToken, 10) {
+		return nil, fmt.Errorf("%w: fencing token %d is stale", ErrNotLeader, fencingToken)
+	}
 
-	capsuleOpeningTime := time.UnixMilli(int64(capsulesList[0].Score))
-	if capsuleOpeningTime.After(now) {
-		time.Sleep(10 * time.Millisecond)
+	peeks := make([]shardPeek, len(shards))
 
-		_, _, err := lo.AttemptWithDelay(100, 10*time.Millisecond, func(i int, d time.Duration) error {
-			member, ok := capsulesList[0].Member.(string)
-			if !ok {
-				return errors.New("invalid capsule content")
+	for i := range shards {
+		scheduled, err := scheduledCmds[i].Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(scheduled) > 0 {
+			peeks[i].scheduledAt = int64(scheduled[0].Score)
+			peeks[i].scheduled = true
+		}
+
+		leased, err := leasedCmds[i].Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(leased) > 0 {
+			peeks[i].leaseDeadline = int64(leased[0].Score)
+			peeks[i].leased = true
+		}
+	}
+
+	// the shards shift the due timestamp by the skew between the clocks
+	// themselves, only the peeks are compared against the clock of Redis here
+	dueAt := utilUnixMilliTimestamp
+	if r.option.ServerTime {
+		serverNow, err := r.serverTime(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		skew := serverNow - now
+		now += skew
+		dueAt += skew
+	}
+
+	// the fencing token has been checked against the leadership of the topic
+	shardCtx := withoutFencingToken(ctx)
+
+	for _, i := range dueShards(peeks, now, dueAt) {
+		capsule, err := r.shards[shards[i]].DigUtil(shardCtx, utilUnixMilliTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		if capsule != nil {
+			return capsule, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ServerNow returns the unix milli timestamp the scores are compared against,
+// which is the clock of Redis with DataloaderOption.ServerTime, or the clock of
+// the dataloader otherwise.
+//
+// Equivalent to redis command with DataloaderOption.ServerTime:
+//
+//	TIME
+func (r *RedisDataloader[P]) ServerNow(ctx context.Context) (int64, error) {
+	if !r.option.ServerTime {
+		return r.option.Clock.Now().UTC().UnixMilli(), nil
+	}
+
+	return r.serverTime(ctx)
+}
+
+// serverTime returns the unix milli timestamp of the clock of Redis. TIME is not
+// pipelined with the peeks of the shards, since the cluster client of rueidis
+// refuses to mix commands without keys with the commands of several slots, and
+// both dataloaders read the clock of Redis the same way.
+//
+// Equivalent to redis command:
+//
+//	TIME
+func (r *RedisDataloader[P]) serverTime(ctx context.Context) (int64, error) {
+	serverNow, err := r.redisClient.Time(ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return serverNow.UnixMilli(), nil
+}
+
+// quarantine moves the leased member which failed to decode to the quarantine hash
+//
+// Equivalent to redis commands, executed atomically as a script:
+//
+//	HSET sortedSetKey/quarantine <member> <quarantine record>
+//	ZREM sortedSetKey/in-flight <member>
+func (r *RedisDataloader[P]) quarantine(ctx context.Context, member string, scheduledAt int64, decodeErr error) error {
+	err := redisQuarantineScript.Run(
+		ctx,
+		r.redisClient,
+		[]string{r.quarantineKey(), r.inFlightKey()},
+		member,
+		newQuarantineRecord(scheduledAt, decodeErr, r.option.Clock.Now().UTC().UnixMilli()),
+	).Err()
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to decode capsule: %w", decodeErr), fmt.Errorf("failed to quarantine capsule: %w", err))
+	}
+
+	return fmt.Errorf("failed to decode capsule, %w: %w", ErrQuarantined, decodeErr)
+}
+
+// Quarantined lists the capsules which failed to decode when digging.
+//
+// Equivalent to redis commands:
+//
+//	HGETALL sortedSetKey/quarantine
+//	HMGET sortedSetKey/payloads <capsule ids of claim-check references>
+func (r *RedisDataloader[P]) Quarantined(ctx context.Context) ([]*QuarantinedCapsule, error) {
+	if len(r.shards) > 0 {
+		quarantinedCapsules := make([]*QuarantinedCapsule, 0)
+
+		for _, shard := range r.shards {
+			shardQuarantinedCapsules, err := shard.Quarantined(ctx)
+			if err != nil {
+				return nil, err
 			}
 
-			return r.bury(ctx, member, capsuleOpeningTime.UnixMilli())
-		})
+			quarantinedCapsules = append(quarantinedCapsules, shardQuarantinedCapsules...)
+		}
+
+		return quarantinedCapsules, nil
+	}
+
+	records, err := r.redisClient.HGetAll(ctx, r.quarantineKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	quarantinedCapsules := make([]*QuarantinedCapsule, 0, len(records))
+	referencedCapsules := make(map[string]*QuarantinedCapsule)
+
+	for member, record := range records {
+		quarantinedCapsule, err := parseQuarantineRecord(member, record)
 		if err != nil {
 			return nil, err
 		}
 
-		return nil, nil
+		quarantinedCapsules = append(quarantinedCapsules, quarantinedCapsule)
+
+		capsuleID, ok := claimCheckCapsuleID(member)
+		if ok {
+			referencedCapsules[capsuleID] = quarantinedCapsule
+		}
+	}
+	if len(referencedCapsules) == 0 {
+		return quarantinedCapsules, nil
 	}
 
-	capsuleContent, ok := capsulesList[0].Member.(string)
-	if !ok {
+	capsuleIDs := lo.Keys(referencedCapsules)
+
+	contents, err := r.redisClient.HMGet(ctx, r.payloadsKey(), capsuleIDs...).Result()
+	if err != nil {
 		return nil, err
 	}
 
-	capsule, err := NewTimeCapsuleFromBase64String[P](capsuleContent)
+	for i, content := range contents {
+		content, ok := content.(string)
+		if ok {
+			referencedCapsules[capsuleIDs[i]].Content = content
+		}
+	}
+
+	return quarantinedCapsules, nil
+}
+
+// DeleteQuarantined deletes the quarantined capsule of the given member
+//
+// Equivalent to redis commands, executed atomically as a script:
+//
+//	HDEL sortedSetKey/quarantine <member>
+//	HDEL sortedSetKey/payloads <capsule id> (only for claim-check references)
+func (r *RedisDataloader[P]) DeleteQuarantined(ctx context.Context, member string) error {
+	if len(r.shards) > 0 {
+		for _, shard := range r.shards {
+			err := shard.DeleteQuarantined(ctx, member)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return redisDeleteQuarantinedScript.Run(
+		ctx,
+		r.redisClient,
+		[]string{r.quarantineKey(), r.payloadsKey()},
+		member,
+		claimCheckReferencePrefix,
+	).Err()
+}
+
+// NextScheduledAt returns the timestamp when the earliest capsule is scheduled
+// to be dug out, ok is false if there is no capsule buried
+//
+// Equivalent to redis command:
+//
+//	ZRANGE sortedSetKey 0 0 WITHSCORES
+func (r *RedisDataloader[P]) NextScheduledAt(ctx context.Context) (int64, bool, error) {
+	if len(r.shards) > 0 {
+		return r.nextScheduledAtOfShards(ctx)
+	}
+
+	members, err := r.redisClient.ZRangeWithScores(ctx, r.sortedSetKey, 0, 0).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	if len(members) == 0 {
+		return 0, false, nil
+	}
+
+	return int64(members[0].Score), true, nil
+}
+
+// nextScheduledAtOfShards returns the earliest scheduled timestamp across the
+// shards of the topic, or across the owned shards if ctx carries them.
+func (r *RedisDataloader[P]) nextScheduledAtOfShards(ctx context.Context) (int64, bool, error) {
+	shards := shardsFromContext(ctx, len(r.shards))
+	cmds := make([]*redis.ZSliceCmd, len(shards))
+
+	_, err := r.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, shard := range shards {
+			cmds[i] = pipe.ZRangeWithScores(ctx, r.shards[shard].sortedSetKey, 0, 0)
+		}
+
+		return nil
+	})
 	if err != nil {
+		return 0, false, err
+	}
+
+	var earliest int64
+	var ok bool
+
+	for _, cmd := range cmds {
+		members := cmd.Val()
+		if len(members) == 0 {
+			continue
+		}
+		if !ok || int64(members[0].Score) < earliest {
+			earliest = int64(members[0].Score)
+			ok = true
+		}
+	}
+
+	return earliest, ok, nil
+}
+
+// Subscribe subscribes to the notifications of buried capsules, the scheduled
+// timestamps of the capsules buried earlier than the earliest buried one will
+// be sent to the returned channel, which will be closed once ctx is done
+//
+// Equivalent to redis command:
+//
+//	SUBSCRIBE sortedSetKey/buried
+func (r *RedisDataloader[P]) Subscribe(ctx context.Context) (<-chan int64, error) {
+	pubsub := r.redisClient.Subscribe(ctx, r.buriedChannel())
+
+	// wait for the confirmation of the subscription
+	_, err := pubsub.Receive(ctx)
+	if err != nil {
+		_ = pubsub.Close()
 		return nil, err
 	}
 
-	capsule.DugOutAt = now.UnixMilli()
+	notifications := make(chan int64)
 
-	return capsule, nil
+	go func() {
+		defer close(notifications)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				scheduledAt, err := strconv.ParseInt(message.Payload, 10, 64)
+				if err != nil {
+					continue
+				}
+
+				select {
+				case notifications <- scheduledAt:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return notifications, nil
+}
+
+// Release hands the leased capsule back to be dug out again once it is due, which
+// is immediately unless the capsule was leased before it is due
+//
+// Equivalent to redis commands, executed atomically as a script:
+//
+//	ZREM sortedSetKey/in-flight <capsule base64 string or claim-check reference>
+//	ZADD sortedSetKey <scheduled timestamp> <capsule base64 string or claim-check reference>
+func (r *RedisDataloader[P]) Release(ctx context.Context, capsule *TimeCapsule[P]) error {
+	if len(r.shards) > 0 {
+		return r.shardOf(capsule).Release(ctx, capsule)
+	}
+
+	_, _, err := lo.AttemptWithDelay(100, 10*time.Millisecond, func(i int, d time.Duration) error {
+		return redisReleaseScript.Run(
+			ctx,
+			r.redisClient,
+			[]string{r.sortedSetKey, r.inFlightKey()},
+			capsule.memberString(),
+			releaseScore(capsule, r.option.Clock.Now().UTC().UnixMilli()),
+		).Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
 // Destroy destroys the given capsule
 //
-// Equivalent to redis command:
+// Equivalent to redis commands, executed atomically as a script:
 //
-//	ZREM sortedSetKey <capsule base64 string>
+//	ZREM sortedSetKey <capsule base64 string or claim-check reference>
+//	ZREM sortedSetKey/in-flight <capsule base64 string or claim-check reference>
+//	HDEL sortedSetKey/payloads <capsule id> (only for claim-check references)
 func (r *RedisDataloader[P]) Destroy(ctx context.Context, capsule *TimeCapsule[P]) error {
+	if len(r.shards) > 0 {
+		return r.shardOf(capsule).Destroy(ctx, capsule)
+	}
+
 	_, _, err := lo.AttemptWithDelay(100, 10*time.Millisecond, func(i int, d time.Duration) error {
-		pipeline := r.redisClient.TxPipeline()
+		return redisDestroyScript.Run(
+			ctx,
+			r.redisClient,
+			[]string{r.sortedSetKey, r.payloadsKey(), r.inFlightKey()},
+			capsule.memberString(),
+			claimCheckReferencePrefix,
+		).Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
 
-		err := pipeline.ZRem(ctx, r.sortedSetKey, capsule.Base64String()).Err()
+// DestroyInTx queues the commands destroying the dug out capsule into pipe, so
+// that the capsule is acked atomically along with the other commands of the
+// caller once pipe is created by TxPipeline and executed.
+//
+// Equivalent to redis commands queued into pipe:
+//
+//	ZREM sortedSetKey <capsule base64 string or claim-check reference>
+//	ZREM sortedSetKey/in-flight <capsule base64 string or claim-check reference>
+//	HDEL sortedSetKey/payloads <capsule id> (only for claim-check references)
+func (r *RedisDataloader[P]) DestroyInTx(ctx context.Context, pipe redis.Pipeliner, capsule *TimeCapsule[P]) {
+	if len(r.shards) > 0 {
+		r.shardOf(capsule).DestroyInTx(ctx, pipe, capsule)
+		return
+	}
+
+	member := capsule.memberString()
+
+	pipe.ZRem(ctx, r.sortedSetKey, member)
+	pipe.ZRem(ctx, r.inFlightKey(), member)
+
+	if strings.HasPrefix(member, claimCheckReferencePrefix) {
+		pipe.HDel(ctx, r.payloadsKey(), strings.TrimPrefix(member, claimCheckReferencePrefix))
+	}
+}
+
+// RedisTxHandlerFunc is the function to handle the capsules dug out by the
+// digger, which queues its writes into pipe instead of executing them, see
+// TxHandlerFunc.
+type RedisTxHandlerFunc[P any] func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P], pipe redis.Pipeliner) error
+
+// TxHandlerFunc returns the handler to be set by SetHandlerFunc of the digger,
+// which executes the writes queued by txHandlerFunc and destroys the capsule
+// atomically in one MULTI / EXEC transaction, see DestroyInTx. Nothing is
+// executed if txHandlerFunc returns an error.
+//
+// Redis does not roll back a transaction, the capsule is acked once the
+// commands of DestroyInTx succeed even if some commands queued by
+// txHandlerFunc failed, and the digger will neither retry nor dead letter such
+// capsule. In Redis Cluster, the keys written by txHandlerFunc should be in the
+// slot of sortedSetKey, or of the shard the capsule is routed to when the
+// topic is sharded.
+func (r *RedisDataloader[P]) TxHandlerFunc(txHandlerFunc RedisTxHandlerFunc[P]) HandlerFunc[P] {
+	return func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error {
+		pipe := r.redisClient.TxPipeline()
+
+		err := txHandlerFunc(ctx, digger, capsule, pipe)
 		if err != nil {
+			pipe.Discard()
 			return err
 		}
 
-		_, err = pipeline.Exec(ctx)
-		if err != nil {
-			return err
+		queued := pipe.Len()
+		r.DestroyInTx(ctx, pipe, capsule)
+
+		cmds, err := pipe.Exec(ctx)
+		if len(cmds) > queued {
+			capsule.acked = lo.EveryBy(cmds[queued:], func(cmd redis.Cmder) bool {
+				return cmd.Err() == nil
+			})
 		}
 
-		return nil
-	})
-	if err != nil {
 		return err
 	}
-
-	return nil
 }
 
 func (r *RedisDataloader[P]) DestroyAll(ctx context.Context) error {
+	if len(r.shards) > 0 {
+		for _, shard := range r.shards {
+			err := shard.DestroyAll(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
 	_, _, err := lo.AttemptWithDelay(100, 10*time.Millisecond, func(i int, d time.Duration) error {
-		return r.redisClient.Del(ctx, r.sortedSetKey).Err()
+		return r.redisClient.Del(ctx, r.sortedSetKey, r.payloadsKey(), r.inFlightKey()).Err()
 	})
 	if err != nil {
 		return err
@@ -179,3 +1060,342 @@ func (r *RedisDataloader[P]) DestroyAll(ctx context.Context) error {
 
 	return nil
 }
+
+// DeadLetter moves the capsule which could not be handled to the dead letters
+//
+// Equivalent to redis command:
+//
+//	HSET sortedSetKey/dead-letters <member> <dead letter record>
+func (r *RedisDataloader[P]) DeadLetter(ctx context.Context, capsule *TimeCapsule[P], reason error) error {
+	if len(r.shards) > 0 {
+		return r.shardOf(capsule).DeadLetter(ctx, capsule, reason)
+	}
+
+	member := capsule.memberString()
+	return r.redisClient.HSet(ctx, r.deadLettersKey(), member, newDeadLetterRecord(member, capsule.Base64String(), reason, r.option.Clock.Now().UTC().UnixMilli())).Err()
+}
+
+// DeadLetters lists the capsules which were dead lettered.
+//
+// Equivalent to redis command:
+//
+//	HGETALL sortedSetKey/dead-letters
+func (r *RedisDataloader[P]) DeadLetters(ctx context.Context) ([]*DeadLetteredCapsule, error) {
+	if len(r.shards) > 0 {
+		deadLetteredCapsules := make([]*DeadLetteredCapsule, 0)
+
+		for _, shard := range r.shards {
+			shardDeadLetteredCapsules, err := shard.DeadLetters(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			deadLetteredCapsules = append(deadLetteredCapsules, shardDeadLetteredCapsules...)
+		}
+
+		return deadLetteredCapsules, nil
+	}
+
+	records, err := r.redisClient.HGetAll(ctx, r.deadLettersKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetteredCapsules := make([]*DeadLetteredCapsule, 0, len(records))
+
+	for member, record := range records {
+		deadLetteredCapsule, err := parseDeadLetterRecord(member, record)
+		if err != nil {
+			return nil, err
+		}
+
+		deadLetteredCapsules = append(deadLetteredCapsules, deadLetteredCapsule)
+	}
+
+	return deadLetteredCapsules, nil
+}
+
+// DeleteDeadLetter deletes the dead lettered capsule of the given member
+//
+// Equivalent to redis command:
+//
+//	HDEL sortedSetKey/dead-letters <member>
+func (r *RedisDataloader[P]) DeleteDeadLetter(ctx context.Context, member string) error {
+	if len(r.shards) > 0 {
+		for _, shard := range r.shards {
+			err := shard.DeleteDeadLetter(ctx, member)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return r.redisClient.HDel(ctx, r.deadLettersKey(), member).Err()
+}
+
+// Pause pauses the topic for all the diggers digging from the sorted set, no
+// capsules will be dug out until Resume is called, burying capsules keeps working
+//
+// Equivalent to redis command:
+//
+//	SET sortedSetKey/paused <now timestamp>
+func (r *RedisDataloader[P]) Pause(ctx context.Context) error {
+	return r.redisClient.Set(ctx, r.pausedKey(), r.option.Clock.Now().UTC().UnixMilli(), 0).Err()
+}
+
+// Resume resumes the topic paused by Pause
+//
+// Equivalent to redis command:
+//
+//	DEL sortedSetKey/paused
+func (r *RedisDataloader[P]) Resume(ctx context.Context) error {
+	return r.redisClient.Del(ctx, r.pausedKey()).Err()
+}
+
+// Paused reports whether the topic is paused
+//
+// Equivalent to redis command:
+//
+//	EXISTS sortedSetKey/paused
+func (r *RedisDataloader[P]) Paused(ctx context.Context) (bool, error) {
+	exists, err := r.redisClient.Exists(ctx, r.pausedKey()).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return exists == 1, nil
+}
+
+// AcquireLeadership acquires the leadership of the topic for the holder, or
+// renews it if the holder is already the leader. The leadership lapses after the
+// lease duration unless it is renewed, a new fencing token is issued every time
+// the leadership is acquired
+//
+// Equivalent to redis command flow, executed atomically as a script:
+//
+//	HGET sortedSetKey/leader holder, return if another holder is the leader
+//	                            |
+//	INCR sortedSetKey/fencing-token, only if there is no leader
+//	                            |
+//	HSET sortedSetKey/leader holder <holder id> token <fencing token>
+//	                            |
+//	PEXPIRE sortedSetKey/leader <lease duration>
+func (r *RedisDataloader[P]) AcquireLeadership(ctx context.Context, holderID string, leaseDuration time.Duration) (int64, bool, error) {
+	fencingToken, err := redisAcquireLeadershipScript.Run(
+		ctx,
+		r.redisClient,
+		[]string{r.leaderKey(), r.fencingTokenKey()},
+		holderID,
+		leaseDuration.Milliseconds(),
+	).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, false, nil
+		}
+
+		return 0, false, err
+	}
+
+	return fencingToken, true, nil
+}
+
+// ResignLeadership gives up the leadership of the topic if the holder is the
+// leader, so that another digger can take over without waiting for the lease to
+// lapse
+//
+// Equivalent to redis command flow, executed atomically as a script:
+//
+//	HGET sortedSetKey/leader holder, return if another holder is the leader
+//	                            |
+//	DEL sortedSetKey/leader
+func (r *RedisDataloader[P]) ResignLeadership(ctx context.Context, holderID string) error {
+	return redisResignLeadershipScript.Run(
+		ctx,
+		r.redisClient,
+		[]string{r.leaderKey()},
+		holderID,
+	).Err()
+}
+
+// Heartbeat registers the member of the topic or extends its membership for the
+// TTL, and returns the shards owned by the member. The shards are distributed
+// among the live members in a round-robin manner by the order of the member
+// IDs. An unsharded topic has a single shard 0 owned by all the members
+//
+// Equivalent to redis command flow, executed atomically as a script:
+//
+//	ZREMRANGEBYSCORE sortedSetKey/members -inf (<now timestamp>
+//	                            |
+//	ZADD sortedSetKey/members <now timestamp + ttl> <member id>
+//	                            |
+//	PEXPIRE sortedSetKey/members <ttl>
+//	                            |
+//	ZRANGE sortedSetKey/members 0 -1
+func (r *RedisDataloader[P]) Heartbeat(ctx context.Context, memberID string, ttl time.Duration) ([]int, error) {
+	now := r.option.Clock.Now().UTC()
+
+	members, err := redisHeartbeatScript.Run(
+		ctx,
+		r.redisClient,
+		[]string{r.membersKey()},
+		now.UnixMilli(),
+		now.Add(ttl).UnixMilli(),
+		memberID,
+		ttl.Milliseconds(),
+	).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	if len(r.shards) == 0 {
+		return []int{0}, nil
+	}
+
+	return ownedShards(members, memberID, len(r.shards)), nil
+}
+
+// Leave removes the member of the topic
+//
+// Equivalent to redis command:
+//
+//	ZREM sortedSetKey/members <member id>
+func (r *RedisDataloader[P]) Leave(ctx context.Context, memberID string) error {
+	return r.redisClient.ZRem(ctx, r.membersKey(), memberID).Err()
+}
+
+// Reshard migrates the pending capsules from the layout of the given number of
+// shards to the current layout of the topic, such as after changing
+// DataloaderOption.Shards, and returns the number of the migrated capsules. Zero
+// or one means the capsules were buried into the unsharded sorted set.
+//
+// The capsules whose leases have expired are migrated as well, while the leased
+// ones are left for their diggers. Reshard should be called once all the
+// diggers use the current number of shards, and once more after the lease
+// duration to migrate the capsules which were being handled. A capsule is
+// buried into its new shard before it is removed from the old one, so it may be
+// handled twice if it is dug out of the old shard in between, but is never lost.
+// Capsules which fail to decode are left to be quarantined by digging.
+//
+// Equivalent to redis commands for each old shard:
+//
+//	ZRANGEBYSCORE <old shard>/in-flight -inf <now timestamp>
+//	ZREM <old shard>/in-flight <member> + ZADD <old shard> <now timestamp> <member>
+//	ZRANGE <old shard> <offset> <offset + 99> WITHSCORES
+//	HGET <old shard>/payloads <capsule id> (only for claim-check references)
+//
+// then BuryCapsule into the new shard, and Destroy from the old shard.
+func (r *RedisDataloader[P]) Reshard(ctx context.Context, fromShards int) (int, error) {
+	moved := 0
+
+	for _, source := range r.reshardSources(fromShards) {
+		sourceMoved, err := r.reshardFrom(ctx, source)
+		moved += sourceMoved
+
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
+
+// reshardSources returns the shards of the layout of the given number of shards.
+func (r *RedisDataloader[P]) reshardSources(fromShards int) []*RedisDataloader[P] {
+	sourceOption := r.option
+	sourceOption.Shards = 0
+
+	if fromShards <= 1 {
+		return []*RedisDataloader[P]{{sortedSetKey: r.sortedSetKey, redisClient: r.redisClient, option: sourceOption}}
+	}
+
+	sources := make([]*RedisDataloader[P], fromShards)
+	for i := range sources {
+		sources[i] = &RedisDataloader[P]{sortedSetKey: shardKey(r.sortedSetKey, i), redisClient: r.redisClient, option: sourceOption}
+	}
+
+	return sources
+}
+
+// reshardTarget returns the sorted set key the capsule belongs to in the
+// current layout.
+func (r *RedisDataloader[P]) reshardTarget(capsule *TimeCapsule[P]) string {
+	if len(r.shards) == 0 {
+		return r.sortedSetKey
+	}
+
+	return r.shardOf(capsule).sortedSetKey
+}
+
+// reshardFrom migrates the pending capsules of the old shard.
+func (r *RedisDataloader[P]) reshardFrom(ctx context.Context, source *RedisDataloader[P]) (int, error) {
+	now := r.option.Clock.Now().UTC().UnixMilli()
+
+	expired, err := r.redisClient.ZRangeByScore(ctx, source.inFlightKey(), &redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(now, 10)}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, member := range expired {
+		err = redisReleaseScript.Run(ctx, r.redisClient, []string{source.sortedSetKey, source.inFlightKey()}, member, now).Err()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	moved := 0
+	// the members which are left in the old shard stay in front of the ones
+	// yet to be migrated
+	skipped := 0
+
+	for {
+		members, err := r.redisClient.ZRangeWithScores(ctx, source.sortedSetKey, int64(skipped), int64(skipped+99)).Result()
+		if err != nil {
+			return moved, err
+		}
+		if len(members) == 0 {
+			return moved, nil
+		}
+
+		for _, z := range members {
+			member, _ := z.Member.(string)
+
+			content := member
+			if capsuleID, ok := claimCheckCapsuleID(member); ok {
+				content, err = r.redisClient.HGet(ctx, source.payloadsKey(), capsuleID).Result()
+				if err != nil && !errors.Is(err, redis.Nil) {
+					return moved, err
+				}
+			}
+
+			capsule, err := NewTimeCapsuleFromBase64String[P](content)
+			if err != nil {
+				skipped++
+				continue
+			}
+
+			capsule.member = member
+
+			err = capsule.ensureID()
+			if err != nil {
+				return moved, err
+			}
+			if r.reshardTarget(capsule) == source.sortedSetKey {
+				skipped++
+				continue
+			}
+
+			err = r.BuryCapsule(ctx, capsule, int64(z.Score))
+			if err != nil {
+				return moved, err
+			}
+
+			err = source.Destroy(ctx, capsule)
+			if err != nil {
+				return moved, err
+			}
+
+			moved++
+		}
+	}
+}
@@ -3,31 +3,73 @@ package timecapsule
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"math/big"
 	"net"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-var (
-	sortedSetKeyRedis = "test/timecapsule/redis/zset"
-	redisv5Client     = redis.NewClient(&redis.Options{Addr: net.JoinHostPort("localhost", "6379")})
-	redisv6Client     = redis.NewClient(&redis.Options{Addr: net.JoinHostPort("localhost", "6380")})
-	redisv7Client     = redis.NewClient(&redis.Options{Addr: net.JoinHostPort("localhost", "6381")})
-)
+var sortedSetKeyRedis = "test/timecapsule/redis/zset"
+
+// Empty unless setupRedisDataloaders is called by TestMain
+var redisDataloaders map[string]*RedisDataloader[any]
+
+// setupRedisDataloaders connects to Redis 5, 6 and 7 listening on 6379, 6380
+// and 6381, it panics if any of them does not respond.
+func setupRedisDataloaders() {
+	redisv5Client := redis.NewClient(&redis.Options{Addr: net.JoinHostPort("localhost", "6379")})
+	redisv6Client := redis.NewClient(&redis.Options{Addr: net.JoinHostPort("localhost", "6380")})
+	redisv7Client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{net.JoinHostPort("localhost", "6381")}})
+
+	lo.Must0(redisv5Client.Ping(context.Background()).Err())
+	lo.Must0(redisv6Client.Ping(context.Background()).Err())
+	lo.Must0(redisv7Client.Ping(context.Background()).Err())
+
+	redisDataloaders = map[string]*RedisDataloader[any]{
+		"Redis/redis:5": NewRedisDataloader[any](sortedSetKeyRedis, redisv5Client),
+		"Redis/redis:6": NewRedisDataloader[any](sortedSetKeyRedis, redisv6Client),
+		"Redis/redis:7": NewRedisDataloader[any](sortedSetKeyRedis, redisv7Client),
+	}
+}
+
+// roundTripCounter is a hook counting the round trips of a client, a pipeline
+// is counted as one round trip.
+type roundTripCounter struct {
+	roundTrips atomic.Int64
+}
+
+func (c *roundTripCounter) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (c *roundTripCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c.roundTrips.Add(1)
+		return next(ctx, cmd)
+	}
+}
 
-var redisDataloaders = map[string]*RedisDataloader[any]{
-	"Redis/redis:5": NewRedisDataloader[any](sortedSetKeyRedis, redisv5Client),
-	"Redis/redis:6": NewRedisDataloader[any](sortedSetKeyRedis, redisv6Client),
-	"Redis/redis:7": NewRedisDataloader[any](sortedSetKeyRedis, redisv7Client),
+func (c *roundTripCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		c.roundTrips.Add(1)
+		return next(ctx, cmds)
+	}
 }
 
 func TestRedisDataloader(t *testing.T) {
+	if len(redisDataloaders) == 0 {
+		t.Skipf("%s is set", skipRedisTestsEnv)
+	}
+
 	for k, d := range redisDataloaders {
 		d := d
 
@@ -123,7 +165,7 @@ func TestRedisDataloader(t *testing.T) {
 					require.NoError(err)
 
 					defer func() {
-						err = d.redisClient.Del(context.Background(), d.sortedSetKey).Err()
+						err = d.redisClient.Del(context.Background(), d.sortedSetKey, d.inFlightKey()).Err()
 						assert.NoError(err)
 					}()
 
@@ -178,7 +220,8 @@ func TestRedisDataloader(t *testing.T) {
 				})
 			})
 
-			t.Run("Destroy", func(t *testing.T) {
+			t.Run("DigUtil", func(t *testing.T) {
+				assert := assert.New(t)
 				require := require.New(t)
 
 				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
@@ -186,19 +229,1140 @@ func TestRedisDataloader(t *testing.T) {
 
 				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
 
-				err = d.BuryUtil(context.Background(), "shouldBeDugOut", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				scheduledAt := time.Now().UTC().Add(time.Minute).UnixMilli()
+
+				err = d.BuryUtil(context.Background(), "shouldBePrefetched", scheduledAt)
 				require.NoError(err)
 
+				defer func() {
+					err = d.redisClient.Del(context.Background(), d.sortedSetKey, d.inFlightKey()).Err()
+					assert.NoError(err)
+				}()
+
 				capsule, err := d.Dig(context.Background())
 				require.NoError(err)
+				assert.Nil(capsule)
+
+				capsule, err = d.DigUtil(context.Background(), scheduledAt)
+				require.NoError(err)
 				require.NotNil(capsule)
+				assert.Equal("shouldBePrefetched", capsule.Payload)
+				assert.Equal(scheduledAt, capsule.ScheduledAt)
 
-				err = d.Destroy(context.Background(), capsule)
+				err = d.Release(context.Background(), capsule)
 				require.NoError(err)
 
-				mems, err := d.redisClient.ZRangeWithScores(context.Background(), d.sortedSetKey, 0, -1).Result()
+				nextScheduledAt, ok, err := d.NextScheduledAt(context.Background())
 				require.NoError(err)
-				require.Len(mems, 0)
+				assert.True(ok)
+				assert.Equal(scheduledAt, nextScheduledAt)
+			})
+
+			t.Run("NextScheduledAt", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				_, ok, err := d.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.False(ok)
+
+				scheduledAt := time.Now().UTC().Add(time.Minute).UnixMilli()
+
+				err = d.BuryUtil(context.Background(), "later", scheduledAt+1000)
+				require.NoError(err)
+				err = d.BuryUtil(context.Background(), "earliest", scheduledAt)
+				require.NoError(err)
+
+				defer func() {
+					err = d.redisClient.Del(context.Background(), d.sortedSetKey).Err()
+					assert.NoError(err)
+				}()
+
+				nextScheduledAt, ok, err := d.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.True(ok)
+				assert.Equal(scheduledAt, nextScheduledAt)
+			})
+
+			t.Run("Subscribe", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				notifications, err := d.Subscribe(ctx)
+				require.NoError(err)
+
+				scheduledAt := time.Now().UTC().Add(time.Minute).UnixMilli()
+
+				err = d.BuryUtil(context.Background(), "shouldBeNotified", scheduledAt)
+				require.NoError(err)
+
+				defer func() {
+					err = d.redisClient.Del(context.Background(), d.sortedSetKey).Err()
+					assert.NoError(err)
+				}()
+
+				// not notified, the capsule buried earlier is due before it
+				err = d.BuryUtil(context.Background(), "shouldNotBeNotified", scheduledAt+1000)
+				require.NoError(err)
+
+				err = d.BuryUtil(context.Background(), "shouldBeNotifiedEarlier", scheduledAt-1000)
+				require.NoError(err)
+
+				for _, expected := range []int64{scheduledAt, scheduledAt - 1000} {
+					select {
+					case notifiedScheduledAt := <-notifications:
+						assert.Equal(expected, notifiedScheduledAt)
+					case <-time.After(5 * time.Second):
+						require.Fail("buried capsule should be notified")
+					}
+				}
+
+				cancel()
+
+				select {
+				case _, ok := <-notifications:
+					assert.False(ok)
+				case <-time.After(5 * time.Second):
+					require.Fail("notifications should be closed once ctx is done")
+				}
+			})
+
+			t.Run("Release", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				err = d.BuryUtil(context.Background(), "shouldBeReleased", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				defer func() {
+					err = d.redisClient.Del(context.Background(), d.sortedSetKey, d.inFlightKey()).Err()
+					assert.NoError(err)
+				}()
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				inFlightCount, err := d.redisClient.ZCard(context.Background(), d.inFlightKey()).Result()
+				require.NoError(err)
+				assert.Equal(int64(1), inFlightCount)
+
+				err = d.Release(context.Background(), capsule)
+				require.NoError(err)
+
+				inFlightCount, err = d.redisClient.ZCard(context.Background(), d.inFlightKey()).Result()
+				require.NoError(err)
+				assert.Zero(inFlightCount)
+
+				releasedCapsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(releasedCapsule)
+				assert.Equal("shouldBeReleased", releasedCapsule.Payload)
+
+				err = d.Destroy(context.Background(), releasedCapsule)
+				require.NoError(err)
+
+				err = d.Release(context.Background(), releasedCapsule)
+				require.NoError(err)
+
+				capsule, err = d.Dig(context.Background())
+				require.NoError(err)
+				assert.Nil(capsule)
+			})
+
+			t.Run("LeaseExpiry", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				d.option.LeaseDuration = time.Millisecond
+
+				defer func() {
+					d.option.LeaseDuration = DefaultDataloaderOption().LeaseDuration
+				}()
+
+				err = d.BuryUtil(context.Background(), "shouldBeLeasedAgain", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				defer func() {
+					err = d.redisClient.Del(context.Background(), d.sortedSetKey, d.inFlightKey()).Err()
+					assert.NoError(err)
+				}()
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				time.Sleep(5 * time.Millisecond)
+
+				capsule, err = d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldBeLeasedAgain", capsule.Payload)
+			})
+
+			t.Run("Pause", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				err = d.Pause(context.Background())
+				require.NoError(err)
+
+				defer func() {
+					err = d.redisClient.Del(context.Background(), d.sortedSetKey, d.inFlightKey(), d.pausedKey()).Err()
+					assert.NoError(err)
+				}()
+
+				paused, err := d.Paused(context.Background())
+				require.NoError(err)
+				assert.True(paused)
+
+				err = d.BuryUtil(context.Background(), "shouldBeDugOutOnceResumed", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				assert.Nil(capsule)
+
+				err = d.Resume(context.Background())
+				require.NoError(err)
+
+				paused, err = d.Paused(context.Background())
+				require.NoError(err)
+				assert.False(paused)
+
+				capsule, err = d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldBeDugOutOnceResumed", capsule.Payload)
+			})
+
+			t.Run("LeaderElection", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				defer func() {
+					err = d.redisClient.Del(context.Background(), d.sortedSetKey, d.inFlightKey(), d.leaderKey(), d.fencingTokenKey()).Err()
+					assert.NoError(err)
+				}()
+
+				fencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+				require.NoError(err)
+				assert.True(acquired)
+
+				_, acquired, err = d.AcquireLeadership(context.Background(), "holder-b", time.Minute)
+				require.NoError(err)
+				assert.False(acquired)
+
+				renewedFencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+				require.NoError(err)
+				assert.True(acquired)
+				assert.Equal(fencingToken, renewedFencingToken)
+
+				ttl, err := d.redisClient.PTTL(context.Background(), d.leaderKey()).Result()
+				require.NoError(err)
+				assert.Greater(ttl, time.Duration(0))
+				assert.LessOrEqual(ttl, time.Minute)
+
+				err = d.BuryUtil(context.Background(), "shouldBeDugOutByLeader", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err := d.Dig(withFencingToken(context.Background(), fencingToken))
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldBeDugOutByLeader", capsule.Payload)
+
+				// the lease of holder-a lapses
+				err = d.redisClient.Del(context.Background(), d.leaderKey()).Err()
+				require.NoError(err)
+
+				takenOverFencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-b", time.Minute)
+				require.NoError(err)
+				assert.True(acquired)
+				assert.Greater(takenOverFencingToken, fencingToken)
+
+				err = d.BuryUtil(context.Background(), "shouldNotBeDugOutByStaleLeader", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err = d.Dig(withFencingToken(context.Background(), fencingToken))
+				require.ErrorIs(err, ErrNotLeader)
+				assert.Nil(capsule)
+
+				err = d.ResignLeadership(context.Background(), "holder-a")
+				require.NoError(err)
+
+				_, acquired, err = d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+				require.NoError(err)
+				assert.False(acquired)
+
+				err = d.ResignLeadership(context.Background(), "holder-b")
+				require.NoError(err)
+
+				reacquiredFencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+				require.NoError(err)
+				assert.True(acquired)
+				assert.Greater(reacquiredFencingToken, takenOverFencingToken)
+
+				capsule, err = d.Dig(withFencingToken(context.Background(), reacquiredFencingToken))
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldNotBeDugOutByStaleLeader", capsule.Payload)
+			})
+
+			t.Run("Sharded", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sharded := NewRedisDataloader[any](fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64()), d.redisClient, DataloaderOption{Shards: 4})
+				require.Len(sharded.shards, 4)
+
+				defer func() {
+					err = sharded.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					err = sharded.redisClient.Del(context.Background(), sharded.pausedKey()).Err()
+					assert.NoError(err)
+				}()
+
+				subscribeCtx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				notifications, err := sharded.Subscribe(subscribeCtx)
+				require.NoError(err)
+
+				now := time.Now().UTC().UnixMilli()
+
+				for i := 0; i < 8; i++ {
+					err = sharded.BuryUtil(context.Background(), fmt.Sprintf("capsule %d", i), now-int64(100-i))
+					require.NoError(err)
+				}
+
+				select {
+				case notified := <-notifications:
+					assert.Equal(now-100, notified)
+				case <-time.After(5 * time.Second):
+					assert.Fail("shards should publish to the buried channel of the topic")
+				}
+
+				buried := 0
+				usedShards := 0
+
+				for _, shard := range sharded.shards {
+					count, err := sharded.redisClient.ZCard(context.Background(), shard.sortedSetKey).Result()
+					require.NoError(err)
+
+					buried += int(count)
+					if count > 0 {
+						usedShards++
+					}
+				}
+
+				assert.Equal(8, buried)
+				assert.Greater(usedShards, 1)
+
+				scheduledAt, ok, err := sharded.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.True(ok)
+				assert.Equal(now-100, scheduledAt)
+
+				err = sharded.Pause(context.Background())
+				require.NoError(err)
+
+				capsule, err := sharded.Dig(context.Background())
+				require.NoError(err)
+				assert.Nil(capsule)
+
+				err = sharded.Resume(context.Background())
+				require.NoError(err)
+
+				capsule, err = sharded.Dig(withFencingToken(context.Background(), 42))
+				require.ErrorIs(err, ErrNotLeader)
+				assert.Nil(capsule)
+
+				capsule, err = sharded.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("capsule 0", capsule.Payload)
+
+				err = sharded.Release(context.Background(), capsule)
+				require.NoError(err)
+
+				for i := 0; i < 8; i++ {
+					capsule, err = sharded.Dig(context.Background())
+					require.NoError(err)
+					require.NotNil(capsule)
+					assert.Equal(fmt.Sprintf("capsule %d", i), capsule.Payload)
+					assert.Equal(now-int64(100-i), capsule.ScheduledAt)
+
+					err = sharded.Destroy(context.Background(), capsule)
+					require.NoError(err)
+				}
+
+				capsule, err = sharded.Dig(context.Background())
+				require.NoError(err)
+				assert.Nil(capsule)
+
+				_, ok, err = sharded.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.False(ok)
+			})
+
+			t.Run("ServerTime", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				// the clock of the dataloaders lags behind Redis by an hour
+				clock := NewFakeClock(time.Now().Add(-time.Hour))
+
+				// the cluster client routes TIME apart from the peeks of the
+				// shards, which are spread over several slots
+				clusterClient := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{d.redisClient.(*redis.Client).Options().Addr}})
+				defer clusterClient.Close()
+
+				for _, redisClient := range []redis.UniversalClient{d.redisClient, clusterClient} {
+					for _, shards := range []int{0, 4} {
+						randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+						require.NoError(err)
+
+						sortedSetKey := fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+						skewed := NewRedisDataloader[any](sortedSetKey, redisClient, DataloaderOption{Clock: clock, Shards: shards})
+						serverTimed := NewRedisDataloader[any](sortedSetKey, redisClient, DataloaderOption{Clock: clock, Shards: shards, ServerTime: true})
+
+						scheduledAt := time.Now().UTC().Add(-5 * time.Millisecond).UnixMilli()
+
+						err = skewed.BuryUtil(context.Background(), fmt.Sprintf("shouldBeDugOutByServerTime %d", shards), scheduledAt)
+						require.NoError(err)
+
+						err = skewed.BuryUtil(context.Background(), fmt.Sprintf("shouldBePrefetchedByServerTime %d", shards), time.Now().UTC().Add(30*time.Second).UnixMilli())
+						require.NoError(err)
+
+						capsule, err := skewed.Dig(context.Background())
+						require.NoError(err)
+						require.Nil(capsule)
+
+						capsule, err = serverTimed.Dig(context.Background())
+						require.NoError(err)
+						require.NotNil(capsule)
+
+						now := time.Now().UTC().UnixMilli()
+
+						assert.Equal(fmt.Sprintf("shouldBeDugOutByServerTime %d", shards), capsule.Payload)
+						assert.GreaterOrEqual(capsule.DugOutAt, scheduledAt)
+						assert.LessOrEqual(capsule.DugOutAt, now)
+						assert.Equal(capsule.DugOutAt+DefaultDataloaderOption().LeaseDuration.Milliseconds(), capsule.leaseDeadline)
+
+						capsule, err = serverTimed.Dig(context.Background())
+						require.NoError(err)
+						require.Nil(capsule)
+
+						// the due timestamp is shifted by the skew as well
+						capsule, err = serverTimed.DigUtil(context.Background(), clock.Now().Add(time.Minute).UnixMilli())
+						require.NoError(err)
+						require.NotNil(capsule)
+						assert.Equal(fmt.Sprintf("shouldBePrefetchedByServerTime %d", shards), capsule.Payload)
+
+						err = serverTimed.DestroyAll(context.Background())
+						require.NoError(err)
+					}
+				}
+			})
+
+			t.Run("ShardDigKeys", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sharded := NewRedisDataloader[any](fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64()), d.redisClient, DataloaderOption{Shards: 4})
+
+				shardPausedKeys := make([]string, 0, len(sharded.shards))
+				for _, shard := range sharded.shards {
+					shardPausedKeys = append(shardPausedKeys, shard.pausedKey())
+				}
+
+				// only the paused flag of the topic pauses the shards
+				for _, key := range shardPausedKeys {
+					err = d.redisClient.Set(context.Background(), key, 1, 0).Err()
+					require.NoError(err)
+				}
+
+				defer func() {
+					err = sharded.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					err = d.redisClient.Del(context.Background(), shardPausedKeys...).Err()
+					assert.NoError(err)
+				}()
+
+				err = sharded.BuryUtil(context.Background(), "shouldBeDugOut", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err := sharded.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldBeDugOut", capsule.Payload)
+			})
+
+			t.Run("ShardOwnership", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sharded := NewRedisDataloader[any](fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64()), d.redisClient, DataloaderOption{Shards: 4})
+
+				defer func() {
+					err = sharded.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					err = d.redisClient.Del(context.Background(), sharded.membersKey()).Err()
+					assert.NoError(err)
+				}()
+
+				owned, err := sharded.Heartbeat(context.Background(), "member-a", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{0, 1, 2, 3}, owned)
+
+				owned, err = sharded.Heartbeat(context.Background(), "member-b", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{1, 3}, owned)
+
+				owned, err = sharded.Heartbeat(context.Background(), "member-a", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{0, 2}, owned)
+
+				for i := 0; i < 8; i++ {
+					err = sharded.BuryUtil(context.Background(), fmt.Sprintf("capsule %d", i), time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+					require.NoError(err)
+				}
+
+				for {
+					capsule, err := sharded.Dig(withShards(context.Background(), owned))
+					require.NoError(err)
+
+					if capsule == nil {
+						break
+					}
+
+					assert.Contains(owned, shardOf(capsule.ID, 4))
+				}
+
+				scheduledAt, ok, err := sharded.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.True(ok, "capsules of the shards owned by member-b should be left")
+				assert.Positive(scheduledAt)
+
+				_, ok, err = sharded.NextScheduledAt(withShards(context.Background(), owned))
+				require.NoError(err)
+				assert.False(ok)
+
+				err = sharded.Leave(context.Background(), "member-b")
+				require.NoError(err)
+
+				owned, err = sharded.Heartbeat(context.Background(), "member-a", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{0, 1, 2, 3}, owned)
+
+				owned, err = sharded.Heartbeat(context.Background(), "member-b", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{1, 3}, owned)
+
+				// the membership of member-b lapses
+				_, err = sharded.Heartbeat(context.Background(), "member-b", -time.Minute)
+				require.NoError(err)
+
+				owned, err = sharded.Heartbeat(context.Background(), "member-a", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{0, 1, 2, 3}, owned)
+
+				owned, err = d.Heartbeat(context.Background(), "member-a", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{0}, owned)
+
+				err = d.redisClient.Del(context.Background(), d.membersKey()).Err()
+				require.NoError(err)
+			})
+
+			t.Run("Reshard", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sortedSetKey := fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				unsharded := NewRedisDataloader[any](sortedSetKey, d.redisClient, DataloaderOption{LeaseDuration: time.Millisecond})
+				sharded := NewRedisDataloader[any](sortedSetKey, d.redisClient, DataloaderOption{Shards: 4})
+				resharded := NewRedisDataloader[any](sortedSetKey, d.redisClient, DataloaderOption{Shards: 2})
+
+				defer func() {
+					for _, loader := range []interface{ DestroyAll(context.Context) error }{unsharded, sharded, resharded} {
+						err = loader.DestroyAll(context.Background())
+						assert.NoError(err)
+					}
+				}()
+
+				payloads := make([]any, 0, 6)
+
+				for i := 0; i < 6; i++ {
+					payloads = append(payloads, fmt.Sprintf("capsule %d", i))
+
+					err = unsharded.BuryUtil(context.Background(), fmt.Sprintf("capsule %d", i), time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+					require.NoError(err)
+				}
+
+				// leased by a digger which is gone, the lease expires before resharding
+				capsule, err := unsharded.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				time.Sleep(5 * time.Millisecond)
+
+				moved, err := sharded.Reshard(context.Background(), 0)
+				require.NoError(err)
+				assert.Equal(6, moved)
+
+				count, err := d.redisClient.ZCard(context.Background(), unsharded.sortedSetKey).Result()
+				require.NoError(err)
+				assert.Zero(count)
+
+				count, err = d.redisClient.ZCard(context.Background(), unsharded.inFlightKey()).Result()
+				require.NoError(err)
+				assert.Zero(count)
+
+				_, err = resharded.Reshard(context.Background(), 4)
+				require.NoError(err)
+
+				buried := 0
+
+				for i := 0; i < 4; i++ {
+					count, err = d.redisClient.ZCard(context.Background(), shardKey(sortedSetKey, i)).Result()
+					require.NoError(err)
+
+					if i >= 2 {
+						assert.Zero(count)
+					}
+
+					buried += int(count)
+				}
+
+				assert.Equal(6, buried)
+
+				dugPayloads := make([]any, 0, 6)
+
+				for {
+					capsule, err = resharded.Dig(context.Background())
+					require.NoError(err)
+
+					if capsule == nil {
+						break
+					}
+
+					assert.Equal(shardKey(sortedSetKey, shardOf(capsule.ID, 2)), resharded.shardOf(capsule).sortedSetKey)
+					dugPayloads = append(dugPayloads, capsule.Payload)
+
+					err = resharded.Destroy(context.Background(), capsule)
+					require.NoError(err)
+				}
+
+				assert.ElementsMatch(payloads, dugPayloads)
+			})
+
+			t.Run("BuryMany", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sortedSetKey := fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				dataloader := NewRedisDataloader[any](sortedSetKey, d.redisClient, DataloaderOption{ClaimCheckThreshold: 128})
+				sharded := NewRedisDataloader[any](sortedSetKey+"/sharded", d.redisClient, DataloaderOption{Shards: 4})
+
+				defer func() {
+					err = dataloader.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					err = sharded.DestroyAll(context.Background())
+					assert.NoError(err)
+				}()
+
+				now := time.Now().UTC()
+				entries := make([]Entry[any], 0, 6)
+				payloads := make([]any, 0, 6)
+
+				for i := 0; i < 5; i++ {
+					entries = append(entries, Entry[any]{Payload: fmt.Sprintf("capsule %d", i), UtilUnixMilliTimestamp: now.Add(time.Duration(i-10) * time.Millisecond).UnixMilli()})
+					payloads = append(payloads, fmt.Sprintf("capsule %d", i))
+				}
+
+				entries = append(entries, Entry[any]{Payload: strings.Repeat("large", 100), UtilUnixMilliTimestamp: now.Add(-time.Millisecond).UnixMilli()})
+				payloads = append(payloads, strings.Repeat("large", 100))
+
+				for _, loader := range []*RedisDataloader[any]{dataloader, sharded} {
+					errs, err := loader.BuryMany(context.Background(), entries)
+					require.NoError(err)
+					require.Len(errs, len(entries))
+
+					for _, err := range errs {
+						assert.NoError(err)
+					}
+
+					scheduledAt, ok, err := loader.NextScheduledAt(context.Background())
+					require.NoError(err)
+					require.True(ok)
+					assert.Equal(entries[0].UtilUnixMilliTimestamp, scheduledAt)
+
+					dugPayloads := make([]any, 0, len(entries))
+
+					for {
+						capsule, err := loader.Dig(context.Background())
+						require.NoError(err)
+
+						if capsule == nil {
+							break
+						}
+
+						dugPayloads = append(dugPayloads, capsule.Payload)
+
+						err = loader.Destroy(context.Background(), capsule)
+						require.NoError(err)
+					}
+
+					assert.Equal(payloads, dugPayloads)
+				}
+
+				count, err := d.redisClient.HLen(context.Background(), dataloader.payloadsKey()).Result()
+				require.NoError(err)
+				assert.Zero(count)
+
+				// claim-checked entries fail once the payloads hash is broken,
+				// while the other entries are still buried
+				err = d.redisClient.Set(context.Background(), dataloader.payloadsKey(), "broken", 0).Err()
+				require.NoError(err)
+
+				errs, err := dataloader.BuryMany(context.Background(), entries)
+				require.Error(err)
+				require.Len(errs, len(entries))
+
+				for i := 0; i < 5; i++ {
+					assert.NoError(errs[i])
+				}
+
+				assert.Error(errs[5])
+
+				count, err = d.redisClient.ZCard(context.Background(), dataloader.sortedSetKey).Result()
+				require.NoError(err)
+				assert.Equal(int64(5), count)
+
+				errs, err = dataloader.BuryMany(context.Background(), nil)
+				require.NoError(err)
+				assert.Empty(errs)
+
+				bulk := make([]Entry[any], 2500)
+				for i := range bulk {
+					bulk[i] = Entry[any]{Payload: fmt.Sprintf("bulk %d", i), UtilUnixMilliTimestamp: now.UnixMilli()}
+				}
+
+				_, err = dataloader.BuryMany(context.Background(), bulk)
+				require.NoError(err)
+
+				count, err = d.redisClient.ZCard(context.Background(), dataloader.sortedSetKey).Result()
+				require.NoError(err)
+				assert.Equal(int64(5+len(bulk)), count)
+
+				// all the batches of all the shards are sent in one pipeline
+				countingClient := redis.NewClient(&redis.Options{Addr: d.redisClient.(*redis.Client).Options().Addr})
+				defer countingClient.Close()
+
+				// the connection is set up before counting
+				err = countingClient.Ping(context.Background()).Err()
+				require.NoError(err)
+
+				counter := &roundTripCounter{}
+				countingClient.AddHook(counter)
+
+				countedSharded := NewRedisDataloader[any](sortedSetKey+"/counted", countingClient, DataloaderOption{Shards: 4, ClaimCheckThreshold: 128})
+
+				defer func() {
+					err = countedSharded.DestroyAll(context.Background())
+					assert.NoError(err)
+				}()
+
+				_, err = countedSharded.BuryMany(context.Background(), append(bulk, entries...))
+				require.NoError(err)
+				assert.Equal(int64(1), counter.roundTrips.Load())
+
+				count = 0
+
+				for _, shard := range countedSharded.shards {
+					shardCount, err := d.redisClient.ZCard(context.Background(), shard.sortedSetKey).Result()
+					require.NoError(err)
+
+					count += shardCount
+				}
+
+				assert.Equal(int64(len(bulk)+len(entries)), count)
+			})
+
+			t.Run("BuryInTx", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sortedSetKey := fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				dataloader := NewRedisDataloader[any](sortedSetKey, d.redisClient, DataloaderOption{ClaimCheckThreshold: 128})
+				sharded := NewRedisDataloader[any](sortedSetKey+"/sharded", d.redisClient, DataloaderOption{Shards: 4})
+				stateKeys := make([]string, 0)
+
+				defer func() {
+					err = dataloader.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					err = sharded.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					for _, stateKey := range stateKeys {
+						err = d.redisClient.Del(context.Background(), stateKey).Err()
+						assert.NoError(err)
+					}
+				}()
+
+				payloads := []any{"follow-up", strings.Repeat("large", 100)}
+
+				for _, loader := range []*RedisDataloader[any]{dataloader, sharded} {
+					for _, payload := range payloads {
+						capsule := NewTimeCapsule(payload)
+						stateKey := topicKey(loader.sortedSetKey, "state")
+
+						_, err = d.redisClient.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+							err := loader.BuryInTx(context.Background(), pipe, capsule, time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+							if err != nil {
+								return err
+							}
+
+							// the state shares the slot of the shard the capsule is routed to
+							if len(loader.shards) > 0 {
+								stateKey = topicKey(loader.shardOf(capsule).sortedSetKey, "state")
+							}
+
+							pipe.Set(context.Background(), stateKey, payload, 0)
+
+							return nil
+						})
+						require.NoError(err)
+						stateKeys = append(stateKeys, stateKey)
+
+						state, err := d.redisClient.Get(context.Background(), stateKey).Result()
+						require.NoError(err)
+						assert.Equal(payload, state)
+					}
+
+					dugPayloads := make([]any, 0, len(payloads))
+
+					for {
+						capsule, err := loader.Dig(context.Background())
+						require.NoError(err)
+
+						if capsule == nil {
+							break
+						}
+
+						dugPayloads = append(dugPayloads, capsule.Payload)
+
+						err = loader.Destroy(context.Background(), capsule)
+						require.NoError(err)
+					}
+
+					assert.ElementsMatch(payloads, dugPayloads)
+				}
+
+				// nothing is buried once the transaction is discarded
+				pipe := d.redisClient.TxPipeline()
+
+				err = dataloader.BuryInTx(context.Background(), pipe, NewTimeCapsule[any]("discarded"), time.Now().UTC().UnixMilli())
+				require.NoError(err)
+
+				pipe.Discard()
+
+				_, err = pipe.Exec(context.Background())
+				require.NoError(err)
+
+				_, ok, err := dataloader.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.False(ok)
+			})
+
+			t.Run("TxHandlerFunc", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sortedSetKey := fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				dataloader := NewRedisDataloader[any](sortedSetKey, d.redisClient, DataloaderOption{ClaimCheckThreshold: 128})
+				stateKey := topicKey(sortedSetKey, "state")
+
+				defer func() {
+					err = dataloader.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					err = d.redisClient.Del(context.Background(), stateKey).Err()
+					assert.NoError(err)
+				}()
+
+				setState := dataloader.TxHandlerFunc(func(ctx context.Context, _ *TimeCapsuleDigger[any], capsule *TimeCapsule[any], pipe redis.Pipeliner) error {
+					pipe.Set(ctx, stateKey, capsule.Payload, 0)
+					return nil
+				})
+
+				// the writes of the handler are committed along with the ack
+				for _, payload := range []any{"small", strings.Repeat("large", 100)} {
+					err = dataloader.BuryUtil(context.Background(), payload, time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+					require.NoError(err)
+
+					capsule, err := dataloader.Dig(context.Background())
+					require.NoError(err)
+					require.NotNil(capsule)
+
+					err = setState(context.Background(), nil, capsule)
+					require.NoError(err)
+					assert.True(capsule.acked)
+
+					state, err := d.redisClient.Get(context.Background(), stateKey).Result()
+					require.NoError(err)
+					assert.Equal(payload, state)
+
+					count, err := d.redisClient.ZCard(context.Background(), dataloader.inFlightKey()).Result()
+					require.NoError(err)
+					assert.Zero(count)
+
+					count, err = d.redisClient.HLen(context.Background(), dataloader.payloadsKey()).Result()
+					require.NoError(err)
+					assert.Zero(count)
+				}
+
+				err = dataloader.BuryUtil(context.Background(), "failed", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err := dataloader.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				// nothing is written nor acked once the handler fails
+				err = dataloader.TxHandlerFunc(func(ctx context.Context, _ *TimeCapsuleDigger[any], capsule *TimeCapsule[any], pipe redis.Pipeliner) error {
+					pipe.Set(ctx, stateKey, capsule.Payload, 0)
+					return errors.New("failed")
+				})(context.Background(), nil, capsule)
+				require.Error(err)
+				assert.False(capsule.acked)
+
+				count, err := d.redisClient.ZCard(context.Background(), dataloader.inFlightKey()).Result()
+				require.NoError(err)
+				assert.Equal(int64(1), count)
+
+				// the capsule is acked even though the writes of the handler failed
+				err = dataloader.TxHandlerFunc(func(ctx context.Context, _ *TimeCapsuleDigger[any], capsule *TimeCapsule[any], pipe redis.Pipeliner) error {
+					pipe.HSet(ctx, stateKey, "payload", capsule.Payload)
+					return nil
+				})(context.Background(), nil, capsule)
+				require.Error(err)
+				assert.True(capsule.acked)
+
+				count, err = d.redisClient.ZCard(context.Background(), dataloader.inFlightKey()).Result()
+				require.NoError(err)
+				assert.Zero(count)
+			})
+
+			t.Run("Destroy", func(t *testing.T) {
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				err = d.BuryUtil(context.Background(), "shouldBeDugOut", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				err = d.Destroy(context.Background(), capsule)
+				require.NoError(err)
+
+				mems, err := d.redisClient.ZRangeWithScores(context.Background(), d.sortedSetKey, 0, -1).Result()
+				require.NoError(err)
+				require.Len(mems, 0)
+			})
+
+			t.Run("ClaimCheck", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				d.option.ClaimCheckThreshold = 1
+
+				defer func() {
+					d.option.ClaimCheckThreshold = 0
+				}()
+
+				err = d.BuryUtil(context.Background(), "shouldBeClaimChecked", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				defer func() {
+					err = d.redisClient.Del(context.Background(), d.sortedSetKey, d.payloadsKey()).Err()
+					assert.NoError(err)
+				}()
+
+				mems, err := d.redisClient.ZRange(context.Background(), d.sortedSetKey, 0, -1).Result()
+				require.NoError(err)
+				require.Len(mems, 1)
+				assert.True(strings.HasPrefix(mems[0], claimCheckReferencePrefix))
+
+				payloadsCount, err := d.redisClient.HLen(context.Background(), d.payloadsKey()).Result()
+				require.NoError(err)
+				assert.Equal(int64(1), payloadsCount)
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldBeClaimChecked", capsule.Payload)
+				assert.NotEmpty(capsule.ID)
+
+				err = d.Destroy(context.Background(), capsule)
+				require.NoError(err)
+
+				payloadsCount, err = d.redisClient.HLen(context.Background(), d.payloadsKey()).Result()
+				require.NoError(err)
+				assert.Zero(payloadsCount)
+			})
+
+			t.Run("Quarantine", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				scheduledAt := time.Now().UTC().Add(-5 * time.Millisecond).UnixMilli()
+
+				err = d.redisClient.ZAdd(context.Background(), d.sortedSetKey, redis.Z{Score: float64(scheduledAt), Member: "not-a-capsule"}).Err()
+				require.NoError(err)
+
+				defer func() {
+					err = d.redisClient.Del(context.Background(), d.sortedSetKey, d.quarantineKey()).Err()
+					assert.NoError(err)
+				}()
+
+				capsule, err := d.Dig(context.Background())
+				require.ErrorIs(err, ErrQuarantined)
+				require.Nil(capsule)
+
+				memsCount, err := d.redisClient.ZCard(context.Background(), d.sortedSetKey).Result()
+				require.NoError(err)
+				assert.Zero(memsCount)
+
+				quarantinedCapsules, err := d.Quarantined(context.Background())
+				require.NoError(err)
+				require.Len(quarantinedCapsules, 1)
+				assert.Equal("not-a-capsule", quarantinedCapsules[0].Member)
+				assert.Equal("not-a-capsule", quarantinedCapsules[0].Content)
+				assert.Equal(scheduledAt, quarantinedCapsules[0].ScheduledAt)
+				assert.NotEmpty(quarantinedCapsules[0].Error)
+				assert.NotZero(quarantinedCapsules[0].QuarantinedAt)
+
+				err = d.DeleteQuarantined(context.Background(), quarantinedCapsules[0].Member)
+				require.NoError(err)
+
+				quarantinedCapsules, err = d.Quarantined(context.Background())
+				require.NoError(err)
+				assert.Empty(quarantinedCapsules)
+			})
+
+			t.Run("DeadLetter", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				err = d.BuryUtil(context.Background(), "shouldBeDeadLettered", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				defer func() {
+					err = d.redisClient.Del(context.Background(), d.sortedSetKey, d.inFlightKey(), d.deadLettersKey()).Err()
+					assert.NoError(err)
+				}()
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				err = d.DeadLetter(context.Background(), capsule, errors.New("failed to handle"))
+				require.NoError(err)
+
+				deadLetteredCapsules, err := d.DeadLetters(context.Background())
+				require.NoError(err)
+				require.Len(deadLetteredCapsules, 1)
+				assert.Equal(capsule.Base64String(), deadLetteredCapsules[0].Member)
+				assert.Equal(capsule.Base64String(), deadLetteredCapsules[0].Content)
+				assert.Equal("failed to handle", deadLetteredCapsules[0].Reason)
+				assert.NotZero(deadLetteredCapsules[0].DeadLetteredAt)
+
+				err = d.DeleteDeadLetter(context.Background(), deadLetteredCapsules[0].Member)
+				require.NoError(err)
+
+				deadLetteredCapsules, err = d.DeadLetters(context.Background())
+				require.NoError(err)
+				assert.Empty(deadLetteredCapsules)
 			})
 
 			t.Run("DestroyAll", func(t *testing.T) {
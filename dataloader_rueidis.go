@@ -1,7 +1,11 @@
 package timecapsule
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/rueidis"
@@ -13,16 +17,86 @@ import (
 type RueidisDataloader[P any] struct {
 	sortedSetKey  string
 	rueidisClient rueidis.Client
+	option        DataloaderOption
+
+	// Shards of the topic when DataloaderOption.Shards is set, nil otherwise
+	shards []*RueidisDataloader[P]
+	// Sorted set key of the sharded topic when the dataloader is one of its
+	// shards, empty otherwise
+	shardedSortedSetKey string
 }
 
 var _ Dataloader[any] = (*RueidisDataloader[any])(nil)
+var _ CapsuleBurier[any] = (*RueidisDataloader[any])(nil)
+var _ BulkBurier[any] = (*RueidisDataloader[any])(nil)
+var _ Prefetcher[any] = (*RueidisDataloader[any])(nil)
+var _ SchedulePeeker = (*RueidisDataloader[any])(nil)
+var _ ServerClock = (*RueidisDataloader[any])(nil)
+var _ Subscriber = (*RueidisDataloader[any])(nil)
+var _ Releaser[any] = (*RueidisDataloader[any])(nil)
+var _ DeadLetterer[any] = (*RueidisDataloader[any])(nil)
+var _ LeaderElector = (*RueidisDataloader[any])(nil)
+var _ ShardCoordinator = (*RueidisDataloader[any])(nil)
+
+var (
+	rueidisBuryScript           = rueidis.NewLuaScript(buryScriptSource)
+	rueidisBuryClaimCheckScript = rueidis.NewLuaScript(buryClaimCheckScriptSource)
+	rueidisDigScript            = rueidis.NewLuaScript(digScriptSource)
+	rueidisReleaseScript        = rueidis.NewLuaScript(releaseScriptSource)
+	rueidisDestroyScript        = rueidis.NewLuaScript(destroyScriptSource)
+	rueidisQuarantineScript     = rueidis.NewLuaScript(quarantineScriptSource)
+
+	rueidisDeleteQuarantinedScript = rueidis.NewLuaScript(deleteQuarantinedScriptSource)
+	rueidisAcquireLeadershipScript = rueidis.NewLuaScript(acquireLeadershipScriptSource)
+	rueidisResignLeadershipScript  = rueidis.NewLuaScript(resignLeadershipScriptSource)
+	rueidisHeartbeatScript         = rueidis.NewLuaScript(heartbeatScriptSource)
+)
 
 // NewRueidisDataloader creates a new RueidisDataloader.
-func NewRueidisDataloader[P any](sortedSetKey string, redisClient rueidis.Client) *RueidisDataloader[P] {
-	return &RueidisDataloader[P]{
+//
+// In cluster mode, sortedSetKey should not contain '}' without a hash tag, see
+// NewRueidisDataloaderE.
+func NewRueidisDataloader[P any](sortedSetKey string, redisClient rueidis.Client, options ...DataloaderOption) *RueidisDataloader[P] {
+	dataloader := &RueidisDataloader[P]{
 		sortedSetKey:  sortedSetKey,
 		rueidisClient: redisClient,
+		option:        DefaultDataloaderOption(),
+	}
+
+	mergeDataloaderOption(&dataloader.option, options...)
+
+	if dataloader.option.Shards > 1 {
+		shardOption := dataloader.option
+		shardOption.Shards = 0
+
+		dataloader.shards = make([]*RueidisDataloader[P], dataloader.option.Shards)
+		for i := range dataloader.shards {
+			dataloader.shards[i] = &RueidisDataloader[P]{
+				sortedSetKey:        shardKey(sortedSetKey, i),
+				rueidisClient:       redisClient,
+				option:              shardOption,
+				shardedSortedSetKey: sortedSetKey,
+			}
+		}
+	}
+
+	return dataloader
+}
+
+// NewRueidisDataloaderE creates a new RueidisDataloader the same as
+// NewRueidisDataloader, and returns an error when redisClient is in cluster mode
+// and sortedSetKey contains '}' without a hash tag, since the keys of the topic
+// could not be hash-tagged into its slot then. Such keys work with the clients
+// in the other modes, where hash slots do not matter.
+func NewRueidisDataloaderE[P any](sortedSetKey string, redisClient rueidis.Client, options ...DataloaderOption) (*RueidisDataloader[P], error) {
+	if redisClient.Mode() == rueidis.ClientModeCluster {
+		err := validateSortedSetKey(sortedSetKey)
+		if err != nil {
+			return nil, err
+		}
 	}
+
+	return NewRueidisDataloader[P](sortedSetKey, redisClient, options...), nil
 }
 
 // Type returns the type of the dataloader.
@@ -32,180 +106,1502 @@ func (r *RueidisDataloader[P]) Type() string {
 
 // BuryFor buries the payload into the ground for the given duration
 //
-// Equivalent to redis command:
+// Equivalent to redis commands, executed atomically as a script:
 //
 //	ZADD sortedSetKey <now timestamp + forTimeRange> <capsule base64 string>
+//	PUBLISH sortedSetKey/buried <now timestamp + forTimeRange>, if earlier than the earliest member
 func (r *RueidisDataloader[P]) BuryFor(ctx context.Context, payload P, forTimeRange time.Duration) error {
-	utilUnixMilliTimestamp := time.Now().UTC().Add(forTimeRange).UnixMilli()
+	utilUnixMilliTimestamp := r.option.Clock.Now().UTC().Add(forTimeRange).UnixMilli()
 	return r.BuryUtil(ctx, payload, utilUnixMilliTimestamp)
 }
 
 // BuryUtil buries the payload into the ground util the given timestamp
 //
-// Equivalent to redis command:
+// Equivalent to redis commands, executed atomically as a script:
 //
 //	ZADD sortedSetKey utilUnixMilliTimestamp <capsule base64 string>
+//	PUBLISH sortedSetKey/buried utilUnixMilliTimestamp, if earlier than the earliest member
+//
+// When the encoded capsule is larger than DataloaderOption.ClaimCheckThreshold,
+// it is equivalent to redis commands, executed atomically as a script:
+//
+//	HSET sortedSetKey/payloads <capsule id> <capsule base64 string>
+//	ZADD sortedSetKey utilUnixMilliTimestamp ref:<capsule id>
+//	PUBLISH sortedSetKey/buried utilUnixMilliTimestamp, if earlier than the earliest member
+//
+// The diggers subscribing to the sortedSetKey/buried channel will be woken up
+// if the capsule is due earlier than they are going to dig, see Subscribe. A
+// failure to publish is not an error, since the capsule is buried anyway and
+// the diggers still dig it out by polling.
 func (r *RueidisDataloader[P]) BuryUtil(ctx context.Context, payload P, utilUnixMilliTimestamp int64) error {
-	newCapsule := TimeCapsule[P]{Payload: payload}
-	return r.bury(ctx, newCapsule.Base64String(), utilUnixMilliTimestamp)
+	return r.BuryCapsule(ctx, NewTimeCapsule(payload), utilUnixMilliTimestamp)
 }
 
-func (r *RueidisDataloader[P]) bury(ctx context.Context, capsuleBase64String string, utilUnixMilliTimestamp int64) error {
-	err := r.rueidisClient.Do(ctx, r.rueidisClient.B().Zadd().Key(r.sortedSetKey).ScoreMember().ScoreMember(float64(utilUnixMilliTimestamp), capsuleBase64String).Build()).Error()
+func (r *RueidisDataloader[P]) payloadsKey() string {
+	return topicKey(r.sortedSetKey, "payloads")
+}
+
+func (r *RueidisDataloader[P]) inFlightKey() string {
+	return topicKey(r.sortedSetKey, "in-flight")
+}
+
+func (r *RueidisDataloader[P]) quarantineKey() string {
+	return topicKey(r.sortedSetKey, "quarantine")
+}
+
+func (r *RueidisDataloader[P]) deadLettersKey() string {
+	return topicKey(r.sortedSetKey, "dead-letters")
+}
+
+func (r *RueidisDataloader[P]) pausedKey() string {
+	return topicKey(r.sortedSetKey, "paused")
+}
+
+func (r *RueidisDataloader[P]) leaderKey() string {
+	return topicKey(r.sortedSetKey, "leader")
+}
+
+func (r *RueidisDataloader[P]) fencingTokenKey() string {
+	return topicKey(r.sortedSetKey, "fencing-token")
+}
+
+func (r *RueidisDataloader[P]) membersKey() string {
+	return topicKey(r.sortedSetKey, "members")
+}
+
+// digKeys returns the keys of the dig script. The shards omit the paused flag
+// and the leader keys, which are checked against the sharded topic by
+// digShards once per dig, since the ones of the shards are never written.
+func (r *RueidisDataloader[P]) digKeys() []string {
+	if r.shardedSortedSetKey != "" {
+		return []string{r.sortedSetKey, r.payloadsKey(), r.inFlightKey()}
+	}
+
+	return []string{r.sortedSetKey, r.payloadsKey(), r.inFlightKey(), r.pausedKey(), r.leaderKey()}
+}
+
+// buriedChannel returns the channel the buried capsules are published to, the
+// shards publish to the channel of the sharded topic.
+func (r *RueidisDataloader[P]) buriedChannel() string {
+	if r.shardedSortedSetKey != "" {
+		return topicKey(r.shardedSortedSetKey, "buried")
+	}
+
+	return topicKey(r.sortedSetKey, "buried")
+}
+
+// shardOf returns the shard the capsule is routed to.
+func (r *RueidisDataloader[P]) shardOf(capsule *TimeCapsule[P]) *RueidisDataloader[P] {
+	return r.shards[shardOf(capsule.ID, len(r.shards))]
+}
+
+// BuryCapsule buries the capsule into the ground util the given timestamp, it
+// allows to bury a capsule with fields other than the payload, such as Kind.
+//
+// See BuryUtil for the equivalent redis commands. When the topic is sharded, a
+// random ID is assigned to the capsule if it has none, and the capsule is buried
+// into the shard sortedSetKey/shards/<shard> routed by the hash of its ID.
+func (r *RueidisDataloader[P]) BuryCapsule(ctx context.Context, capsule *TimeCapsule[P], utilUnixMilliTimestamp int64) error {
+	if len(r.shards) > 0 {
+		err := capsule.ensureID()
+		if err != nil {
+			return err
+		}
+
+		return r.shardOf(capsule).BuryCapsule(ctx, capsule, utilUnixMilliTimestamp)
+	}
+	if r.option.ClaimCheckThreshold <= 0 || len(capsule.Base64String()) <= r.option.ClaimCheckThreshold {
+		return r.bury(ctx, capsule.Base64String(), utilUnixMilliTimestamp)
+	}
+
+	err := capsule.ensureID()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	capsuleID := capsule.ID
+
+	return rueidisBuryClaimCheckScript.Exec(
+		ctx,
+		r.rueidisClient,
+		[]string{r.sortedSetKey, r.payloadsKey()},
+		[]string{
+			strconv.FormatInt(utilUnixMilliTimestamp, 10),
+			claimCheckReference(capsuleID),
+			capsuleID,
+			capsule.Base64String(),
+			r.buriedChannel(),
+		},
+	).Error()
+}
+
+func (r *RueidisDataloader[P]) bury(ctx context.Context, capsuleBase64String string, utilUnixMilliTimestamp int64) error {
+	return rueidisBuryScript.Exec(
+		ctx,
+		r.rueidisClient,
+		[]string{r.sortedSetKey},
+		[]string{
+			r.buriedChannel(),
+			strconv.FormatInt(utilUnixMilliTimestamp, 10),
+			capsuleBase64String,
+		},
+	).Error()
+}
+
+// BuryMany buries the payloads of the entries in bulk, with one DoMulti of up
+// to 1000 entries per script. It returns the errors of the entries by index, nil
+// for the entries which were buried, and an error summarizing the failed entries
+// if there is any.
+//
+// Equivalent to redis commands, executed atomically as a script per batch:
+//
+//	ZADD sortedSetKey <timestamp> <capsule base64 string> [<timestamp> <capsule base64 string> ...]
+//	PUBLISH sortedSetKey/buried <earliest timestamp>, if earlier than the earliest member
+//
+// The entries larger than DataloaderOption.ClaimCheckThreshold are buried with
+// the claim-check script of BuryUtil in the same DoMulti. When the topic is
+// sharded, the entries are routed to the shards the same way as BuryCapsule,
+// and the batches of all the shards are sent in the same DoMulti as well.
+func (r *RueidisDataloader[P]) BuryMany(ctx context.Context, entries []Entry[P]) ([]error, error) {
+	capsules := make([]*TimeCapsule[P], len(entries))
+	utilUnixMilliTimestamps := make([]int64, len(entries))
+	errs := make([]error, len(entries))
+	indicesOfShards := make([][]int, max(len(r.shards), 1))
+
+	for i, entry := range entries {
+		capsules[i] = NewTimeCapsule(entry.Payload)
+		utilUnixMilliTimestamps[i] = entry.UtilUnixMilliTimestamp
+
+		if len(r.shards) == 0 {
+			indicesOfShards[0] = append(indicesOfShards[0], i)
+			continue
+		}
+
+		err := capsules[i].ensureID()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		shard := shardOf(capsules[i].ID, len(r.shards))
+		indicesOfShards[shard] = append(indicesOfShards[shard], i)
+	}
+
+	cmds := make(rueidis.Commands, 0)
+	indicesOfCmds := make([][]int, 0)
+
+	for shard, indices := range indicesOfShards {
+		loader := r
+		if len(r.shards) > 0 {
+			loader = r.shards[shard]
+		}
+
+		shardCmds, shardIndicesOfCmds := loader.buryManyCommands(capsules, utilUnixMilliTimestamps, indices, errs)
+		cmds = append(cmds, shardCmds...)
+		indicesOfCmds = append(indicesOfCmds, shardIndicesOfCmds...)
+	}
+
+	if len(cmds) == 0 {
+		return errs, buryManyError(errs)
+	}
+
+	for j, resp := range r.rueidisClient.DoMulti(ctx, cmds...) {
+		for _, i := range indicesOfCmds[j] {
+			errs[i] = resp.Error()
+		}
+	}
+
+	return errs, buryManyError(errs)
+}
+
+// BuryInTx returns the commands burying the capsule until the given timestamp,
+// so that the capsule can be buried atomically along with the other commands
+// of the caller by sending them between MULTI and EXEC with DoMulti.
+//
+// Equivalent to redis commands, returned as a script:
+//
+//	ZADD sortedSetKey utilUnixMilliTimestamp <capsule base64 string>
+//	PUBLISH sortedSetKey/buried utilUnixMilliTimestamp, if earlier than the earliest member
+//
+// When the encoded capsule is larger than DataloaderOption.ClaimCheckThreshold,
+// it is equivalent to redis commands, returned as a script:
+//
+//	HSET sortedSetKey/payloads <capsule id> <capsule base64 string>
+//	ZADD sortedSetKey utilUnixMilliTimestamp ref:<capsule id>
+//	PUBLISH sortedSetKey/buried utilUnixMilliTimestamp, if earlier than the earliest member
+//
+// When the topic is sharded, the capsule is buried into its shard the same way
+// as BuryCapsule. In Redis Cluster, the keys of the other commands of the
+// transaction should be in the slot of sortedSetKey, or of the shard the
+// capsule is routed to when the topic is sharded.
+func (r *RueidisDataloader[P]) BuryInTx(capsule *TimeCapsule[P], utilUnixMilliTimestamp int64) (rueidis.Commands, error) {
+	if len(r.shards) > 0 {
+		err := capsule.ensureID()
+		if err != nil {
+			return nil, err
+		}
+
+		return r.shardOf(capsule).BuryInTx(capsule, utilUnixMilliTimestamp)
+	}
+
+	// EVAL instead of EVALSHA, the script may not be loaded and NOSCRIPT would
+	// abort the transaction
+	if r.option.ClaimCheckThreshold <= 0 || len(capsule.Base64String()) <= r.option.ClaimCheckThreshold {
+		return rueidis.Commands{
+			r.rueidisClient.B().Eval().Script(buryScriptSource).Numkeys(1).Key(r.sortedSetKey).Arg(
+				r.buriedChannel(),
+				strconv.FormatInt(utilUnixMilliTimestamp, 10),
+				capsule.Base64String(),
+			).Build(),
+		}, nil
+	}
+
+	err := capsule.ensureID()
+	if err != nil {
+		return nil, err
+	}
+
+	return rueidis.Commands{r.buryClaimCheckCommand(capsule, utilUnixMilliTimestamp)}, nil
+}
+
+// buryClaimCheckCommand returns the claim-check script burying the capsule
+// which has an ID, sent with EVAL.
+func (r *RueidisDataloader[P]) buryClaimCheckCommand(capsule *TimeCapsule[P], utilUnixMilliTimestamp int64) rueidis.Completed {
+	return r.rueidisClient.B().Eval().Script(buryClaimCheckScriptSource).Numkeys(2).Key(r.sortedSetKey, r.payloadsKey()).Arg(
+		strconv.FormatInt(utilUnixMilliTimestamp, 10),
+		claimCheckReference(capsule.ID),
+		capsule.ID,
+		capsule.Base64String(),
+		r.buriedChannel(),
+	).Build()
+}
+
+// buryManyCommands returns the scripts burying the capsules of the given
+// indices, in batches of up to buryManyBatchSize capsules, along with the
+// indices of the capsules each of them buries, and records the errors of the
+// capsules which can not be buried into errs by index. The scripts are sent with
+// EVAL instead of EVALSHA, so that they are not loaded beforehand in another
+// round trip.
+func (r *RueidisDataloader[P]) buryManyCommands(capsules []*TimeCapsule[P], utilUnixMilliTimestamps []int64, indices []int, errs []error) (rueidis.Commands, [][]int) {
+	cmds := make(rueidis.Commands, 0)
+	indicesOfCmds := make([][]int, 0)
+
+	for start := 0; start < len(indices); start += buryManyBatchSize {
+		batch := indices[start:min(start+buryManyBatchSize, len(indices))]
+		args := make([]string, 0, 1+2*len(batch))
+		args = append(args, r.buriedChannel())
+		inlined := make([]int, 0, len(batch))
+
+		for _, i := range batch {
+			capsule := capsules[i]
+
+			if r.option.ClaimCheckThreshold <= 0 || len(capsule.Base64String()) <= r.option.ClaimCheckThreshold {
+				args = append(args, strconv.FormatInt(utilUnixMilliTimestamps[i], 10), capsule.Base64String())
+				inlined = append(inlined, i)
+
+				continue
+			}
+
+			err := capsule.ensureID()
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+
+			cmds = append(cmds, r.buryClaimCheckCommand(capsule, utilUnixMilliTimestamps[i]))
+			indicesOfCmds = append(indicesOfCmds, []int{i})
+		}
+
+		if len(inlined) > 0 {
+			cmds = append(cmds, r.rueidisClient.B().Eval().Script(buryScriptSource).Numkeys(1).Key(r.sortedSetKey).Arg(args...).Build())
+			indicesOfCmds = append(indicesOfCmds, inlined)
+		}
+	}
+
+	return cmds, indicesOfCmds
 }
 
 // Dig digs the time capsule from the dataloader
 //
-// Equivalent to redis command flow:
+// Equivalent to redis command flow, executed atomically as a script:
 //
-//	     ZRANGEBYSCORE sortedSetKey 0 <now timestamp>
+//	HGET sortedSetKey/leader token, fail if the fencing token in ctx is stale
+//	                            |
+//	EXISTS sortedSetKey/paused, return if the topic is paused
+//	                            |
+//	move members of sortedSetKey/in-flight with expired leases back to sortedSetKey
+//	                            |
+//	ZRANGEBYSCORE sortedSetKey -inf <now timestamp> WITHSCORES LIMIT 0 1
 //	                            |
 //	                      got elements?
 //	                            |
 //	                   -------------------
 //	                   |                 |
-//	        ZPOPMIN sortedSetKey 1     return
+//	      ZREM sortedSetKey <member>   return
+//	                   |
+//	ZADD sortedSetKey/in-flight <lease deadline> <member>
 //	                   |
-//	            dut to execute?
+//	        claim-check reference?
 //	                   |
 //	           -----------------
 //	           |               |
-//	return TimeCapsule     return
+//	HGET sortedSetKey/payloads |
+//	           |               |
+//	           -----------------
+//	                   |
+//	          return TimeCapsule
+//
+// The dug out capsule is leased to the caller until DataloaderOption.LeaseDuration
+// elapses, it should be either destroyed or released before the lease expires,
+// otherwise it will be handed back to be dug out again.
+//
+// Capsules which fail to decode are moved to the quarantine hash with the decode
+// error attached instead of being lost, see Quarantined.
+//
+// When the topic is sharded, the pause flag, the fencing token and the earliest
+// members of the shards are read in one round trip first, then the shards with
+// due capsules are dug with the flow above in the order of the earliest due
+// time, until a capsule is dug out:
+//
+//	EXISTS sortedSetKey/paused
+//	HGET sortedSetKey/leader token
+//	ZRANGE sortedSetKey/shards/<shard> 0 0 WITHSCORES
+//	ZRANGE {sortedSetKey/shards/<shard>}/in-flight 0 0 WITHSCORES
+//
+// The fencing token is not checked atomically with digging the shards then.
+//
+// With DataloaderOption.ServerTime, <now timestamp> is taken from TIME inside the
+// script, and TIME is read after the earliest members of the shards:
+//
+//	TIME
 func (r *RueidisDataloader[P]) Dig(ctx context.Context) (*TimeCapsule[P], error) {
-	now := time.Now().UTC()
+	return r.DigUtil(ctx, r.option.Clock.Now().UTC().UnixMilli())
+}
 
-	zrangebyscoreCmd := r.rueidisClient.
-		B().
-		Zcount().
-		Key(r.sortedSetKey).
-		Min("0").
-		Max(strconv.FormatInt(now.UnixMilli(), 10)).
-		Build()
+// DigUtil digs the earliest capsule which is due until the given timestamp, it
+// allows to lease the capsules before they are due, such as prefetching.
+//
+// See Dig for the equivalent redis commands.
+func (r *RueidisDataloader[P]) DigUtil(ctx context.Context, utilUnixMilliTimestamp int64) (*TimeCapsule[P], error) {
+	if len(r.shards) > 0 {
+		return r.digShards(ctx, utilUnixMilliTimestamp)
+	}
 
-	resp := r.rueidisClient.Do(ctx, zrangebyscoreCmd)
+	now := r.option.Clock.Now().UTC()
+	leaseDeadline := now.Add(r.option.LeaseDuration).UnixMilli()
+
+	resp := rueidisDigScript.Exec(
+		ctx,
+		r.rueidisClient,
+		r.digKeys(),
+		[]string{
+			strconv.FormatInt(now.UnixMilli(), 10),
+			claimCheckReferencePrefix,
+			strconv.FormatInt(leaseDeadline, 10),
+			strconv.Itoa(leaseRequeueLimit),
+			strconv.FormatInt(utilUnixMilliTimestamp, 10),
+			fencingTokenArg(ctx),
+			serverTimeArg(r.option.ServerTime),
+		},
+	)
 
 	err := resp.Error()
 	if err != nil {
 		if rueidis.IsRedisNil(err) {
 			return nil, nil
 		}
+		if errors.Is(err, rueidis.ErrClosing) || strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			return nil, fmt.Errorf("%w: %w", ErrFatal, err)
+		}
+		if strings.HasPrefix(err.Error(), notLeaderErrorPrefix) {
+			return nil, fmt.Errorf("%w: %w", ErrNotLeader, err)
+		}
 
 		return nil, err
 	}
 
-	members, err := resp.AsInt64()
+	result, err := resp.ToArray()
 	if err != nil {
 		return nil, err
 	}
-	if members == 0 {
-		return nil, nil
+	if len(result) != 5 {
+		return nil, errors.New("invalid dig result")
 	}
 
-	zpopminCmd := r.rueidisClient.
-		B().
-		Zpopmin().
-		Key(r.sortedSetKey).
-		Count(1).
-		Build()
+	member, err := result[0].ToString()
+	if err != nil {
+		return nil, err
+	}
+
+	score, err := result[1].ToString()
+	if err != nil {
+		return nil, err
+	}
+
+	scheduledAt, err := parseScore(score)
+	if err != nil {
+		return nil, err
+	}
+
+	// now and the lease deadline follow the clock of Redis with ServerTime
+	dugOutAt, err := result[3].AsInt64()
+	if err != nil {
+		return nil, err
+	}
 
-	resp = r.rueidisClient.Do(ctx, zpopminCmd)
+	leaseDeadline, err = result[4].AsInt64()
+	if err != nil {
+		return nil, err
+	}
 
-	err = resp.Error()
+	capsuleContent, err := result[2].ToString()
 	if err != nil {
 		if rueidis.IsRedisNil(err) {
-			return nil, nil
+			return nil, r.quarantine(ctx, member, scheduledAt, fmt.Errorf("payload of claim-check reference %s is missing", member))
 		}
 
 		return nil, err
 	}
 
-	capsulesList, err := resp.AsZScores()
+	capsule, err := NewTimeCapsuleFromBase64String[P](capsuleContent)
+	if err != nil {
+		return nil, r.quarantine(ctx, member, scheduledAt, err)
+	}
+
+	capsule.member = member
+	capsule.leaseDeadline = leaseDeadline
+	capsule.DugOutAt = dugOutAt
+	capsule.ScheduledAt = scheduledAt
+
+	return capsule, nil
+}
+
+// digShards digs the earliest due capsule across the shards of the topic.
+func (r *RueidisDataloader[P]) digShards(ctx context.Context, utilUnixMilliTimestamp int64) (*TimeCapsule[P], error) {
+	now := r.option.Clock.Now().UTC().UnixMilli()
+	fencingToken, fenced := fencingTokenFromContext(ctx)
+
+	shards := shardsFromContext(ctx, len(r.shards))
+
+	cmds := make(rueidis.Commands, 0, 2+2*len(shards))
+	cmds = append(cmds,
+		r.rueidisClient.B().Exists().Key(r.pausedKey()).Build(),
+		r.rueidisClient.B().Hget().Key(r.leaderKey()).Field("token").Build(),
+	)
+
+	for _, shard := range shards {
+		cmds = append(cmds,
+			r.rueidisClient.B().Zrange().Key(r.shards[shard].sortedSetKey).Min("0").Max("0").Withscores().Build(),
+			r.rueidisClient.B().Zrange().Key(r.shards[shard].inFlightKey()).Min("0").Max("0").Withscores().Build(),
+		)
+	}
+
+	resps := r.rueidisClient.DoMulti(ctx, cmds...)
+
+	paused, err := resps[0].AsInt64()
 	if err != nil {
+		if errors.Is(err, rueidis.ErrClosing) || strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			return nil, fmt.Errorf("%w: %w", ErrFatal, err)
+		}
+
 		return nil, err
 	}
-	if len(capsulesList) == 0 {
+	if paused == 1 {
 		return nil, nil
 	}
+	if fenced {
+		leaderFencingToken, err := resps[1].ToString()
+		if err != nil && !rueidis.IsRedisNil(err) {
+			return nil, err
+		}
+		if leaderFencingToken != strconv.FormatInt(fencingToken, 10) {
+			return nil, fmt.Errorf("%w: fencing token %d is stale", ErrNotLeader, fencingToken)
+		}
+	}
+
+	peeks := make([]shardPeek, len(shards))
 
-	headCapsule := capsulesList[0]
+	for i := range shards {
+		scheduled, err := resps[2+2*i].AsZScores()
+		if err != nil {
+			return nil, err
+		}
+		if len(scheduled) > 0 {
+			peeks[i].scheduledAt = int64(scheduled[0].Score)
+			peeks[i].scheduled = true
+		}
 
-	capsuleOpeningTime := time.UnixMilli(int64(headCapsule.Score))
-	if capsuleOpeningTime.After(now) {
-		time.Sleep(10 * time.Millisecond)
+		leased, err := resps[3+2*i].AsZScores()
+		if err != nil {
+			return nil, err
+		}
+		if len(leased) > 0 {
+			peeks[i].leaseDeadline = int64(leased[0].Score)
+			peeks[i].leased = true
+		}
+	}
 
-		_, _, err := lo.AttemptWithDelay(100, 10*time.Millisecond, func(i int, d time.Duration) error {
-			return r.bury(ctx, headCapsule.Member, capsuleOpeningTime.UnixMilli())
-		})
+	// the shards shift the due timestamp by the skew between the clocks
+	// themselves, only the peeks are compared against the clock of Redis here
+	dueAt := utilUnixMilliTimestamp
+	if r.option.ServerTime {
+		serverNow, err := r.serverTime(ctx)
 		if err != nil {
 			return nil, err
 		}
 
-		return nil, nil
+		skew := serverNow - now
+		now += skew
+		dueAt += skew
 	}
 
-	capsule, err := NewTimeCapsuleFromBase64String[P](headCapsule.Member)
-	if err != nil {
-		return nil, err
+	// the fencing token has been checked against the leadership of the topic
+	shardCtx := withoutFencingToken(ctx)
+
+	for _, i := range dueShards(peeks, now, dueAt) {
+		capsule, err := r.shards[shards[i]].DigUtil(shardCtx, utilUnixMilliTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		if capsule != nil {
+			return capsule, nil
+		}
 	}
 
-	capsule.DugOutAt = now.UnixMilli()
+	return nil, nil
+}
+
+// ServerNow returns the unix milli timestamp the scores are compared against,
+// which is the clock of Redis with DataloaderOption.ServerTime, or the clock of
+// the dataloader otherwise.
+//
+// Equivalent to redis command with DataloaderOption.ServerTime:
+//
+//	TIME
+func (r *RueidisDataloader[P]) ServerNow(ctx context.Context) (int64, error) {
+	if !r.option.ServerTime {
+		return r.option.Clock.Now().UTC().UnixMilli(), nil
+	}
 
-	return capsule, nil
+	return r.serverTime(ctx)
 }
 
-// Destroy destroys the given capsule
+// serverTime returns the unix milli timestamp of the clock of Redis. TIME is not
+// pipelined with the peeks of the shards, since the cluster client refuses to
+// mix commands without keys with the commands of several slots.
 //
 // Equivalent to redis command:
 //
-//	ZREM sortedSetKey <capsule base64 string>
-func (r *RueidisDataloader[P]) Destroy(ctx context.Context, capsule *TimeCapsule[P]) error {
-	_, _, err := lo.AttemptWithDelay(100, 10*time.Millisecond, func(i int, d time.Duration) error {
-		zremCmd := r.rueidisClient.
-			B().
-			Zrem().
-			Key(r.sortedSetKey).
-			Member(capsule.Base64String()).
-			Build()
+//	TIME
+func (r *RueidisDataloader[P]) serverTime(ctx context.Context) (int64, error) {
+	reply, err := r.rueidisClient.Do(ctx, r.rueidisClient.B().Time().Build()).AsStrSlice()
+	if err != nil {
+		return 0, err
+	}
+	if len(reply) != 2 {
+		return 0, errors.New("invalid time result")
+	}
 
-		resp := r.rueidisClient.Do(ctx, zremCmd)
+	seconds, err := strconv.ParseInt(reply[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
 
-		err := resp.Error()
-		if err != nil {
-			return err
-		}
+	microseconds, err := strconv.ParseInt(reply[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
 
-		return nil
-	})
+	return seconds*1000 + microseconds/1000, nil
+}
+
+// quarantine moves the leased member which failed to decode to the quarantine hash
+//
+// Equivalent to redis commands, executed atomically as a script:
+//
+//	HSET sortedSetKey/quarantine <member> <quarantine record>
+//	ZREM sortedSetKey/in-flight <member>
+func (r *RueidisDataloader[P]) quarantine(ctx context.Context, member string, scheduledAt int64, decodeErr error) error {
+	err := rueidisQuarantineScript.Exec(
+		ctx,
+		r.rueidisClient,
+		[]string{r.quarantineKey(), r.inFlightKey()},
+		[]string{member, newQuarantineRecord(scheduledAt, decodeErr, r.option.Clock.Now().UTC().UnixMilli())},
+	).Error()
 	if err != nil {
-		return err
+		return errors.Join(fmt.Errorf("failed to decode capsule: %w", decodeErr), fmt.Errorf("failed to quarantine capsule: %w", err))
 	}
 
-	return nil
+	return fmt.Errorf("failed to decode capsule, %w: %w", ErrQuarantined, decodeErr)
 }
 
-func (r *RueidisDataloader[P]) DestroyAll(ctx context.Context) error {
-	_, _, err := lo.AttemptWithDelay(100, 10*time.Millisecond, func(i int, d time.Duration) error {
-		delCmd := r.rueidisClient.
-			B().
-			Del().
-			Key(r.sortedSetKey).
-			Build()
+// Quarantined lists the capsules which failed to decode when digging.
+//
+// Equivalent to redis commands:
+//
+//	HGETALL sortedSetKey/quarantine
+//	HMGET sortedSetKey/payloads <capsule ids of claim-check references>
+func (r *RueidisDataloader[P]) Quarantined(ctx context.Context) ([]*QuarantinedCapsule, error) {
+	if len(r.shards) > 0 {
+		quarantinedCapsules := make([]*QuarantinedCapsule, 0)
 
-		err := r.rueidisClient.Do(ctx, delCmd).Error()
-		if err != nil {
-			return err
+		for _, shard := range r.shards {
+			shardQuarantinedCapsules, err := shard.Quarantined(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			quarantinedCapsules = append(quarantinedCapsules, shardQuarantinedCapsules...)
 		}
 
-		return nil
-	})
-	if err != nil {
-		return err
+		return quarantinedCapsules, nil
 	}
 
-	return nil
+	hgetallCmd := r.rueidisClient.
+		B().
+		Hgetall().
+		Key(r.quarantineKey()).
+		Build()
+
+	records, err := r.rueidisClient.Do(ctx, hgetallCmd).AsStrMap()
+	if err != nil {
+		return nil, err
+	}
+
+	quarantinedCapsules := make([]*QuarantinedCapsule, 0, len(records))
+	referencedCapsules := make(map[string]*QuarantinedCapsule)
+
+	for member, record := range records {
+		quarantinedCapsule, err := parseQuarantineRecord(member, record)
+		if err != nil {
+			return nil, err
+		}
+
+		quarantinedCapsules = append(quarantinedCapsules, quarantinedCapsule)
+
+		capsuleID, ok := claimCheckCapsuleID(member)
+		if ok {
+			referencedCapsules[capsuleID] = quarantinedCapsule
+		}
+	}
+	if len(referencedCapsules) == 0 {
+		return quarantinedCapsules, nil
+	}
+
+	capsuleIDs := lo.Keys(referencedCapsules)
+
+	hmgetCmd := r.rueidisClient.
+		B().
+		Hmget().
+		Key(r.payloadsKey()).
+		Field(capsuleIDs...).
+		Build()
+
+	contents, err := r.rueidisClient.Do(ctx, hmgetCmd).ToArray()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, content := range contents {
+		content, err := content.ToString()
+		if err == nil {
+			referencedCapsules[capsuleIDs[i]].Content = content
+		}
+	}
+
+	return quarantinedCapsules, nil
+}
+
+// DeleteQuarantined deletes the quarantined capsule of the given member
+//
+// Equivalent to redis commands, executed atomically as a script:
+//
+//	HDEL sortedSetKey/quarantine <member>
+//	HDEL sortedSetKey/payloads <capsule id> (only for claim-check references)
+func (r *RueidisDataloader[P]) DeleteQuarantined(ctx context.Context, member string) error {
+	if len(r.shards) > 0 {
+		for _, shard := range r.shards {
+			err := shard.DeleteQuarantined(ctx, member)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return rueidisDeleteQuarantinedScript.Exec(
+		ctx,
+		r.rueidisClient,
+		[]string{r.quarantineKey(), r.payloadsKey()},
+		[]string{member, claimCheckReferencePrefix},
+	).Error()
+}
+
+// NextScheduledAt returns the timestamp when the earliest capsule is scheduled
+// to be dug out, ok is false if there is no capsule buried
+//
+// Equivalent to redis command:
+//
+//	ZRANGE sortedSetKey 0 0 WITHSCORES
+func (r *RueidisDataloader[P]) NextScheduledAt(ctx context.Context) (int64, bool, error) {
+	if len(r.shards) > 0 {
+		return r.nextScheduledAtOfShards(ctx)
+	}
+
+	zrangeCmd := r.rueidisClient.
+		B().
+		Zrange().
+		Key(r.sortedSetKey).
+		Min("0").
+		Max("0").
+		Withscores().
+		Build()
+
+	members, err := r.rueidisClient.Do(ctx, zrangeCmd).AsZScores()
+	if err != nil {
+		return 0, false, err
+	}
+	if len(members) == 0 {
+		return 0, false, nil
+	}
+
+	return int64(members[0].Score), true, nil
+}
+
+// nextScheduledAtOfShards returns the earliest scheduled timestamp across the
+// shards of the topic, or across the owned shards if ctx carries them.
+func (r *RueidisDataloader[P]) nextScheduledAtOfShards(ctx context.Context) (int64, bool, error) {
+	shards := shardsFromContext(ctx, len(r.shards))
+	cmds := make(rueidis.Commands, 0, len(shards))
+
+	for _, shard := range shards {
+		cmds = append(cmds, r.rueidisClient.B().Zrange().Key(r.shards[shard].sortedSetKey).Min("0").Max("0").Withscores().Build())
+	}
+
+	var earliest int64
+	var ok bool
+
+	for _, resp := range r.rueidisClient.DoMulti(ctx, cmds...) {
+		members, err := resp.AsZScores()
+		if err != nil {
+			return 0, false, err
+		}
+		if len(members) == 0 {
+			continue
+		}
+		if !ok || int64(members[0].Score) < earliest {
+			earliest = int64(members[0].Score)
+			ok = true
+		}
+	}
+
+	return earliest, ok, nil
+}
+
+// Subscribe subscribes to the notifications of buried capsules, the scheduled
+// timestamps of the capsules buried earlier than the earliest buried one will
+// be sent to the returned channel, which will be closed once ctx is done or the
+// client is closed. The subscription is made again with backoff once the
+// connection drops, the capsules buried in the meantime are not notified.
+//
+// Equivalent to redis command:
+//
+//	SUBSCRIBE sortedSetKey/buried
+func (r *RueidisDataloader[P]) Subscribe(ctx context.Context) (<-chan int64, error) {
+	notifications := make(chan int64)
+
+	wait, cancel, err := r.subscribe(ctx, notifications)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer close(notifications)
+
+		interval := minResubscribeInterval
+
+		for {
+			select {
+			case <-ctx.Done():
+			case <-wait:
+			}
+
+			cancel()
+			// the hooks will not be called anymore once wait is closed
+			for range wait {
+			}
+
+			for {
+				timer := time.NewTimer(interval)
+
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+
+				wait, cancel, err = r.subscribe(ctx, notifications)
+				if err == nil {
+					interval = minResubscribeInterval
+					break
+				}
+				if errors.Is(err, rueidis.ErrClosing) {
+					return
+				}
+
+				interval = min(2*interval, maxResubscribeInterval)
+			}
+		}
+	}()
+
+	return notifications, nil
+}
+
+const (
+	// minResubscribeInterval is the backoff of resubscribing to the
+	// notifications of buried capsules once the connection drops, it doubles
+	// until maxResubscribeInterval while resubscribing fails.
+	minResubscribeInterval = 100 * time.Millisecond
+	maxResubscribeInterval = 5 * time.Second
+)
+
+// subscribe subscribes on a dedicated connection, and returns once the
+// subscription is confirmed. wait is closed once the connection drops, cancel
+// releases the connection.
+func (r *RueidisDataloader[P]) subscribe(ctx context.Context, notifications chan<- int64) (<-chan error, func(), error) {
+	client, cancel := r.rueidisClient.Dedicate()
+
+	subscribed := make(chan struct{})
+
+	var subscribedOnce sync.Once
+
+	wait := client.SetPubSubHooks(rueidis.PubSubHooks{
+		OnMessage: func(message rueidis.PubSubMessage) {
+			scheduledAt, err := strconv.ParseInt(message.Message, 10, 64)
+			if err != nil {
+				return
+			}
+
+			select {
+			case notifications <- scheduledAt:
+			case <-ctx.Done():
+			}
+		},
+		OnSubscription: func(subscription rueidis.PubSubSubscription) {
+			if subscription.Kind == "subscribe" {
+				subscribedOnce.Do(func() { close(subscribed) })
+			}
+		},
+	})
+
+	subscribeCmd := client.
+		B().
+		Subscribe().
+		Channel(r.buriedChannel()).
+		Build()
+
+	err := client.Do(ctx, subscribeCmd).Error()
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	// wait for the confirmation of the subscription
+	select {
+	case <-subscribed:
+	case err = <-wait:
+		cancel()
+		return nil, nil, errors.Join(errors.New("subscription closed"), err)
+	case <-ctx.Done():
+		cancel()
+		return nil, nil, ctx.Err()
+	}
+
+	return wait, cancel, nil
+}
+
+// Release hands the leased capsule back to be dug out again once it is due, which
+// is immediately unless the capsule was leased before it is due
+//
+// Equivalent to redis commands, executed atomically as a script:
+//
+//	ZREM sortedSetKey/in-flight <capsule base64 string or claim-check reference>
+//	ZADD sortedSetKey <scheduled timestamp> <capsule base64 string or claim-check reference>
+func (r *RueidisDataloader[P]) Release(ctx context.Context, capsule *TimeCapsule[P]) error {
+	if len(r.shards) > 0 {
+		return r.shardOf(capsule).Release(ctx, capsule)
+	}
+
+	_, _, err := lo.AttemptWithDelay(100, 10*time.Millisecond, func(i int, d time.Duration) error {
+		return rueidisReleaseScript.Exec(
+			ctx,
+			r.rueidisClient,
+			[]string{r.sortedSetKey, r.inFlightKey()},
+			[]string{capsule.memberString(), strconv.FormatInt(releaseScore(capsule, r.option.Clock.Now().UTC().UnixMilli()), 10)},
+		).Error()
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Destroy destroys the given capsule
+//
+// Equivalent to redis commands, executed atomically as a script:
+//
+//	ZREM sortedSetKey <capsule base64 string or claim-check reference>
+//	ZREM sortedSetKey/in-flight <capsule base64 string or claim-check reference>
+//	HDEL sortedSetKey/payloads <capsule id> (only for claim-check references)
+func (r *RueidisDataloader[P]) Destroy(ctx context.Context, capsule *TimeCapsule[P]) error {
+	if len(r.shards) > 0 {
+		return r.shardOf(capsule).Destroy(ctx, capsule)
+	}
+
+	_, _, err := lo.AttemptWithDelay(100, 10*time.Millisecond, func(i int, d time.Duration) error {
+		resp := rueidisDestroyScript.Exec(
+			ctx,
+			r.rueidisClient,
+			[]string{r.sortedSetKey, r.payloadsKey(), r.inFlightKey()},
+			[]string{capsule.memberString(), claimCheckReferencePrefix},
+		)
+
+		err := resp.Error()
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DestroyInTx returns the commands destroying the dug out capsule, so that the
+// capsule can be acked atomically along with the other commands of the caller
+// by sending them between MULTI and EXEC with DoMulti.
+//
+// Equivalent to redis commands:
+//
+//	ZREM sortedSetKey <capsule base64 string or claim-check reference>
+//	ZREM sortedSetKey/in-flight <capsule base64 string or claim-check reference>
+//	HDEL sortedSetKey/payloads <capsule id> (only for claim-check references)
+func (r *RueidisDataloader[P]) DestroyInTx(capsule *TimeCapsule[P]) rueidis.Commands {
+	if len(r.shards) > 0 {
+		return r.shardOf(capsule).DestroyInTx(capsule)
+	}
+
+	member := capsule.memberString()
+	cmds := rueidis.Commands{
+		r.rueidisClient.B().Zrem().Key(r.sortedSetKey).Member(member).Build(),
+		r.rueidisClient.B().Zrem().Key(r.inFlightKey()).Member(member).Build(),
+	}
+
+	if strings.HasPrefix(member, claimCheckReferencePrefix) {
+		cmds = append(cmds, r.rueidisClient.B().Hdel().Key(r.payloadsKey()).Field(strings.TrimPrefix(member, claimCheckReferencePrefix)).Build())
+	}
+
+	return cmds
+}
+
+// RueidisTxHandlerFunc is the function to handle the capsules dug out by the
+// digger, which returns its writes as commands instead of sending them, see
+// TxHandlerFunc.
+type RueidisTxHandlerFunc[P any] func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) (rueidis.Commands, error)
+
+// TxHandlerFunc returns the handler to be set by SetHandlerFunc of the digger,
+// which sends the commands returned by txHandlerFunc and destroys the capsule
+// atomically in one MULTI / EXEC transaction, see DestroyInTx. Nothing is sent
+// if txHandlerFunc returns an error.
+//
+// Redis does not roll back a transaction, the capsule is acked once the
+// commands of DestroyInTx succeed even if some commands returned by
+// txHandlerFunc failed, and the digger will neither retry nor dead letter such
+// capsule. In Redis Cluster, the keys written by txHandlerFunc should be in the
+// slot of sortedSetKey, or of the shard the capsule is routed to when the
+// topic is sharded.
+func (r *RueidisDataloader[P]) TxHandlerFunc(txHandlerFunc RueidisTxHandlerFunc[P]) HandlerFunc[P] {
+	return func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error {
+		cmds, err := txHandlerFunc(ctx, digger, capsule)
+		if err != nil {
+			return err
+		}
+
+		queued := len(cmds)
+		txCmds := make(rueidis.Commands, 0, len(cmds)+5)
+		txCmds = append(txCmds, r.rueidisClient.B().Multi().Build())
+		txCmds = append(txCmds, cmds...)
+		txCmds = append(txCmds, r.DestroyInTx(capsule)...)
+		txCmds = append(txCmds, r.rueidisClient.B().Exec().Build())
+
+		resps := r.rueidisClient.DoMulti(ctx, txCmds...)
+
+		results, err := resps[len(resps)-1].ToArray()
+		if err != nil {
+			return err
+		}
+		if len(results) > queued {
+			capsule.acked = lo.EveryBy(results[queued:], func(result rueidis.RedisMessage) bool {
+				return result.Error() == nil
+			})
+		}
+
+		for _, result := range results[:min(queued, len(results))] {
+			err = result.Error()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func (r *RueidisDataloader[P]) DestroyAll(ctx context.Context) error {
+	if len(r.shards) > 0 {
+		for _, shard := range r.shards {
+			err := shard.DestroyAll(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	_, _, err := lo.AttemptWithDelay(100, 10*time.Millisecond, func(i int, d time.Duration) error {
+		delCmd := r.rueidisClient.
+			B().
+			Del().
+			Key(r.sortedSetKey, r.payloadsKey(), r.inFlightKey()).
+			Build()
+
+		err := r.rueidisClient.Do(ctx, delCmd).Error()
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeadLetter moves the capsule which could not be handled to the dead letters
+//
+// Equivalent to redis command:
+//
+//	HSET sortedSetKey/dead-letters <member> <dead letter record>
+func (r *RueidisDataloader[P]) DeadLetter(ctx context.Context, capsule *TimeCapsule[P], reason error) error {
+	if len(r.shards) > 0 {
+		return r.shardOf(capsule).DeadLetter(ctx, capsule, reason)
+	}
+
+	member := capsule.memberString()
+
+	hsetCmd := r.rueidisClient.
+		B().
+		Hset().
+		Key(r.deadLettersKey()).
+		FieldValue().
+		FieldValue(member, newDeadLetterRecord(member, capsule.Base64String(), reason, r.option.Clock.Now().UTC().UnixMilli())).
+		Build()
+
+	return r.rueidisClient.Do(ctx, hsetCmd).Error()
+}
+
+// DeadLetters lists the capsules which were dead lettered.
+//
+// Equivalent to redis command:
+//
+//	HGETALL sortedSetKey/dead-letters
+func (r *RueidisDataloader[P]) DeadLetters(ctx context.Context) ([]*DeadLetteredCapsule, error) {
+	if len(r.shards) > 0 {
+		deadLetteredCapsules := make([]*DeadLetteredCapsule, 0)
+
+		for _, shard := range r.shards {
+			shardDeadLetteredCapsules, err := shard.DeadLetters(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			deadLetteredCapsules = append(deadLetteredCapsules, shardDeadLetteredCapsules...)
+		}
+
+		return deadLetteredCapsules, nil
+	}
+
+	hgetallCmd := r.rueidisClient.
+		B().
+		Hgetall().
+		Key(r.deadLettersKey()).
+		Build()
+
+	records, err := r.rueidisClient.Do(ctx, hgetallCmd).AsStrMap()
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetteredCapsules := make([]*DeadLetteredCapsule, 0, len(records))
+
+	for member, record := range records {
+		deadLetteredCapsule, err := parseDeadLetterRecord(member, record)
+		if err != nil {
+			return nil, err
+		}
+
+		deadLetteredCapsules = append(deadLetteredCapsules, deadLetteredCapsule)
+	}
+
+	return deadLetteredCapsules, nil
+}
+
+// DeleteDeadLetter deletes the dead lettered capsule of the given member
+//
+// Equivalent to redis command:
+//
+//	HDEL sortedSetKey/dead-letters <member>
+func (r *RueidisDataloader[P]) DeleteDeadLetter(ctx context.Context, member string) error {
+	if len(r.shards) > 0 {
+		for _, shard := range r.shards {
+			err := shard.DeleteDeadLetter(ctx, member)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	hdelCmd := r.rueidisClient.
+		B().
+		Hdel().
+		Key(r.deadLettersKey()).
+		Field(member).
+		Build()
+
+	return r.rueidisClient.Do(ctx, hdelCmd).Error()
+}
+
+// Pause pauses the topic for all the diggers digging from the sorted set, no
+// capsules will be dug out until Resume is called, burying capsules keeps working
+//
+// Equivalent to redis command:
+//
+//	SET sortedSetKey/paused <now timestamp>
+func (r *RueidisDataloader[P]) Pause(ctx context.Context) error {
+	setCmd := r.rueidisClient.
+		B().
+		Set().
+		Key(r.pausedKey()).
+		Value(strconv.FormatInt(r.option.Clock.Now().UTC().UnixMilli(), 10)).
+		Build()
+
+	return r.rueidisClient.Do(ctx, setCmd).Error()
+}
+
+// Resume resumes the topic paused by Pause
+//
+// Equivalent to redis command:
+//
+//	DEL sortedSetKey/paused
+func (r *RueidisDataloader[P]) Resume(ctx context.Context) error {
+	delCmd := r.rueidisClient.
+		B().
+		Del().
+		Key(r.pausedKey()).
+		Build()
+
+	return r.rueidisClient.Do(ctx, delCmd).Error()
+}
+
+// Paused reports whether the topic is paused
+//
+// Equivalent to redis command:
+//
+//	EXISTS sortedSetKey/paused
+func (r *RueidisDataloader[P]) Paused(ctx context.Context) (bool, error) {
+	existsCmd := r.rueidisClient.
+		B().
+		Exists().
+		Key(r.pausedKey()).
+		Build()
+
+	exists, err := r.rueidisClient.Do(ctx, existsCmd).AsInt64()
+	if err != nil {
+		return false, err
+	}
+
+	return exists == 1, nil
+}
+
+// AcquireLeadership acquires the leadership of the topic for the holder, or
+// renews it if the holder is already the leader. The leadership lapses after the
+// lease duration unless it is renewed, a new fencing token is issued every time
+// the leadership is acquired
+//
+// Equivalent to redis command flow, executed atomically as a script:
+//
+//	HGET sortedSetKey/leader holder, return if another holder is the leader
+//	                            |
+//	INCR sortedSetKey/fencing-token, only if there is no leader
+//	                            |
+//	HSET sortedSetKey/leader holder <holder id> token <fencing token>
+//	                            |
+//	PEXPIRE sortedSetKey/leader <lease duration>
+func (r *RueidisDataloader[P]) AcquireLeadership(ctx context.Context, holderID string, leaseDuration time.Duration) (int64, bool, error) {
+	fencingToken, err := rueidisAcquireLeadershipScript.Exec(
+		ctx,
+		r.rueidisClient,
+		[]string{r.leaderKey(), r.fencingTokenKey()},
+		[]string{holderID, strconv.FormatInt(leaseDuration.Milliseconds(), 10)},
+	).AsInt64()
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return 0, false, nil
+		}
+
+		return 0, false, err
+	}
+
+	return fencingToken, true, nil
+}
+
+// ResignLeadership gives up the leadership of the topic if the holder is the
+// leader, so that another digger can take over without waiting for the lease to
+// lapse
+//
+// Equivalent to redis command flow, executed atomically as a script:
+//
+//	HGET sortedSetKey/leader holder, return if another holder is the leader
+//	                            |
+//	DEL sortedSetKey/leader
+func (r *RueidisDataloader[P]) ResignLeadership(ctx context.Context, holderID string) error {
+	return rueidisResignLeadershipScript.Exec(
+		ctx,
+		r.rueidisClient,
+		[]string{r.leaderKey()},
+		[]string{holderID},
+	).Error()
+}
+
+// Heartbeat registers the member of the topic or extends its membership for the
+// TTL, and returns the shards owned by the member. The shards are distributed
+// among the live members in a round-robin manner by the order of the member
+// IDs. An unsharded topic has a single shard 0 owned by all the members
+//
+// Equivalent to redis command flow, executed atomically as a script:
+//
+//	ZREMRANGEBYSCORE sortedSetKey/members -inf (<now timestamp>
+//	                            |
+//	ZADD sortedSetKey/members <now timestamp + ttl> <member id>
+//	                            |
+//	PEXPIRE sortedSetKey/members <ttl>
+//	                            |
+//	ZRANGE sortedSetKey/members 0 -1
+func (r *RueidisDataloader[P]) Heartbeat(ctx context.Context, memberID string, ttl time.Duration) ([]int, error) {
+	now := r.option.Clock.Now().UTC()
+
+	members, err := rueidisHeartbeatScript.Exec(
+		ctx,
+		r.rueidisClient,
+		[]string{r.membersKey()},
+		[]string{
+			strconv.FormatInt(now.UnixMilli(), 10),
+			strconv.FormatInt(now.Add(ttl).UnixMilli(), 10),
+			memberID,
+			strconv.FormatInt(ttl.Milliseconds(), 10),
+		},
+	).AsStrSlice()
+	if err != nil {
+		return nil, err
+	}
+	if len(r.shards) == 0 {
+		return []int{0}, nil
+	}
+
+	return ownedShards(members, memberID, len(r.shards)), nil
+}
+
+// Leave removes the member of the topic
+//
+// Equivalent to redis command:
+//
+//	ZREM sortedSetKey/members <member id>
+func (r *RueidisDataloader[P]) Leave(ctx context.Context, memberID string) error {
+	zremCmd := r.rueidisClient.
+		B().
+		Zrem().
+		Key(r.membersKey()).
+		Member(memberID).
+		Build()
+
+	return r.rueidisClient.Do(ctx, zremCmd).Error()
+}
+
+// Reshard migrates the pending capsules from the layout of the given number of
+// shards to the current layout of the topic, such as after changing
+// DataloaderOption.Shards, and returns the number of the migrated capsules. Zero
+// or one means the capsules were buried into the unsharded sorted set.
+//
+// The capsules whose leases have expired are migrated as well, while the leased
+// ones are left for their diggers. Reshard should be called once all the
+// diggers use the current number of shards, and once more after the lease
+// duration to migrate the capsules which were being handled. A capsule is
+// buried into its new shard before it is removed from the old one, so it may be
+// handled twice if it is dug out of the old shard in between, but is never lost.
+// Capsules which fail to decode are left to be quarantined by digging.
+//
+// Equivalent to redis commands for each old shard:
+//
+//	ZRANGEBYSCORE <old shard>/in-flight -inf <now timestamp>
+//	ZREM <old shard>/in-flight <member> + ZADD <old shard> <now timestamp> <member>
+//	ZRANGE <old shard> <offset> <offset + 99> WITHSCORES
+//	HGET <old shard>/payloads <capsule id> (only for claim-check references)
+//
+// then BuryCapsule into the new shard, and Destroy from the old shard.
+func (r *RueidisDataloader[P]) Reshard(ctx context.Context, fromShards int) (int, error) {
+	moved := 0
+
+	for _, source := range r.reshardSources(fromShards) {
+		sourceMoved, err := r.reshardFrom(ctx, source)
+		moved += sourceMoved
+
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
+
+// reshardSources returns the shards of the layout of the given number of shards.
+func (r *RueidisDataloader[P]) reshardSources(fromShards int) []*RueidisDataloader[P] {
+	sourceOption := r.option
+	sourceOption.Shards = 0
+
+	if fromShards <= 1 {
+		return []*RueidisDataloader[P]{{sortedSetKey: r.sortedSetKey, rueidisClient: r.rueidisClient, option: sourceOption}}
+	}
+
+	sources := make([]*RueidisDataloader[P], fromShards)
+	for i := range sources {
+		sources[i] = &RueidisDataloader[P]{sortedSetKey: shardKey(r.sortedSetKey, i), rueidisClient: r.rueidisClient, option: sourceOption}
+	}
+
+	return sources
+}
+
+// reshardTarget returns the sorted set key the capsule belongs to in the
+// current layout.
+func (r *RueidisDataloader[P]) reshardTarget(capsule *TimeCapsule[P]) string {
+	if len(r.shards) == 0 {
+		return r.sortedSetKey
+	}
+
+	return r.shardOf(capsule).sortedSetKey
+}
+
+// reshardFrom migrates the pending capsules of the old shard.
+func (r *RueidisDataloader[P]) reshardFrom(ctx context.Context, source *RueidisDataloader[P]) (int, error) {
+	now := strconv.FormatInt(r.option.Clock.Now().UTC().UnixMilli(), 10)
+
+	zrangebyscoreCmd := r.rueidisClient.
+		B().
+		Zrangebyscore().
+		Key(source.inFlightKey()).
+		Min("-inf").
+		Max(now).
+		Build()
+
+	expired, err := r.rueidisClient.Do(ctx, zrangebyscoreCmd).AsStrSlice()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, member := range expired {
+		err = rueidisReleaseScript.Exec(ctx, r.rueidisClient, []string{source.sortedSetKey, source.inFlightKey()}, []string{member, now}).Error()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	moved := 0
+	// the members which are left in the old shard stay in front of the ones
+	// yet to be migrated
+	skipped := 0
+
+	for {
+		zrangeCmd := r.rueidisClient.
+			B().
+			Zrange().
+			Key(source.sortedSetKey).
+			Min(strconv.Itoa(skipped)).
+			Max(strconv.Itoa(skipped + 99)).
+			Withscores().
+			Build()
+
+		members, err := r.rueidisClient.Do(ctx, zrangeCmd).AsZScores()
+		if err != nil {
+			return moved, err
+		}
+		if len(members) == 0 {
+			return moved, nil
+		}
+
+		for _, z := range members {
+			content := z.Member
+			if capsuleID, ok := claimCheckCapsuleID(z.Member); ok {
+				hgetCmd := r.rueidisClient.
+					B().
+					Hget().
+					Key(source.payloadsKey()).
+					Field(capsuleID).
+					Build()
+
+				content, err = r.rueidisClient.Do(ctx, hgetCmd).ToString()
+				if err != nil && !rueidis.IsRedisNil(err) {
+					return moved, err
+				}
+			}
+
+			capsule, err := NewTimeCapsuleFromBase64String[P](content)
+			if err != nil {
+				skipped++
+				continue
+			}
+
+			capsule.member = z.Member
+
+			err = capsule.ensureID()
+			if err != nil {
+				return moved, err
+			}
+			if r.reshardTarget(capsule) == source.sortedSetKey {
+				skipped++
+				continue
+			}
+
+			err = r.BuryCapsule(ctx, capsule, int64(z.Score))
+			if err != nil {
+				return moved, err
+			}
+
+			err = source.Destroy(ctx, capsule)
+			if err != nil {
+				return moved, err
+			}
+
+			moved++
+		}
+	}
 }
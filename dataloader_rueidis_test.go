@@ -3,9 +3,14 @@ package timecapsule
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
 	"math/big"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,20 +20,52 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-var (
-	sortedSetKeyRueidis = "test/timecapsule/rueidis/zset"
-	rueidisv5Client     = lo.Must(rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{net.JoinHostPort("localhost", "6379")}, DisableCache: true}))
-	rueidisv6Client     = lo.Must(rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{net.JoinHostPort("localhost", "6380")}}))
-	rueidisv7Client     = lo.Must(rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{net.JoinHostPort("localhost", "6381")}}))
-)
+var sortedSetKeyRueidis = "test/timecapsule/rueidis/zset"
+
+// Empty unless setupRueidisDataloaders is called by TestMain
+var rueidisDataloaders map[string]*RueidisDataloader[any]
+
+// setupRueidisDataloaders connects to Redis 5, 6 and 7 listening on 6379, 6380
+// and 6381, it panics if any of them does not respond.
+func setupRueidisDataloaders() {
+	rueidisv5Client := lo.Must(rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{net.JoinHostPort("localhost", "6379")}, DisableCache: true}))
+	rueidisv6Client := lo.Must(rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{net.JoinHostPort("localhost", "6380")}}))
+	rueidisv7Client := lo.Must(rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{net.JoinHostPort("localhost", "6381")}}))
+
+	lo.Must0(rueidisv5Client.Do(context.Background(), rueidisv5Client.B().Ping().Build()).Error())
+	lo.Must0(rueidisv6Client.Do(context.Background(), rueidisv6Client.B().Ping().Build()).Error())
+	lo.Must0(rueidisv7Client.Do(context.Background(), rueidisv7Client.B().Ping().Build()).Error())
+
+	rueidisDataloaders = map[string]*RueidisDataloader[any]{
+		"Rueidis/redis:5": NewRueidisDataloader[any](sortedSetKeyRueidis, rueidisv5Client),
+		"Rueidis/redis:6": NewRueidisDataloader[any](sortedSetKeyRueidis, rueidisv6Client),
+		"Rueidis/redis:7": NewRueidisDataloader[any](sortedSetKeyRueidis, rueidisv7Client),
+	}
+}
+
+// roundTripCountingClient counts the calls of Do and DoMulti of the client, the
+// commands of DoMulti are counted as one round trip.
+type roundTripCountingClient struct {
+	rueidis.Client
 
-var rueidisDataloaders = map[string]*RueidisDataloader[any]{
-	"Rueidis/redis:5": NewRueidisDataloader[any](sortedSetKeyRueidis, rueidisv5Client),
-	"Rueidis/redis:6": NewRueidisDataloader[any](sortedSetKeyRueidis, rueidisv6Client),
-	"Rueidis/redis:7": NewRueidisDataloader[any](sortedSetKeyRueidis, rueidisv7Client),
+	roundTrips atomic.Int64
+}
+
+func (c *roundTripCountingClient) Do(ctx context.Context, cmd rueidis.Completed) rueidis.RedisResult {
+	c.roundTrips.Add(1)
+	return c.Client.Do(ctx, cmd)
+}
+
+func (c *roundTripCountingClient) DoMulti(ctx context.Context, multi ...rueidis.Completed) []rueidis.RedisResult {
+	c.roundTrips.Add(1)
+	return c.Client.DoMulti(ctx, multi...)
 }
 
 func TestRueidisDataloder(t *testing.T) {
+	if len(rueidisDataloaders) == 0 {
+		t.Skipf("%s is set", skipRedisTestsEnv)
+	}
+
 	for k, d := range rueidisDataloaders {
 		d := d
 
@@ -136,7 +173,7 @@ func TestRueidisDataloder(t *testing.T) {
 					require.NoError(err)
 
 					defer func() {
-						err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey).Build()).Error()
+						err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey, d.inFlightKey()).Build()).Error()
 						assert.NoError(err)
 					}()
 
@@ -197,7 +234,8 @@ func TestRueidisDataloder(t *testing.T) {
 				})
 			})
 
-			t.Run("Destroy", func(t *testing.T) {
+			t.Run("DigUtil", func(t *testing.T) {
+				assert := assert.New(t)
 				require := require.New(t)
 
 				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
@@ -205,26 +243,67 @@ func TestRueidisDataloder(t *testing.T) {
 
 				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
 
-				err = d.BuryUtil(context.Background(), "shouldBeDugOut", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				scheduledAt := time.Now().UTC().Add(time.Minute).UnixMilli()
+
+				err = d.BuryUtil(context.Background(), "shouldBePrefetched", scheduledAt)
 				require.NoError(err)
 
+				defer func() {
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey, d.inFlightKey()).Build()).Error()
+					assert.NoError(err)
+				}()
+
 				capsule, err := d.Dig(context.Background())
 				require.NoError(err)
+				assert.Nil(capsule)
+
+				capsule, err = d.DigUtil(context.Background(), scheduledAt)
+				require.NoError(err)
 				require.NotNil(capsule)
+				assert.Equal("shouldBePrefetched", capsule.Payload)
+				assert.Equal(scheduledAt, capsule.ScheduledAt)
 
-				err = d.Destroy(context.Background(), capsule)
+				err = d.Release(context.Background(), capsule)
 				require.NoError(err)
 
-				zrangewithscoresCmd := d.rueidisClient.B().Zrange().Key(d.sortedSetKey).Min("0").Max("-1").Withscores().Build()
-				resp := d.rueidisClient.Do(context.Background(), zrangewithscoresCmd)
-				require.NoError(resp.Error())
+				nextScheduledAt, ok, err := d.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.True(ok)
+				assert.Equal(scheduledAt, nextScheduledAt)
+			})
 
-				mems, err := resp.AsZScores()
+			t.Run("NextScheduledAt", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
 				require.NoError(err)
-				require.Len(mems, 0)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				_, ok, err := d.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.False(ok)
+
+				scheduledAt := time.Now().UTC().Add(time.Minute).UnixMilli()
+
+				err = d.BuryUtil(context.Background(), "later", scheduledAt+1000)
+				require.NoError(err)
+				err = d.BuryUtil(context.Background(), "earliest", scheduledAt)
+				require.NoError(err)
+
+				defer func() {
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				nextScheduledAt, ok, err := d.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.True(ok)
+				assert.Equal(scheduledAt, nextScheduledAt)
 			})
 
-			t.Run("DestroyAll", func(t *testing.T) {
+			t.Run("Subscribe", func(t *testing.T) {
 				assert := assert.New(t)
 				require := require.New(t)
 
@@ -233,7 +312,15 @@ func TestRueidisDataloder(t *testing.T) {
 
 				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
 
-				err = d.BuryUtil(context.Background(), "shouldNotBeDugOut", time.Now().UTC().Add(5*time.Millisecond).UnixMilli())
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				notifications, err := d.Subscribe(ctx)
+				require.NoError(err)
+
+				scheduledAt := time.Now().UTC().Add(time.Minute).UnixMilli()
+
+				err = d.BuryUtil(context.Background(), "shouldBeNotified", scheduledAt)
 				require.NoError(err)
 
 				defer func() {
@@ -241,17 +328,1193 @@ func TestRueidisDataloder(t *testing.T) {
 					assert.NoError(err)
 				}()
 
-				err = d.DestroyAll(context.Background())
+				// not notified, the capsule buried earlier is due before it
+				err = d.BuryUtil(context.Background(), "shouldNotBeNotified", scheduledAt+1000)
 				require.NoError(err)
 
-				zcountCmd := d.rueidisClient.B().Zcount().Key(d.sortedSetKey).Min("-inf").Max("+inf").Build()
-				resp := d.rueidisClient.Do(context.Background(), zcountCmd)
-				require.NoError(resp.Error())
+				err = d.BuryUtil(context.Background(), "shouldBeNotifiedEarlier", scheduledAt-1000)
+				require.NoError(err)
 
-				memsCount, err := resp.AsInt64()
+				for _, expected := range []int64{scheduledAt, scheduledAt - 1000} {
+					select {
+					case notifiedScheduledAt := <-notifications:
+						assert.Equal(expected, notifiedScheduledAt)
+					case <-time.After(5 * time.Second):
+						require.Fail("buried capsule should be notified")
+					}
+				}
+
+				cancel()
+
+				select {
+				case _, ok := <-notifications:
+					assert.False(ok)
+				case <-time.After(5 * time.Second):
+					require.Fail("notifications should be closed once ctx is done")
+				}
+			})
+
+			t.Run("Resubscribe", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
 				require.NoError(err)
-				assert.Zero(memsCount)
+
+				sortedSetKey := fmt.Sprintf("test/timecapsule/rueidis/zset/%d", randomSeed.Int64())
+
+				var upstream string
+				for address := range d.rueidisClient.Nodes() {
+					upstream = address
+				}
+
+				proxyAddress, dropConnections := newDroppingProxy(t, upstream)
+
+				// miniredis replies to CLUSTER SLOTS with its own address, connect
+				// to the proxy only
+				proxiedClient, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{proxyAddress}, DisableCache: true, ForceSingleClient: true})
+				require.NoError(err)
+
+				defer proxiedClient.Close()
+
+				subscriber := NewRueidisDataloader[any](sortedSetKey, proxiedClient)
+				publisher := NewRueidisDataloader[any](sortedSetKey, d.rueidisClient)
+
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				notifications, err := subscriber.Subscribe(ctx)
+				require.NoError(err)
+
+				defer func() {
+					err = publisher.DestroyAll(context.Background())
+					assert.NoError(err)
+				}()
+
+				dropConnections()
+
+				// the capsules buried before resubscribing are not notified, keep
+				// burying earlier ones until one is
+				deadline := time.After(5 * time.Second)
+				earliestScheduledAt := time.Now().UTC().Add(time.Minute).UnixMilli()
+
+				for i := 0; ; i++ {
+					scheduledAt := earliestScheduledAt - int64(i)
+
+					err = publisher.BuryUtil(context.Background(), fmt.Sprintf("shouldBeNotified %d", i), scheduledAt)
+					require.NoError(err)
+
+					select {
+					case notifiedScheduledAt, ok := <-notifications:
+						require.True(ok, "notifications should not be closed once the connection drops")
+						assert.Equal(scheduledAt, notifiedScheduledAt)
+
+						return
+					case <-time.After(50 * time.Millisecond):
+					case <-deadline:
+						require.Fail("buried capsule should be notified once resubscribed")
+					}
+				}
 			})
-		})
-	}
+
+			t.Run("Release", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				err = d.BuryUtil(context.Background(), "shouldBeReleased", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				defer func() {
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey, d.inFlightKey()).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				inFlightCount, err := d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(d.inFlightKey()).Build()).AsInt64()
+				require.NoError(err)
+				assert.Equal(int64(1), inFlightCount)
+
+				err = d.Release(context.Background(), capsule)
+				require.NoError(err)
+
+				inFlightCount, err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(d.inFlightKey()).Build()).AsInt64()
+				require.NoError(err)
+				assert.Zero(inFlightCount)
+
+				releasedCapsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(releasedCapsule)
+				assert.Equal("shouldBeReleased", releasedCapsule.Payload)
+
+				err = d.Destroy(context.Background(), releasedCapsule)
+				require.NoError(err)
+
+				err = d.Release(context.Background(), releasedCapsule)
+				require.NoError(err)
+
+				capsule, err = d.Dig(context.Background())
+				require.NoError(err)
+				assert.Nil(capsule)
+			})
+
+			t.Run("LeaseExpiry", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				d.option.LeaseDuration = time.Millisecond
+
+				defer func() {
+					d.option.LeaseDuration = DefaultDataloaderOption().LeaseDuration
+				}()
+
+				err = d.BuryUtil(context.Background(), "shouldBeLeasedAgain", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				defer func() {
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey, d.inFlightKey()).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				time.Sleep(5 * time.Millisecond)
+
+				capsule, err = d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldBeLeasedAgain", capsule.Payload)
+			})
+
+			t.Run("Pause", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				err = d.Pause(context.Background())
+				require.NoError(err)
+
+				defer func() {
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey, d.inFlightKey(), d.pausedKey()).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				paused, err := d.Paused(context.Background())
+				require.NoError(err)
+				assert.True(paused)
+
+				err = d.BuryUtil(context.Background(), "shouldBeDugOutOnceResumed", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				assert.Nil(capsule)
+
+				err = d.Resume(context.Background())
+				require.NoError(err)
+
+				paused, err = d.Paused(context.Background())
+				require.NoError(err)
+				assert.False(paused)
+
+				capsule, err = d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldBeDugOutOnceResumed", capsule.Payload)
+			})
+
+			t.Run("LeaderElection", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				defer func() {
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey, d.inFlightKey(), d.leaderKey(), d.fencingTokenKey()).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				fencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+				require.NoError(err)
+				assert.True(acquired)
+
+				_, acquired, err = d.AcquireLeadership(context.Background(), "holder-b", time.Minute)
+				require.NoError(err)
+				assert.False(acquired)
+
+				renewedFencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+				require.NoError(err)
+				assert.True(acquired)
+				assert.Equal(fencingToken, renewedFencingToken)
+
+				ttlMilliseconds, err := d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Pttl().Key(d.leaderKey()).Build()).AsInt64()
+				require.NoError(err)
+
+				ttl := time.Duration(ttlMilliseconds) * time.Millisecond
+				assert.Greater(ttl, time.Duration(0))
+				assert.LessOrEqual(ttl, time.Minute)
+
+				err = d.BuryUtil(context.Background(), "shouldBeDugOutByLeader", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err := d.Dig(withFencingToken(context.Background(), fencingToken))
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldBeDugOutByLeader", capsule.Payload)
+
+				// the lease of holder-a lapses
+				err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.leaderKey()).Build()).Error()
+				require.NoError(err)
+
+				takenOverFencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-b", time.Minute)
+				require.NoError(err)
+				assert.True(acquired)
+				assert.Greater(takenOverFencingToken, fencingToken)
+
+				err = d.BuryUtil(context.Background(), "shouldNotBeDugOutByStaleLeader", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err = d.Dig(withFencingToken(context.Background(), fencingToken))
+				require.ErrorIs(err, ErrNotLeader)
+				assert.Nil(capsule)
+
+				err = d.ResignLeadership(context.Background(), "holder-a")
+				require.NoError(err)
+
+				_, acquired, err = d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+				require.NoError(err)
+				assert.False(acquired)
+
+				err = d.ResignLeadership(context.Background(), "holder-b")
+				require.NoError(err)
+
+				reacquiredFencingToken, acquired, err := d.AcquireLeadership(context.Background(), "holder-a", time.Minute)
+				require.NoError(err)
+				assert.True(acquired)
+				assert.Greater(reacquiredFencingToken, takenOverFencingToken)
+
+				capsule, err = d.Dig(withFencingToken(context.Background(), reacquiredFencingToken))
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldNotBeDugOutByStaleLeader", capsule.Payload)
+			})
+
+			t.Run("Sharded", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sharded := NewRueidisDataloader[any](fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64()), d.rueidisClient, DataloaderOption{Shards: 4})
+				require.Len(sharded.shards, 4)
+
+				defer func() {
+					err = sharded.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					err = sharded.rueidisClient.Do(context.Background(), sharded.rueidisClient.B().Del().Key(sharded.pausedKey()).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				subscribeCtx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				notifications, err := sharded.Subscribe(subscribeCtx)
+				require.NoError(err)
+
+				now := time.Now().UTC().UnixMilli()
+
+				for i := 0; i < 8; i++ {
+					err = sharded.BuryUtil(context.Background(), fmt.Sprintf("capsule %d", i), now-int64(100-i))
+					require.NoError(err)
+				}
+
+				select {
+				case notified := <-notifications:
+					assert.Equal(now-100, notified)
+				case <-time.After(5 * time.Second):
+					assert.Fail("shards should publish to the buried channel of the topic")
+				}
+
+				buried := 0
+				usedShards := 0
+
+				for _, shard := range sharded.shards {
+					count, err := sharded.rueidisClient.Do(context.Background(), sharded.rueidisClient.B().Zcard().Key(shard.sortedSetKey).Build()).AsInt64()
+					require.NoError(err)
+
+					buried += int(count)
+					if count > 0 {
+						usedShards++
+					}
+				}
+
+				assert.Equal(8, buried)
+				assert.Greater(usedShards, 1)
+
+				scheduledAt, ok, err := sharded.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.True(ok)
+				assert.Equal(now-100, scheduledAt)
+
+				err = sharded.Pause(context.Background())
+				require.NoError(err)
+
+				capsule, err := sharded.Dig(context.Background())
+				require.NoError(err)
+				assert.Nil(capsule)
+
+				err = sharded.Resume(context.Background())
+				require.NoError(err)
+
+				capsule, err = sharded.Dig(withFencingToken(context.Background(), 42))
+				require.ErrorIs(err, ErrNotLeader)
+				assert.Nil(capsule)
+
+				capsule, err = sharded.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("capsule 0", capsule.Payload)
+
+				err = sharded.Release(context.Background(), capsule)
+				require.NoError(err)
+
+				for i := 0; i < 8; i++ {
+					capsule, err = sharded.Dig(context.Background())
+					require.NoError(err)
+					require.NotNil(capsule)
+					assert.Equal(fmt.Sprintf("capsule %d", i), capsule.Payload)
+					assert.Equal(now-int64(100-i), capsule.ScheduledAt)
+
+					err = sharded.Destroy(context.Background(), capsule)
+					require.NoError(err)
+				}
+
+				capsule, err = sharded.Dig(context.Background())
+				require.NoError(err)
+				assert.Nil(capsule)
+
+				_, ok, err = sharded.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.False(ok)
+			})
+
+			t.Run("ServerTime", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				// the clock of the dataloaders lags behind Redis by an hour
+				clock := NewFakeClock(time.Now().Add(-time.Hour))
+
+				for _, shards := range []int{0, 4} {
+					randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+					require.NoError(err)
+
+					sortedSetKey := fmt.Sprintf("test/timecapsule/rueidis/zset/%d", randomSeed.Int64())
+
+					skewed := NewRueidisDataloader[any](sortedSetKey, d.rueidisClient, DataloaderOption{Clock: clock, Shards: shards})
+					serverTimed := NewRueidisDataloader[any](sortedSetKey, d.rueidisClient, DataloaderOption{Clock: clock, Shards: shards, ServerTime: true})
+
+					scheduledAt := time.Now().UTC().Add(-5 * time.Millisecond).UnixMilli()
+
+					err = skewed.BuryUtil(context.Background(), fmt.Sprintf("shouldBeDugOutByServerTime %d", shards), scheduledAt)
+					require.NoError(err)
+
+					err = skewed.BuryUtil(context.Background(), fmt.Sprintf("shouldBePrefetchedByServerTime %d", shards), time.Now().UTC().Add(30*time.Second).UnixMilli())
+					require.NoError(err)
+
+					capsule, err := skewed.Dig(context.Background())
+					require.NoError(err)
+					require.Nil(capsule)
+
+					capsule, err = serverTimed.Dig(context.Background())
+					require.NoError(err)
+					require.NotNil(capsule)
+
+					now := time.Now().UTC().UnixMilli()
+
+					assert.Equal(fmt.Sprintf("shouldBeDugOutByServerTime %d", shards), capsule.Payload)
+					assert.GreaterOrEqual(capsule.DugOutAt, scheduledAt)
+					assert.LessOrEqual(capsule.DugOutAt, now)
+					assert.Equal(capsule.DugOutAt+DefaultDataloaderOption().LeaseDuration.Milliseconds(), capsule.leaseDeadline)
+
+					capsule, err = serverTimed.Dig(context.Background())
+					require.NoError(err)
+					require.Nil(capsule)
+
+					// the due timestamp is shifted by the skew as well
+					capsule, err = serverTimed.DigUtil(context.Background(), clock.Now().Add(time.Minute).UnixMilli())
+					require.NoError(err)
+					require.NotNil(capsule)
+					assert.Equal(fmt.Sprintf("shouldBePrefetchedByServerTime %d", shards), capsule.Payload)
+
+					err = serverTimed.DestroyAll(context.Background())
+					require.NoError(err)
+				}
+			})
+
+			t.Run("ShardDigKeys", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sharded := NewRueidisDataloader[any](fmt.Sprintf("test/timecapsule/rueidis/zset/%d", randomSeed.Int64()), d.rueidisClient, DataloaderOption{Shards: 4})
+				client := d.rueidisClient
+
+				shardPausedKeys := make([]string, 0, len(sharded.shards))
+				for _, shard := range sharded.shards {
+					shardPausedKeys = append(shardPausedKeys, shard.pausedKey())
+				}
+
+				// only the paused flag of the topic pauses the shards
+				for _, key := range shardPausedKeys {
+					err = client.Do(context.Background(), client.B().Set().Key(key).Value("1").Build()).Error()
+					require.NoError(err)
+				}
+
+				defer func() {
+					err = sharded.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					for _, key := range shardPausedKeys {
+						err = client.Do(context.Background(), client.B().Del().Key(key).Build()).Error()
+						assert.NoError(err)
+					}
+				}()
+
+				err = sharded.BuryUtil(context.Background(), "shouldBeDugOut", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err := sharded.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldBeDugOut", capsule.Payload)
+			})
+
+			t.Run("ShardOwnership", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sharded := NewRueidisDataloader[any](fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64()), d.rueidisClient, DataloaderOption{Shards: 4})
+
+				defer func() {
+					err = sharded.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(sharded.membersKey()).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				owned, err := sharded.Heartbeat(context.Background(), "member-a", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{0, 1, 2, 3}, owned)
+
+				owned, err = sharded.Heartbeat(context.Background(), "member-b", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{1, 3}, owned)
+
+				owned, err = sharded.Heartbeat(context.Background(), "member-a", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{0, 2}, owned)
+
+				for i := 0; i < 8; i++ {
+					err = sharded.BuryUtil(context.Background(), fmt.Sprintf("capsule %d", i), time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+					require.NoError(err)
+				}
+
+				for {
+					capsule, err := sharded.Dig(withShards(context.Background(), owned))
+					require.NoError(err)
+
+					if capsule == nil {
+						break
+					}
+
+					assert.Contains(owned, shardOf(capsule.ID, 4))
+				}
+
+				scheduledAt, ok, err := sharded.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.True(ok, "capsules of the shards owned by member-b should be left")
+				assert.Positive(scheduledAt)
+
+				_, ok, err = sharded.NextScheduledAt(withShards(context.Background(), owned))
+				require.NoError(err)
+				assert.False(ok)
+
+				err = sharded.Leave(context.Background(), "member-b")
+				require.NoError(err)
+
+				owned, err = sharded.Heartbeat(context.Background(), "member-a", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{0, 1, 2, 3}, owned)
+
+				owned, err = sharded.Heartbeat(context.Background(), "member-b", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{1, 3}, owned)
+
+				// the membership of member-b lapses
+				_, err = sharded.Heartbeat(context.Background(), "member-b", -time.Minute)
+				require.NoError(err)
+
+				owned, err = sharded.Heartbeat(context.Background(), "member-a", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{0, 1, 2, 3}, owned)
+
+				owned, err = d.Heartbeat(context.Background(), "member-a", time.Minute)
+				require.NoError(err)
+				assert.Equal([]int{0}, owned)
+
+				err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.membersKey()).Build()).Error()
+				require.NoError(err)
+			})
+
+			t.Run("Reshard", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sortedSetKey := fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				unsharded := NewRueidisDataloader[any](sortedSetKey, d.rueidisClient, DataloaderOption{LeaseDuration: time.Millisecond})
+				sharded := NewRueidisDataloader[any](sortedSetKey, d.rueidisClient, DataloaderOption{Shards: 4})
+				resharded := NewRueidisDataloader[any](sortedSetKey, d.rueidisClient, DataloaderOption{Shards: 2})
+
+				defer func() {
+					for _, loader := range []interface{ DestroyAll(context.Context) error }{unsharded, sharded, resharded} {
+						err = loader.DestroyAll(context.Background())
+						assert.NoError(err)
+					}
+				}()
+
+				payloads := make([]any, 0, 6)
+
+				for i := 0; i < 6; i++ {
+					payloads = append(payloads, fmt.Sprintf("capsule %d", i))
+
+					err = unsharded.BuryUtil(context.Background(), fmt.Sprintf("capsule %d", i), time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+					require.NoError(err)
+				}
+
+				// leased by a digger which is gone, the lease expires before resharding
+				capsule, err := unsharded.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				time.Sleep(5 * time.Millisecond)
+
+				moved, err := sharded.Reshard(context.Background(), 0)
+				require.NoError(err)
+				assert.Equal(6, moved)
+
+				count, err := d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(unsharded.sortedSetKey).Build()).AsInt64()
+				require.NoError(err)
+				assert.Zero(count)
+
+				count, err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(unsharded.inFlightKey()).Build()).AsInt64()
+				require.NoError(err)
+				assert.Zero(count)
+
+				_, err = resharded.Reshard(context.Background(), 4)
+				require.NoError(err)
+
+				buried := 0
+
+				for i := 0; i < 4; i++ {
+					count, err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(shardKey(sortedSetKey, i)).Build()).AsInt64()
+					require.NoError(err)
+
+					if i >= 2 {
+						assert.Zero(count)
+					}
+
+					buried += int(count)
+				}
+
+				assert.Equal(6, buried)
+
+				dugPayloads := make([]any, 0, 6)
+
+				for {
+					capsule, err = resharded.Dig(context.Background())
+					require.NoError(err)
+
+					if capsule == nil {
+						break
+					}
+
+					assert.Equal(shardKey(sortedSetKey, shardOf(capsule.ID, 2)), resharded.shardOf(capsule).sortedSetKey)
+					dugPayloads = append(dugPayloads, capsule.Payload)
+
+					err = resharded.Destroy(context.Background(), capsule)
+					require.NoError(err)
+				}
+
+				assert.ElementsMatch(payloads, dugPayloads)
+			})
+
+			t.Run("BuryMany", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sortedSetKey := fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				dataloader := NewRueidisDataloader[any](sortedSetKey, d.rueidisClient, DataloaderOption{ClaimCheckThreshold: 128})
+				sharded := NewRueidisDataloader[any](sortedSetKey+"/sharded", d.rueidisClient, DataloaderOption{Shards: 4})
+
+				defer func() {
+					err = dataloader.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					err = sharded.DestroyAll(context.Background())
+					assert.NoError(err)
+				}()
+
+				now := time.Now().UTC()
+				entries := make([]Entry[any], 0, 6)
+				payloads := make([]any, 0, 6)
+
+				for i := 0; i < 5; i++ {
+					entries = append(entries, Entry[any]{Payload: fmt.Sprintf("capsule %d", i), UtilUnixMilliTimestamp: now.Add(time.Duration(i-10) * time.Millisecond).UnixMilli()})
+					payloads = append(payloads, fmt.Sprintf("capsule %d", i))
+				}
+
+				entries = append(entries, Entry[any]{Payload: strings.Repeat("large", 100), UtilUnixMilliTimestamp: now.Add(-time.Millisecond).UnixMilli()})
+				payloads = append(payloads, strings.Repeat("large", 100))
+
+				for _, loader := range []*RueidisDataloader[any]{dataloader, sharded} {
+					errs, err := loader.BuryMany(context.Background(), entries)
+					require.NoError(err)
+					require.Len(errs, len(entries))
+
+					for _, err := range errs {
+						assert.NoError(err)
+					}
+
+					scheduledAt, ok, err := loader.NextScheduledAt(context.Background())
+					require.NoError(err)
+					require.True(ok)
+					assert.Equal(entries[0].UtilUnixMilliTimestamp, scheduledAt)
+
+					dugPayloads := make([]any, 0, len(entries))
+
+					for {
+						capsule, err := loader.Dig(context.Background())
+						require.NoError(err)
+
+						if capsule == nil {
+							break
+						}
+
+						dugPayloads = append(dugPayloads, capsule.Payload)
+
+						err = loader.Destroy(context.Background(), capsule)
+						require.NoError(err)
+					}
+
+					assert.Equal(payloads, dugPayloads)
+				}
+
+				count, err := d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Hlen().Key(dataloader.payloadsKey()).Build()).AsInt64()
+				require.NoError(err)
+				assert.Zero(count)
+
+				// claim-checked entries fail once the payloads hash is broken,
+				// while the other entries are still buried
+				err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Set().Key(dataloader.payloadsKey()).Value("broken").Build()).Error()
+				require.NoError(err)
+
+				errs, err := dataloader.BuryMany(context.Background(), entries)
+				require.Error(err)
+				require.Len(errs, len(entries))
+
+				for i := 0; i < 5; i++ {
+					assert.NoError(errs[i])
+				}
+
+				assert.Error(errs[5])
+
+				count, err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(dataloader.sortedSetKey).Build()).AsInt64()
+				require.NoError(err)
+				assert.Equal(int64(5), count)
+
+				errs, err = dataloader.BuryMany(context.Background(), nil)
+				require.NoError(err)
+				assert.Empty(errs)
+
+				bulk := make([]Entry[any], 2500)
+				for i := range bulk {
+					bulk[i] = Entry[any]{Payload: fmt.Sprintf("bulk %d", i), UtilUnixMilliTimestamp: now.UnixMilli()}
+				}
+
+				_, err = dataloader.BuryMany(context.Background(), bulk)
+				require.NoError(err)
+
+				count, err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(dataloader.sortedSetKey).Build()).AsInt64()
+				require.NoError(err)
+				assert.Equal(int64(5+len(bulk)), count)
+
+				// all the batches of all the shards are sent in one DoMulti
+				countingClient := &roundTripCountingClient{Client: d.rueidisClient}
+				countedSharded := NewRueidisDataloader[any](sortedSetKey+"/counted", countingClient, DataloaderOption{Shards: 4, ClaimCheckThreshold: 128})
+
+				defer func() {
+					err = countedSharded.DestroyAll(context.Background())
+					assert.NoError(err)
+				}()
+
+				_, err = countedSharded.BuryMany(context.Background(), append(bulk, entries...))
+				require.NoError(err)
+				assert.Equal(int64(1), countingClient.roundTrips.Load())
+
+				count = 0
+
+				for _, shard := range countedSharded.shards {
+					shardCount, err := d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(shard.sortedSetKey).Build()).AsInt64()
+					require.NoError(err)
+
+					count += shardCount
+				}
+
+				assert.Equal(int64(len(bulk)+len(entries)), count)
+			})
+
+			t.Run("BuryInTx", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sortedSetKey := fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				dataloader := NewRueidisDataloader[any](sortedSetKey, d.rueidisClient, DataloaderOption{ClaimCheckThreshold: 128})
+				sharded := NewRueidisDataloader[any](sortedSetKey+"/sharded", d.rueidisClient, DataloaderOption{Shards: 4})
+				stateKeys := make([]string, 0)
+
+				defer func() {
+					err = dataloader.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					err = sharded.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					for _, stateKey := range stateKeys {
+						err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(stateKey).Build()).Error()
+						assert.NoError(err)
+					}
+				}()
+
+				payloads := []any{"follow-up", strings.Repeat("large", 100)}
+
+				for _, loader := range []*RueidisDataloader[any]{dataloader, sharded} {
+					for _, payload := range payloads {
+						capsule := NewTimeCapsule(payload)
+						stateKey := topicKey(loader.sortedSetKey, "state")
+
+						buryCmds, err := loader.BuryInTx(capsule, time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+						require.NoError(err)
+
+						// the state shares the slot of the shard the capsule is routed to
+						if len(loader.shards) > 0 {
+							stateKey = topicKey(loader.shardOf(capsule).sortedSetKey, "state")
+						}
+
+						cmds := append(rueidis.Commands{d.rueidisClient.B().Multi().Build()}, buryCmds...)
+						cmds = append(
+							cmds,
+							d.rueidisClient.B().Set().Key(stateKey).Value(payload.(string)).Build(),
+							d.rueidisClient.B().Exec().Build(),
+						)
+
+						for _, resp := range d.rueidisClient.DoMulti(context.Background(), cmds...) {
+							require.NoError(resp.Error())
+						}
+						stateKeys = append(stateKeys, stateKey)
+
+						state, err := d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Get().Key(stateKey).Build()).ToString()
+						require.NoError(err)
+						assert.Equal(payload, state)
+					}
+
+					dugPayloads := make([]any, 0, len(payloads))
+
+					for {
+						capsule, err := loader.Dig(context.Background())
+						require.NoError(err)
+
+						if capsule == nil {
+							break
+						}
+
+						dugPayloads = append(dugPayloads, capsule.Payload)
+
+						err = loader.Destroy(context.Background(), capsule)
+						require.NoError(err)
+					}
+
+					assert.ElementsMatch(payloads, dugPayloads)
+				}
+
+				// nothing is buried once the transaction is discarded
+				buryCmds, err := dataloader.BuryInTx(NewTimeCapsule[any]("discarded"), time.Now().UTC().UnixMilli())
+				require.NoError(err)
+
+				cmds := append(rueidis.Commands{d.rueidisClient.B().Multi().Build()}, buryCmds...)
+				cmds = append(cmds, d.rueidisClient.B().Discard().Build())
+
+				for _, resp := range d.rueidisClient.DoMulti(context.Background(), cmds...) {
+					require.NoError(resp.Error())
+				}
+
+				_, ok, err := dataloader.NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.False(ok)
+			})
+
+			t.Run("TxHandlerFunc", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				sortedSetKey := fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				dataloader := NewRueidisDataloader[any](sortedSetKey, d.rueidisClient, DataloaderOption{ClaimCheckThreshold: 128})
+				stateKey := topicKey(sortedSetKey, "state")
+
+				defer func() {
+					err = dataloader.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(stateKey).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				setState := dataloader.TxHandlerFunc(func(ctx context.Context, _ *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) (rueidis.Commands, error) {
+					return rueidis.Commands{d.rueidisClient.B().Set().Key(stateKey).Value(capsule.Payload.(string)).Build()}, nil
+				})
+
+				// the writes of the handler are committed along with the ack
+				for _, payload := range []any{"small", strings.Repeat("large", 100)} {
+					err = dataloader.BuryUtil(context.Background(), payload, time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+					require.NoError(err)
+
+					capsule, err := dataloader.Dig(context.Background())
+					require.NoError(err)
+					require.NotNil(capsule)
+
+					err = setState(context.Background(), nil, capsule)
+					require.NoError(err)
+					assert.True(capsule.acked)
+
+					state, err := d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Get().Key(stateKey).Build()).ToString()
+					require.NoError(err)
+					assert.Equal(payload, state)
+
+					count, err := d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(dataloader.inFlightKey()).Build()).AsInt64()
+					require.NoError(err)
+					assert.Zero(count)
+
+					count, err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Hlen().Key(dataloader.payloadsKey()).Build()).AsInt64()
+					require.NoError(err)
+					assert.Zero(count)
+				}
+
+				err = dataloader.BuryUtil(context.Background(), "failed", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err := dataloader.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				// nothing is written nor acked once the handler fails
+				err = dataloader.TxHandlerFunc(func(ctx context.Context, _ *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) (rueidis.Commands, error) {
+					return nil, errors.New("failed")
+				})(context.Background(), nil, capsule)
+				require.Error(err)
+				assert.False(capsule.acked)
+
+				count, err := d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(dataloader.inFlightKey()).Build()).AsInt64()
+				require.NoError(err)
+				assert.Equal(int64(1), count)
+
+				// the capsule is acked even though the writes of the handler failed
+				err = dataloader.TxHandlerFunc(func(ctx context.Context, _ *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) (rueidis.Commands, error) {
+					return rueidis.Commands{d.rueidisClient.B().Hset().Key(stateKey).FieldValue().FieldValue("payload", capsule.Payload.(string)).Build()}, nil
+				})(context.Background(), nil, capsule)
+				require.Error(err)
+				assert.True(capsule.acked)
+
+				count, err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(dataloader.inFlightKey()).Build()).AsInt64()
+				require.NoError(err)
+				assert.Zero(count)
+			})
+
+			t.Run("Destroy", func(t *testing.T) {
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				err = d.BuryUtil(context.Background(), "shouldBeDugOut", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				err = d.Destroy(context.Background(), capsule)
+				require.NoError(err)
+
+				zrangewithscoresCmd := d.rueidisClient.B().Zrange().Key(d.sortedSetKey).Min("0").Max("-1").Withscores().Build()
+				resp := d.rueidisClient.Do(context.Background(), zrangewithscoresCmd)
+				require.NoError(resp.Error())
+
+				mems, err := resp.AsZScores()
+				require.NoError(err)
+				require.Len(mems, 0)
+			})
+
+			t.Run("ClaimCheck", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				d.option.ClaimCheckThreshold = 1
+
+				defer func() {
+					d.option.ClaimCheckThreshold = 0
+				}()
+
+				err = d.BuryUtil(context.Background(), "shouldBeClaimChecked", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				defer func() {
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey, d.payloadsKey()).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				zrangeCmd := d.rueidisClient.B().Zrange().Key(d.sortedSetKey).Min("0").Max("-1").Build()
+				mems, err := d.rueidisClient.Do(context.Background(), zrangeCmd).AsStrSlice()
+				require.NoError(err)
+				require.Len(mems, 1)
+				assert.True(strings.HasPrefix(mems[0], claimCheckReferencePrefix))
+
+				hlenCmd := d.rueidisClient.B().Hlen().Key(d.payloadsKey()).Build()
+				payloadsCount, err := d.rueidisClient.Do(context.Background(), hlenCmd).AsInt64()
+				require.NoError(err)
+				assert.Equal(int64(1), payloadsCount)
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("shouldBeClaimChecked", capsule.Payload)
+				assert.NotEmpty(capsule.ID)
+
+				err = d.Destroy(context.Background(), capsule)
+				require.NoError(err)
+
+				hlenCmd = d.rueidisClient.B().Hlen().Key(d.payloadsKey()).Build()
+				payloadsCount, err = d.rueidisClient.Do(context.Background(), hlenCmd).AsInt64()
+				require.NoError(err)
+				assert.Zero(payloadsCount)
+			})
+
+			t.Run("Quarantine", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+				scheduledAt := time.Now().UTC().Add(-5 * time.Millisecond).UnixMilli()
+
+				zaddCmd := d.rueidisClient.B().Zadd().Key(d.sortedSetKey).ScoreMember().ScoreMember(float64(scheduledAt), "not-a-capsule").Build()
+				err = d.rueidisClient.Do(context.Background(), zaddCmd).Error()
+				require.NoError(err)
+
+				defer func() {
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey, d.quarantineKey()).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				capsule, err := d.Dig(context.Background())
+				require.ErrorIs(err, ErrQuarantined)
+				require.Nil(capsule)
+
+				memsCount, err := d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zcard().Key(d.sortedSetKey).Build()).AsInt64()
+				require.NoError(err)
+				assert.Zero(memsCount)
+
+				quarantinedCapsules, err := d.Quarantined(context.Background())
+				require.NoError(err)
+				require.Len(quarantinedCapsules, 1)
+				assert.Equal("not-a-capsule", quarantinedCapsules[0].Member)
+				assert.Equal("not-a-capsule", quarantinedCapsules[0].Content)
+				assert.Equal(scheduledAt, quarantinedCapsules[0].ScheduledAt)
+				assert.NotEmpty(quarantinedCapsules[0].Error)
+				assert.NotZero(quarantinedCapsules[0].QuarantinedAt)
+
+				err = d.DeleteQuarantined(context.Background(), quarantinedCapsules[0].Member)
+				require.NoError(err)
+
+				quarantinedCapsules, err = d.Quarantined(context.Background())
+				require.NoError(err)
+				assert.Empty(quarantinedCapsules)
+			})
+
+			t.Run("DeadLetter", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				err = d.BuryUtil(context.Background(), "shouldBeDeadLettered", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				defer func() {
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey, d.inFlightKey(), d.deadLettersKey()).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				capsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+
+				err = d.DeadLetter(context.Background(), capsule, errors.New("failed to handle"))
+				require.NoError(err)
+
+				deadLetteredCapsules, err := d.DeadLetters(context.Background())
+				require.NoError(err)
+				require.Len(deadLetteredCapsules, 1)
+				assert.Equal(capsule.Base64String(), deadLetteredCapsules[0].Member)
+				assert.Equal(capsule.Base64String(), deadLetteredCapsules[0].Content)
+				assert.Equal("failed to handle", deadLetteredCapsules[0].Reason)
+				assert.NotZero(deadLetteredCapsules[0].DeadLetteredAt)
+
+				err = d.DeleteDeadLetter(context.Background(), deadLetteredCapsules[0].Member)
+				require.NoError(err)
+
+				deadLetteredCapsules, err = d.DeadLetters(context.Background())
+				require.NoError(err)
+				assert.Empty(deadLetteredCapsules)
+			})
+
+			t.Run("DestroyAll", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+				require.NoError(err)
+
+				d.sortedSetKey = fmt.Sprintf("test/timecapsule/redis/zset/%d", randomSeed.Int64())
+
+				err = d.BuryUtil(context.Background(), "shouldNotBeDugOut", time.Now().UTC().Add(5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				defer func() {
+					err = d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Del().Key(d.sortedSetKey).Build()).Error()
+					assert.NoError(err)
+				}()
+
+				err = d.DestroyAll(context.Background())
+				require.NoError(err)
+
+				zcountCmd := d.rueidisClient.B().Zcount().Key(d.sortedSetKey).Min("-inf").Max("+inf").Build()
+				resp := d.rueidisClient.Do(context.Background(), zcountCmd)
+				require.NoError(resp.Error())
+
+				memsCount, err := resp.AsInt64()
+				require.NoError(err)
+				assert.Zero(memsCount)
+			})
+		})
+	}
+}
+
+// newDroppingProxy proxies the connections to upstream, dropConnections closes
+// the proxied connections, the proxy keeps accepting new ones.
+func newDroppingProxy(t *testing.T, upstream string) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = listener.Close() })
+
+	var mutex sync.Mutex
+	var conns []net.Conn
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			upstreamConn, err := net.Dial("tcp", upstream)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+
+			mutex.Lock()
+			conns = append(conns, conn, upstreamConn)
+			mutex.Unlock()
+
+			go func() { _, _ = io.Copy(upstreamConn, conn); _ = upstreamConn.Close() }()
+			go func() { _, _ = io.Copy(conn, upstreamConn); _ = conn.Close() }()
+		}
+	}()
+
+	dropConnections := func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+
+		conns = nil
+	}
+
+	t.Cleanup(dropConnections)
+
+	return listener.Addr().String(), dropConnections
 }
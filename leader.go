@@ -0,0 +1,89 @@
+package timecapsule
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrNotLeader is returned by Dig when the fencing token of the digger is no
+// longer the one of the current leader of the topic.
+var ErrNotLeader = errors.New("not the leader of the topic")
+
+// LeaderElector is implemented by the dataloaders which are able to elect a
+// leader among the diggers of the same topic, see
+// TimeCapsuleDiggerOption.LeaderLeaseDuration.
+type LeaderElector interface {
+	// AcquireLeadership acquires or renews the leadership of the topic for the
+	// holder, acquired is false if another holder is the leader. The fencing
+	// token increases every time the leadership changes hands.
+	AcquireLeadership(ctx context.Context, holderID string, leaseDuration time.Duration) (fencingToken int64, acquired bool, err error)
+	// ResignLeadership gives up the leadership of the topic if the holder is
+	// the leader.
+	ResignLeadership(ctx context.Context, holderID string) error
+}
+
+type fencingTokenContextKey struct{}
+
+// withFencingToken returns a context carrying the fencing token, digging with
+// such context fails with ErrNotLeader once the token is stale.
+func withFencingToken(ctx context.Context, fencingToken int64) context.Context {
+	return context.WithValue(ctx, fencingTokenContextKey{}, fencingToken)
+}
+
+// withoutFencingToken returns a context which carries no fencing token even if
+// ctx does.
+func withoutFencingToken(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fencingTokenContextKey{}, nil)
+}
+
+func fencingTokenFromContext(ctx context.Context) (int64, bool) {
+	fencingToken, ok := ctx.Value(fencingTokenContextKey{}).(int64)
+	return fencingToken, ok
+}
+
+// leadership is the state of the leader election of a digger, it is accessed by
+// the digging goroutine and the keepalive goroutine with mutex held.
+type leadership struct {
+	mutex sync.Mutex
+
+	elector       LeaderElector
+	leaseDuration time.Duration
+	holderID      string
+
+	leading      bool
+	fencingToken int64
+	// The leadership is renewed once renewAt is reached, a third of the lease
+	// duration after it was acquired or renewed last time
+	renewAt time.Time
+}
+
+// acquired records the leadership acquired or renewed with the fencing token
+// at now.
+func (l *leadership) acquired(fencingToken int64, now time.Time) {
+	l.leading = true
+	l.fencingToken = fencingToken
+	l.renewAt = now.Add(l.leaseDuration / 3)
+}
+
+// isLeading reports whether the digger is the leader.
+func (l *leadership) isLeading() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.leading
+}
+
+// lost records that the digger is no longer the leader.
+func (l *leadership) lost() {
+	l.leading = false
+	l.fencingToken = 0
+	l.renewAt = time.Time{}
+}
+
+// renewDue reports whether the leadership should be acquired or renewed at now.
+func (l *leadership) renewDue(now time.Time) bool {
+	return !l.leading || !now.Before(l.renewAt)
+}
@@ -0,0 +1,111 @@
+package timecapsule
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ShardCoordinator is implemented by the dataloaders which are able to
+// distribute the shards of a sharded topic among the diggers of the topic, see
+// TimeCapsuleDiggerOption.MembershipTTL.
+type ShardCoordinator interface {
+	// Heartbeat registers the member or extends its membership for the TTL,
+	// and returns the shards owned by the member. The shards are distributed
+	// among the live members, so that the ownership is rebalanced once a member
+	// joins, leaves, or misses its heartbeats for the TTL.
+	Heartbeat(ctx context.Context, memberID string, ttl time.Duration) (ownedShards []int, err error)
+	// Leave removes the member, its shards will be taken over by the other
+	// members on their next heartbeats.
+	Leave(ctx context.Context, memberID string) error
+}
+
+// ownedShards distributes the shards among the members in a round-robin manner
+// by the order of the member IDs, and returns the shards owned by the member.
+// Nothing is owned by a member which is not one of the members.
+func ownedShards(members []string, memberID string, shards int) []int {
+	sortedMembers := append([]string(nil), members...)
+	sort.Strings(sortedMembers)
+
+	index := sort.SearchStrings(sortedMembers, memberID)
+	if index == len(sortedMembers) || sortedMembers[index] != memberID {
+		return nil
+	}
+
+	owned := make([]int, 0, shards/len(sortedMembers)+1)
+	for shard := index; shard < shards; shard += len(sortedMembers) {
+		owned = append(owned, shard)
+	}
+
+	return owned
+}
+
+type shardsContextKey struct{}
+
+// withShards returns a context carrying the shards owned by the digger, digging
+// with such context only digs the given shards of a sharded topic.
+func withShards(ctx context.Context, shards []int) context.Context {
+	return context.WithValue(ctx, shardsContextKey{}, shards)
+}
+
+// shardsFromContext returns the shards of a sharded topic with the given number
+// of shards which should be dug, which are all the shards unless ctx carries
+// the owned shards.
+func shardsFromContext(ctx context.Context, shards int) []int {
+	owned, ok := ctx.Value(shardsContextKey{}).([]int)
+	if ok {
+		return owned
+	}
+
+	all := make([]int, shards)
+	for shard := range all {
+		all[shard] = shard
+	}
+
+	return all
+}
+
+// membership is the state of the shard ownership of a digger, it is accessed by
+// the digging goroutine and the keepalive goroutine with mutex held.
+type membership struct {
+	mutex sync.Mutex
+
+	coordinator ShardCoordinator
+	ttl         time.Duration
+	memberID    string
+
+	joined      bool
+	ownedShards []int
+	// The membership is extended once heartbeatAt is reached, a third of the
+	// TTL after the last successful heartbeat
+	heartbeatAt time.Time
+}
+
+// beat records the shards owned after a successful heartbeat at now.
+func (m *membership) beat(ownedShards []int, now time.Time) {
+	m.joined = true
+	m.ownedShards = ownedShards
+	m.heartbeatAt = now.Add(m.ttl / 3)
+}
+
+// owned returns the shards owned by the digger.
+func (m *membership) owned() []int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.ownedShards
+}
+
+// left records that the digger is no longer a member.
+func (m *membership) left() {
+	m.joined = false
+	m.ownedShards = nil
+	m.heartbeatAt = time.Time{}
+}
+
+// heartbeatDue reports whether the membership should be extended at now.
+func (m *membership) heartbeatDue(now time.Time) bool {
+	return !m.joined || !now.Before(m.heartbeatAt)
+}
@@ -0,0 +1,22 @@
+package timecapsule
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwnedShards(t *testing.T) {
+	assert := assert.New(t)
+
+	members := []string{"b", "a", "c"}
+
+	assert.Equal([]int{0, 3, 6}, ownedShards(members, "a", 8))
+	assert.Equal([]int{1, 4, 7}, ownedShards(members, "b", 8))
+	assert.Equal([]int{2, 5}, ownedShards(members, "c", 8))
+	assert.Nil(ownedShards(members, "d", 8))
+	assert.Equal([]string{"b", "a", "c"}, members)
+
+	assert.Equal([]int{0, 1, 2, 3}, ownedShards([]string{"a"}, "a", 4))
+	assert.Empty(ownedShards(members, "c", 2))
+}
@@ -0,0 +1,100 @@
+package timecapsule
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// HandlerFunc is the function to handle the capsules dug out by the digger.
+type HandlerFunc[P any] func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error
+
+// Middleware wraps a HandlerFunc with extra behaviours, such as logging,
+// panic recovery and timeouts.
+type Middleware[P any] func(next HandlerFunc[P]) HandlerFunc[P]
+
+// chainMiddlewares wraps the handler with the middlewares, the first middleware
+// will be the outermost one.
+func chainMiddlewares[P any](handlerFunc HandlerFunc[P], middlewares ...Middleware[P]) HandlerFunc[P] {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handlerFunc = middlewares[i](handlerFunc)
+	}
+
+	return handlerFunc
+}
+
+// LoggingMiddleware logs the capsules handled with the time spent and the error
+// returned by the handler.
+func LoggingMiddleware[P any](logger TimeCapsuleLogger) Middleware[P] {
+	return func(next HandlerFunc[P]) HandlerFunc[P] {
+		return func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error {
+			start := time.Now()
+
+			err := next(ctx, digger, capsule)
+			if err != nil {
+				logger.Errorf("[TimeCapsule] failed to handle capsule in %v: %v", time.Since(start), err)
+				return err
+			}
+
+			logger.Debugf("[TimeCapsule] handled capsule in %v", time.Since(start))
+
+			return nil
+		}
+	}
+}
+
+// RecoverMiddleware recovers the panic of the handler and returns it as error
+// with the stack trace attached.
+func RecoverMiddleware[P any]() Middleware[P] {
+	return func(next HandlerFunc[P]) HandlerFunc[P] {
+		return func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic recovered: %v\n%s", r, debug.Stack())
+				}
+			}()
+
+			return next(ctx, digger, capsule)
+		}
+	}
+}
+
+// TimeoutMiddleware cancels the context passed to the handler once the timeout
+// is reached.
+func TimeoutMiddleware[P any](timeout time.Duration) Middleware[P] {
+	return func(next HandlerFunc[P]) HandlerFunc[P] {
+		return func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			return next(ctx, digger, capsule)
+		}
+	}
+}
+
+// ContextMiddleware derives the context passed to the handler with contextFunc,
+// which is useful to inject values such as authentication contexts.
+func ContextMiddleware[P any](contextFunc func(ctx context.Context, capsule *TimeCapsule[P]) context.Context) Middleware[P] {
+	return func(next HandlerFunc[P]) HandlerFunc[P] {
+		return func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error {
+			return next(contextFunc(ctx, capsule), digger, capsule)
+		}
+	}
+}
+
+// MetricsMiddleware reports the time spent and the error returned by the
+// handler of every capsule to observeFunc, which is useful to record metrics.
+func MetricsMiddleware[P any](observeFunc func(capsule *TimeCapsule[P], duration time.Duration, err error)) Middleware[P] {
+	return func(next HandlerFunc[P]) HandlerFunc[P] {
+		return func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error {
+			start := time.Now()
+
+			err := next(ctx, digger, capsule)
+			observeFunc(capsule, time.Since(start), err)
+
+			return err
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package timecapsule
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testContextKey struct{}
+
+func TestMiddlewares(t *testing.T) {
+	t.Run("Chain", func(t *testing.T) {
+		assert := assert.New(t)
+
+		calls := make([]string, 0)
+		middleware := func(name string) Middleware[string] {
+			return func(next HandlerFunc[string]) HandlerFunc[string] {
+				return func(ctx context.Context, digger *TimeCapsuleDigger[string], capsule *TimeCapsule[string]) error {
+					calls = append(calls, name)
+					return next(ctx, digger, capsule)
+				}
+			}
+		}
+
+		handlerFunc := chainMiddlewares(func(ctx context.Context, digger *TimeCapsuleDigger[string], capsule *TimeCapsule[string]) error {
+			calls = append(calls, "handler")
+			return nil
+		}, middleware("first"), middleware("second"))
+
+		err := handlerFunc(context.Background(), nil, NewTimeCapsule("hello"))
+		assert.NoError(err)
+		assert.Equal([]string{"first", "second", "handler"}, calls)
+	})
+
+	t.Run("LoggingMiddleware", func(t *testing.T) {
+		assert := assert.New(t)
+
+		handlerFunc := chainMiddlewares(func(ctx context.Context, digger *TimeCapsuleDigger[string], capsule *TimeCapsule[string]) error {
+			return errors.New("failed")
+		}, LoggingMiddleware[string](logrus.New()))
+
+		err := handlerFunc(context.Background(), nil, NewTimeCapsule("hello"))
+		assert.EqualError(err, "failed")
+	})
+
+	t.Run("RecoverMiddleware", func(t *testing.T) {
+		assert := assert.New(t)
+
+		handlerFunc := chainMiddlewares(func(ctx context.Context, digger *TimeCapsuleDigger[string], capsule *TimeCapsule[string]) error {
+			panic("boom")
+		}, RecoverMiddleware[string]())
+
+		err := handlerFunc(context.Background(), nil, NewTimeCapsule("hello"))
+		assert.ErrorContains(err, "boom")
+	})
+
+	t.Run("TimeoutMiddleware", func(t *testing.T) {
+		assert := assert.New(t)
+
+		handlerFunc := chainMiddlewares(func(ctx context.Context, digger *TimeCapsuleDigger[string], capsule *TimeCapsule[string]) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, TimeoutMiddleware[string](10*time.Millisecond))
+
+		err := handlerFunc(context.Background(), nil, NewTimeCapsule("hello"))
+		assert.ErrorIs(err, context.DeadlineExceeded)
+	})
+
+	t.Run("ContextMiddleware", func(t *testing.T) {
+		assert := assert.New(t)
+
+		var value any
+
+		handlerFunc := chainMiddlewares(func(ctx context.Context, digger *TimeCapsuleDigger[string], capsule *TimeCapsule[string]) error {
+			value = ctx.Value(testContextKey{})
+			return nil
+		}, ContextMiddleware(func(ctx context.Context, capsule *TimeCapsule[string]) context.Context {
+			return context.WithValue(ctx, testContextKey{}, capsule.Payload)
+		}))
+
+		err := handlerFunc(context.Background(), nil, NewTimeCapsule("hello"))
+		assert.NoError(err)
+		assert.Equal("hello", value)
+	})
+
+	t.Run("MetricsMiddleware", func(t *testing.T) {
+		assert := assert.New(t)
+
+		var observedErr error
+
+		handlerFunc := chainMiddlewares(func(ctx context.Context, digger *TimeCapsuleDigger[string], capsule *TimeCapsule[string]) error {
+			return errors.New("failed")
+		}, MetricsMiddleware(func(capsule *TimeCapsule[string], duration time.Duration, err error) {
+			observedErr = err
+		}))
+
+		err := handlerFunc(context.Background(), nil, NewTimeCapsule("hello"))
+		assert.Error(err)
+		assert.Equal(err, observedErr)
+	})
+
+	for k, d := range dataloders {
+		d := d
+
+		t.Run(k, func(t *testing.T) {
+			t.Run("Use", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				digger := NewDigger(d, time.Second)
+				require.NotNil(digger)
+
+				var value any
+
+				digger.Use(
+					RecoverMiddleware[any](),
+					ContextMiddleware(func(ctx context.Context, capsule *TimeCapsule[any]) context.Context {
+						return context.WithValue(ctx, testContextKey{}, capsule.Payload)
+					}),
+				)
+				digger.SetHandlerFunc(func(ctx context.Context, digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) error {
+					value = ctx.Value(testContextKey{})
+					panic("boom")
+				})
+
+				assert.NotPanics(func() {
+					digger.handle(NewTimeCapsule[any]("hello"))
+				})
+				assert.Equal("hello", value)
+			})
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package timecapsule
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// prefetchedCapsules is a min heap of the prefetched capsules ordered by the
+// time they are scheduled at.
+type prefetchedCapsules[P any] []*TimeCapsule[P]
+
+func (h prefetchedCapsules[P]) Len() int           { return len(h) }
+func (h prefetchedCapsules[P]) Less(i, j int) bool { return h[i].ScheduledAt < h[j].ScheduledAt }
+func (h prefetchedCapsules[P]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *prefetchedCapsules[P]) Push(x any) {
+	*h = append(*h, x.(*TimeCapsule[P]))
+}
+
+func (h *prefetchedCapsules[P]) Pop() any {
+	old := *h
+	n := len(old)
+	capsule := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+
+	return capsule
+}
+
+// prefetchBuffer holds the capsules which are leased before they are due, so
+// that they can be handled at the exact time they are scheduled at. It is used
+// by the digging goroutine, and drained by Shutdown once its context is done.
+type prefetchBuffer[P any] struct {
+	mutex    sync.Mutex
+	capsules prefetchedCapsules[P]
+}
+
+func (b *prefetchBuffer[P]) push(capsule *TimeCapsule[P]) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	heap.Push(&b.capsules, capsule)
+}
+
+// next returns the earliest prefetched capsule without removing it, ok is false
+// if the buffer is empty.
+func (b *prefetchBuffer[P]) next() (*TimeCapsule[P], bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.capsules) == 0 {
+		return nil, false
+	}
+
+	return b.capsules[0], true
+}
+
+// popDue removes and returns the earliest prefetched capsule if it is due at
+// the given unix milli timestamp.
+func (b *prefetchBuffer[P]) popDue(unixMilliTimestamp int64) (*TimeCapsule[P], bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.capsules) == 0 || b.capsules[0].ScheduledAt > unixMilliTimestamp {
+		return nil, false
+	}
+
+	return heap.Pop(&b.capsules).(*TimeCapsule[P]), true
+}
+
+// drain removes and returns all the prefetched capsules.
+func (b *prefetchBuffer[P]) drain() []*TimeCapsule[P] {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	capsules := b.capsules
+	b.capsules = nil
+
+	return capsules
+}
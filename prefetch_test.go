@@ -0,0 +1,43 @@
+package timecapsule
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefetchBuffer(t *testing.T) {
+	assert := assert.New(t)
+
+	var buffer prefetchBuffer[string]
+
+	for _, scheduledAt := range []int64{300, 100, 200} {
+		buffer.push(&TimeCapsule[string]{ScheduledAt: scheduledAt})
+	}
+
+	next, ok := buffer.next()
+	assert.True(ok)
+	assert.Equal(int64(100), next.ScheduledAt)
+
+	capsule, ok := buffer.popDue(50)
+	assert.False(ok)
+	assert.Nil(capsule)
+
+	capsule, ok = buffer.popDue(200)
+	assert.True(ok)
+	assert.Equal(int64(100), capsule.ScheduledAt)
+
+	capsule, ok = buffer.popDue(200)
+	assert.True(ok)
+	assert.Equal(int64(200), capsule.ScheduledAt)
+
+	capsule, ok = buffer.popDue(200)
+	assert.False(ok)
+	assert.Nil(capsule)
+
+	assert.Len(buffer.drain(), 1)
+	assert.Empty(buffer.drain())
+
+	_, ok = buffer.next()
+	assert.False(ok)
+}
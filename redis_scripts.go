@@ -0,0 +1,424 @@
+package timecapsule
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// claimCheckReferencePrefix is the prefix of sorted set members which only
+// reference a capsule stored in the payloads hash. The colon never appears in
+// the standard base64 alphabet, so it can not be confused with an inline capsule.
+const claimCheckReferencePrefix = "ref:"
+
+// topicKey derives the key of an auxiliary structure (such as the payloads hash)
+// from the sorted set key of a topic.
+//
+// The derived key always hashes to the same slot as the sorted set key, so that
+// scripts touching several keys of the same topic are allowed by clients and
+// servers which check key slots: when the sorted set key has no hash tag, the
+// whole sorted set key is used as the hash tag of the derived key.
+func topicKey(sortedSetKey string, suffix string) string {
+	if hasHashTag(sortedSetKey) {
+		return sortedSetKey + "/" + suffix
+	}
+
+	return "{" + sortedSetKey + "}/" + suffix
+}
+
+// validateSortedSetKey returns an error when the keys derived by topicKey can
+// not hash to the slot of the sorted set key. Without a hash tag, the whole
+// sorted set key becomes the hash tag of the derived keys, which ends at its
+// first '}' (such as {a}b}/payloads, which hashes a instead of a}b).
+func validateSortedSetKey(sortedSetKey string) error {
+	if !hasHashTag(sortedSetKey) && strings.IndexByte(sortedSetKey, '}') >= 0 {
+		return fmt.Errorf("invalid sorted set key %q, it contains '}' without a hash tag", sortedSetKey)
+	}
+
+	return nil
+}
+
+// hasHashTag reports whether the key contains a non-empty hash tag.
+func hasHashTag(key string) bool {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return false
+	}
+
+	end := strings.IndexByte(key[start+1:], '}')
+
+	return end > 0
+}
+
+func claimCheckReference(capsuleID string) string {
+	return claimCheckReferencePrefix + capsuleID
+}
+
+func claimCheckCapsuleID(member string) (string, bool) {
+	if !strings.HasPrefix(member, claimCheckReferencePrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(member, claimCheckReferencePrefix), true
+}
+
+// releaseScore returns the score of the capsule which is handed back to the
+// sorted set, which is the time the capsule was scheduled at, so that capsules
+// handed back before they are due (such as prefetched ones) keep their time,
+// or now if the capsule was never scheduled.
+func releaseScore[P any](capsule *TimeCapsule[P], now int64) int64 {
+	if capsule.ScheduledAt > 0 {
+		return capsule.ScheduledAt
+	}
+
+	return now
+}
+
+// parseScore parses the score of a sorted set member returned as string.
+func parseScore(score string) (int64, error) {
+	parsed, err := strconv.ParseFloat(score, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(parsed), nil
+}
+
+// newQuarantineRecord encodes the quarantine record of a capsule which failed
+// to decode with decodeErr at quarantinedAt.
+func newQuarantineRecord(scheduledAt int64, decodeErr error, quarantinedAt int64) string {
+	record, _ := json.Marshal(QuarantinedCapsule{
+		ScheduledAt:   scheduledAt,
+		Error:         decodeErr.Error(),
+		QuarantinedAt: quarantinedAt,
+	})
+
+	return string(record)
+}
+
+// parseQuarantineRecord decodes the quarantine record of member.
+func parseQuarantineRecord(member string, record string) (*QuarantinedCapsule, error) {
+	var quarantinedCapsule QuarantinedCapsule
+
+	err := json.Unmarshal([]byte(record), &quarantinedCapsule)
+	if err != nil {
+		return nil, err
+	}
+
+	quarantinedCapsule.Member = member
+	if _, ok := claimCheckCapsuleID(member); !ok {
+		quarantinedCapsule.Content = member
+	}
+
+	return &quarantinedCapsule, nil
+}
+
+// newDeadLetterRecord encodes the dead letter record of a capsule, the content
+// is only kept in the record for claim-check references since the member is
+// the content itself otherwise.
+func newDeadLetterRecord(member string, content string, reason error, deadLetteredAt int64) string {
+	deadLetteredCapsule := DeadLetteredCapsule{
+		Reason:         reason.Error(),
+		DeadLetteredAt: deadLetteredAt,
+	}
+	if member != content {
+		deadLetteredCapsule.Content = content
+	}
+
+	record, _ := json.Marshal(deadLetteredCapsule)
+
+	return string(record)
+}
+
+// parseDeadLetterRecord decodes the dead letter record of member.
+func parseDeadLetterRecord(member string, record string) (*DeadLetteredCapsule, error) {
+	var deadLetteredCapsule DeadLetteredCapsule
+
+	err := json.Unmarshal([]byte(record), &deadLetteredCapsule)
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetteredCapsule.Member = member
+	if deadLetteredCapsule.Content == "" {
+		deadLetteredCapsule.Content = member
+	}
+
+	return &deadLetteredCapsule, nil
+}
+
+// buryScriptSource adds the members to the sorted set, and publishes the
+// earliest score of them to the buried notification channel only when it is
+// earlier than the earliest member the sorted set had, since the diggers wake
+// up by then anyway otherwise. Failing to publish is ignored, the members are
+// buried anyway and the diggers still wake up by polling.
+//
+//	KEYS[1]: sorted set key
+//	ARGV[1]: buried notification channel
+//	ARGV[2...]: score and member pairs
+const buryScriptSource = `
+local head = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local earliest = 2
+for i = 4, #ARGV, 2 do
+	if tonumber(ARGV[i]) < tonumber(ARGV[earliest]) then
+		earliest = i
+	end
+end
+local added = redis.call('ZADD', KEYS[1], unpack(ARGV, 2))
+if head[2] == nil or tonumber(ARGV[earliest]) < tonumber(head[2]) then
+	redis.pcall('PUBLISH', ARGV[1], ARGV[earliest])
+end
+return added
+`
+
+// buryClaimCheckScriptSource stores the encoded capsule into the payloads hash
+// and the reference into the sorted set atomically, and publishes the same way
+// as buryScriptSource.
+//
+//	KEYS[1]: sorted set key
+//	KEYS[2]: payloads hash key
+//	ARGV[1]: score
+//	ARGV[2]: reference member
+//	ARGV[3]: capsule ID
+//	ARGV[4]: encoded capsule
+//	ARGV[5]: buried notification channel
+const buryClaimCheckScriptSource = `
+redis.call('HSET', KEYS[2], ARGV[3], ARGV[4])
+local head = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local added = redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+if head[2] == nil or tonumber(ARGV[1]) < tonumber(head[2]) then
+	redis.pcall('PUBLISH', ARGV[5], ARGV[1])
+end
+return added
+`
+
+// leaseRequeueLimit is the max number of capsules with expired leases handed
+// back to the sorted set per dig.
+const leaseRequeueLimit = 100
+
+// digScriptSource hands the capsules with expired leases back to the sorted set,
+// then leases the earliest capsule which is due by moving it to the in-flight
+// sorted set, and resolves the claim-check reference if there is one. Nothing
+// is dug out while the topic is paused, or when the fencing token is given but
+// is not the one of the current leader. The shards of a sharded topic omit the
+// paused flag key and the leader hash key, since they are checked against the
+// topic once per dig instead.
+//
+// With the server time flag, now is taken from the TIME of Redis instead, and
+// the lease deadline and the due timestamp are shifted by the skew between the
+// clocks, so that all the diggers share the clock of Redis. Effects replication
+// is enabled first, since Redis before 5.0 refuses to write after TIME
+// otherwise.
+//
+//	KEYS[1]: sorted set key
+//	KEYS[2]: payloads hash key
+//	KEYS[3]: in-flight sorted set key
+//	KEYS[4]: paused flag key, omitted by the shards
+//	KEYS[5]: leader hash key, omitted by the shards
+//	ARGV[1]: now unix milli timestamp
+//	ARGV[2]: claim-check reference prefix
+//	ARGV[3]: lease deadline unix milli timestamp
+//	ARGV[4]: max number of capsules with expired leases to hand back
+//	ARGV[5]: unix milli timestamp until which capsules are considered due
+//	ARGV[6]: fencing token of the leader, empty if there is no leader election
+//	ARGV[7]: '1' to take now from the TIME of Redis, empty otherwise
+//
+// Returns nil when there is no due capsule, otherwise { member, score, encoded
+// capsule, now, lease deadline }.
+const digScriptSource = `
+local now = tonumber(ARGV[1])
+local leaseDeadline = tonumber(ARGV[3])
+local util = tonumber(ARGV[5])
+if ARGV[7] == '1' then
+	if redis.replicate_commands then
+		redis.replicate_commands()
+	end
+
+	local time = redis.call('TIME')
+	local serverNow = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+	local skew = serverNow - now
+
+	now = serverNow
+	leaseDeadline = leaseDeadline + skew
+	util = util + skew
+end
+
+if #KEYS >= 5 and ARGV[6] ~= '' and redis.call('HGET', KEYS[5], 'token') ~= ARGV[6] then
+	return redis.error_reply('NOTLEADER fencing token is stale')
+end
+if #KEYS >= 4 and redis.call('EXISTS', KEYS[4]) == 1 then
+	return false
+end
+
+local expired = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', now, 'LIMIT', 0, ARGV[4])
+for _, member in ipairs(expired) do
+	redis.call('ZREM', KEYS[3], member)
+	redis.call('ZADD', KEYS[1], now, member)
+end
+
+local head = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', util, 'WITHSCORES', 'LIMIT', 0, 1)
+if #head == 0 then
+	return false
+end
+
+redis.call('ZREM', KEYS[1], head[1])
+redis.call('ZADD', KEYS[3], leaseDeadline, head[1])
+
+local encoded = head[1]
+if string.sub(head[1], 1, #ARGV[2]) == ARGV[2] then
+	encoded = redis.call('HGET', KEYS[2], string.sub(head[1], #ARGV[2] + 1))
+end
+
+return { head[1], head[2], encoded, now, leaseDeadline }
+`
+
+// notLeaderErrorPrefix is the prefix of the error replied by digScriptSource
+// when the fencing token is stale.
+const notLeaderErrorPrefix = "NOTLEADER"
+
+// acquireLeadershipScriptSource acquires the leadership of the topic for the
+// holder if there is no leader, or renews it if the holder is the leader. A new
+// fencing token is issued every time the leadership is acquired.
+//
+//	KEYS[1]: leader hash key
+//	KEYS[2]: fencing token counter key
+//	ARGV[1]: holder ID
+//	ARGV[2]: lease duration in milliseconds
+//
+// Returns the fencing token, or nil if another holder is the leader.
+const acquireLeadershipScriptSource = `
+local holder = redis.call('HGET', KEYS[1], 'holder')
+if holder and holder ~= ARGV[1] then
+	return false
+end
+
+local token
+if holder then
+	token = redis.call('HGET', KEYS[1], 'token')
+else
+	token = redis.call('INCR', KEYS[2])
+	redis.call('HSET', KEYS[1], 'holder', ARGV[1], 'token', token)
+end
+
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+
+return tonumber(token)
+`
+
+// resignLeadershipScriptSource gives up the leadership of the topic if the
+// holder is the leader.
+//
+//	KEYS[1]: leader hash key
+//	ARGV[1]: holder ID
+const resignLeadershipScriptSource = `
+if redis.call('HGET', KEYS[1], 'holder') == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+
+return 0
+`
+
+// heartbeatScriptSource removes the members which missed their heartbeats, then
+// registers the member or extends its membership.
+//
+//	KEYS[1]: members sorted set key
+//	ARGV[1]: now unix milli timestamp
+//	ARGV[2]: membership deadline unix milli timestamp
+//	ARGV[3]: member ID
+//	ARGV[4]: TTL in milliseconds
+//
+// Returns the IDs of the live members.
+const heartbeatScriptSource = `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[1])
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+
+return redis.call('ZRANGE', KEYS[1], 0, -1)
+`
+
+// fencingTokenArg returns the fencing token carried by ctx as script argument,
+// which is empty if there is none.
+func fencingTokenArg(ctx context.Context) string {
+	fencingToken, ok := fencingTokenFromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	return strconv.FormatInt(fencingToken, 10)
+}
+
+// serverTimeArg returns the server time flag of digScriptSource as script
+// argument.
+func serverTimeArg(serverTime bool) string {
+	if !serverTime {
+		return ""
+	}
+
+	return "1"
+}
+
+// releaseScriptSource hands the leased capsule back to the sorted set, it does
+// nothing if the capsule is no longer leased.
+//
+//	KEYS[1]: sorted set key
+//	KEYS[2]: in-flight sorted set key
+//	ARGV[1]: member
+//	ARGV[2]: score
+const releaseScriptSource = `
+if redis.call('ZREM', KEYS[2], ARGV[1]) == 0 then
+	return 0
+end
+
+return redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+`
+
+// destroyScriptSource removes the capsule from both the sorted set and the
+// in-flight sorted set, and the referenced payload from the payloads hash if
+// the member is a claim-check reference.
+//
+//	KEYS[1]: sorted set key
+//	KEYS[2]: payloads hash key
+//	KEYS[3]: in-flight sorted set key
+//	ARGV[1]: member
+//	ARGV[2]: claim-check reference prefix
+const destroyScriptSource = `
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZREM', KEYS[3], ARGV[1])
+if string.sub(ARGV[1], 1, #ARGV[2]) == ARGV[2] then
+	redis.call('HDEL', KEYS[2], string.sub(ARGV[1], #ARGV[2] + 1))
+end
+
+return 1
+`
+
+// quarantineScriptSource moves the leased member which failed to decode to the
+// quarantine hash.
+//
+//	KEYS[1]: quarantine hash key
+//	KEYS[2]: in-flight sorted set key
+//	ARGV[1]: member
+//	ARGV[2]: quarantine record
+const quarantineScriptSource = `
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return redis.call('ZREM', KEYS[2], ARGV[1])
+`
+
+// deleteQuarantinedScriptSource removes the member from the quarantine hash,
+// and the referenced payload from the payloads hash if the member is a
+// claim-check reference.
+//
+//	KEYS[1]: quarantine hash key
+//	KEYS[2]: payloads hash key
+//	ARGV[1]: member
+//	ARGV[2]: claim-check reference prefix
+const deleteQuarantinedScriptSource = `
+redis.call('HDEL', KEYS[1], ARGV[1])
+if string.sub(ARGV[1], 1, #ARGV[2]) == ARGV[2] then
+	redis.call('HDEL', KEYS[2], string.sub(ARGV[1], #ARGV[2] + 1))
+end
+
+return 1
+`
@@ -0,0 +1,123 @@
+package timecapsule
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/rueidis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keyHashTag returns the part of the key which Redis Cluster hashes to find the
+// slot of the key.
+func keyHashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+
+	return key[start+1 : start+1+end]
+}
+
+func TestTopicKey(t *testing.T) {
+	for _, sortedSetKey := range []string{"some/task/key", "{some}/task/key", "some/{task}/key", "some/{task/key", "some/{task}}/key"} {
+		t.Run(sortedSetKey, func(t *testing.T) {
+			assert := assert.New(t)
+
+			d := NewRedisDataloader[any](sortedSetKey, nil)
+
+			for _, key := range []string{
+				d.payloadsKey(),
+				d.inFlightKey(),
+				d.quarantineKey(),
+				d.deadLettersKey(),
+				d.pausedKey(),
+				d.leaderKey(),
+				d.fencingTokenKey(),
+			} {
+				assert.Equal(keyHashTag(sortedSetKey), keyHashTag(key), key)
+			}
+		})
+	}
+}
+
+func TestValidateSortedSetKey(t *testing.T) {
+	for _, sortedSetKey := range []string{"a}b", "{}a}b", "a}{}b"} {
+		t.Run(sortedSetKey, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			assert.Error(validateSortedSetKey(sortedSetKey))
+
+			clusterClient := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{net.JoinHostPort("localhost", "6379")}})
+			defer clusterClient.Close()
+
+			_, err := NewRedisDataloaderE[any](sortedSetKey, clusterClient)
+			require.Error(err)
+
+			if len(redisDataloaders) == 0 {
+				t.Skipf("%s is set", skipRedisTestsEnv)
+			}
+
+			// hash slots do not matter to the clients which are not in cluster
+			// mode, the keys keep working with them
+			dataloaders := make([]Dataloader[any], 0, len(redisDataloaders)+len(rueidisDataloaders))
+
+			for _, d := range redisDataloaders {
+				dataloader, err := NewRedisDataloaderE[any](sortedSetKey, d.redisClient)
+				require.NoError(err)
+
+				dataloaders = append(dataloaders, dataloader)
+			}
+
+			for _, d := range rueidisDataloaders {
+				// the rueidis clients connect to miniredis in cluster mode
+				_, err := NewRueidisDataloaderE[any](sortedSetKey, d.rueidisClient)
+				require.Error(err)
+
+				for address := range d.rueidisClient.Nodes() {
+					singleClient, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{address}, DisableCache: true, ForceSingleClient: true})
+					require.NoError(err)
+
+					defer singleClient.Close()
+
+					dataloader, err := NewRueidisDataloaderE[any](sortedSetKey, singleClient)
+					require.NoError(err)
+
+					dataloaders = append(dataloaders, dataloader)
+				}
+			}
+
+			for _, dataloader := range dataloaders {
+				err := dataloader.BuryUtil(context.Background(), "hello", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				capsule, err := dataloader.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(capsule)
+				assert.Equal("hello", capsule.Payload)
+
+				err = dataloader.Destroy(context.Background(), capsule)
+				require.NoError(err)
+
+				cleanupKey(t, dataloader)
+			}
+		})
+	}
+
+	for _, sortedSetKey := range []string{"a/b", "a{b", "{a}b}", "a}b{c}"} {
+		t.Run(sortedSetKey, func(t *testing.T) {
+			assert.NoError(t, validateSortedSetKey(sortedSetKey))
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package timecapsule
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// ErrNoRoute is wrapped by the error of Router.HandleFunc for the capsules
+// whose kind has no registered route and no fallback handler, the digger dead
+// letters such capsules whatever its FailurePolicy is.
+var ErrNoRoute = errors.New("no route registered")
+
+// Router dispatches capsules of different kinds dug out by the same digger to
+// the handlers registered for their kinds, the payload of a capsule will be
+// decoded into the Go type registered for its kind before being handled.
+//
+// Router.HandleFunc can be passed to TimeCapsuleDigger.SetHandlerFunc directly,
+// the capsules which fail to decode are then dealt with by the FailurePolicy of
+// the digger, and the capsules which have no route are dead lettered:
+//
+//	router := NewRouter[json.RawMessage]()
+//	RegisterRouteFunc(router, "reminder", func(ctx context.Context, digger *TimeCapsuleDigger[json.RawMessage], capsule *TimeCapsule[Reminder]) error {
+//		// handle the reminder
+//		return nil
+//	})
+//
+//	digger.SetHandlerFunc(router.HandleFunc)
+type Router[P any] struct {
+	routes          map[string]HandlerFunc[P]
+	fallbackHandler HandlerFunc[P]
+}
+
+// NewRouter creates a new Router for diggers of P, P should be a type that any
+// payload can be decoded into, such as json.RawMessage or any.
+func NewRouter[P any]() *Router[P] {
+	return &Router[P]{
+		routes: make(map[string]HandlerFunc[P]),
+	}
+}
+
+// RegisterRoute registers the handler of capsules of the kind, the payload of
+// those capsules will be decoded into T, with the upcasters registered for T.
+func RegisterRoute[P any, T any](router *Router[P], kind string, handlerFunc func(digger *TimeCapsuleDigger[P], capsule *TimeCapsule[T])) {
+	RegisterRouteFunc(router, kind, func(_ context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[T]) error {
+		handlerFunc(digger, capsule)
+		return nil
+	})
+}
+
+// RegisterRouteFunc registers the handler of capsules of the kind, which
+// receives the context of handling and reports the failure of handling as
+// error. The payload of those capsules will be decoded into T, with the
+// upcasters registered for T.
+func RegisterRouteFunc[P any, T any](router *Router[P], kind string, handlerFunc func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[T]) error) {
+	router.routes[kind] = func(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error {
+		typedCapsule, err := NewTimeCapsuleFromBase64String[T](capsule.Base64String())
+		if err != nil {
+			return fmt.Errorf("failed to decode payload of kind %s: %w", kind, err)
+		}
+
+		typedCapsule.DugOutAt = capsule.DugOutAt
+		typedCapsule.ScheduledAt = capsule.ScheduledAt
+		typedCapsule.member = capsule.member
+		typedCapsule.leaseDeadline = capsule.leaseDeadline
+
+		return handlerFunc(ctx, digger, typedCapsule)
+	}
+}
+
+// SetFallbackHandler sets the handler of capsules whose kind has no registered
+// route.
+func (r *Router[P]) SetFallbackHandler(handlerFunc func(digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P])) {
+	if handlerFunc == nil {
+		r.fallbackHandler = nil
+		return
+	}
+
+	r.SetFallbackHandlerFunc(func(_ context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error {
+		handlerFunc(digger, capsule)
+		return nil
+	})
+}
+
+// SetFallbackHandlerFunc sets the handler of capsules whose kind has no
+// registered route, which receives the context of handling and reports the
+// failure of handling as error.
+func (r *Router[P]) SetFallbackHandlerFunc(handlerFunc HandlerFunc[P]) {
+	r.fallbackHandler = handlerFunc
+}
+
+// HandleFunc dispatches the capsule to the handler registered for its kind, it
+// returns the error of the handler, the error of decoding the payload, or an
+// error wrapping ErrNoRoute if there is neither a route nor a fallback handler
+// for the kind.
+func (r *Router[P]) HandleFunc(ctx context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error {
+	route, ok := r.routes[capsule.Kind]
+	if !ok {
+		if r.fallbackHandler != nil {
+			return r.fallbackHandler(ctx, digger, capsule)
+		}
+
+		return fmt.Errorf("%w for kind %q", ErrNoRoute, capsule.Kind)
+	}
+
+	return route(ctx, digger, capsule)
+}
+
+// NewRoutedCapsule creates a capsule of the kind for diggers of P, whose payload
+// is encoded from T and stamped with the current schema version of T.
+func NewRoutedCapsule[P any, T any](kind string, payload T) (*TimeCapsule[P], error) {
+	typedCapsule := NewTimeCapsule(payload)
+	typedCapsule.Kind = kind
+
+	return NewTimeCapsuleFromBase64String[P](typedCapsule.Base64String())
+}
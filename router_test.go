@@ -0,0 +1,192 @@
+package timecapsule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testGreeting struct {
+	Name string `json:"name"`
+}
+
+type testFarewell struct {
+	Name string `json:"name"`
+}
+
+func TestRouter(t *testing.T) {
+	for k, d := range dataloders {
+		d := d
+
+		t.Run(k, func(t *testing.T) {
+			t.Run("Handle", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				digger := NewDigger(d, time.Second)
+				router := NewRouter[any]()
+
+				var greeted string
+
+				RegisterRoute(router, "greeting", func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[testGreeting]) {
+					greeted = capsule.Payload.Name
+				})
+
+				capsule, err := NewRoutedCapsule[any]("greeting", testGreeting{Name: "neko"})
+				require.NoError(err)
+
+				err = digger.BuryCapsule(context.Background(), capsule, time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				defer cleanupKey(t, d)
+
+				dugCapsule, err := d.Dig(context.Background())
+				require.NoError(err)
+				require.NotNil(dugCapsule)
+				assert.Equal("greeting", dugCapsule.Kind)
+
+				err = router.HandleFunc(context.Background(), digger, dugCapsule)
+				require.NoError(err)
+				assert.Equal("neko", greeted)
+			})
+
+			t.Run("HandleFunc", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				digger := NewDigger(d, time.Second, TimeCapsuleDiggerOption{HandlerTimeout: time.Minute})
+				router := NewRouter[any]()
+
+				var routedCapsule *TimeCapsule[testGreeting]
+				var hasDeadline bool
+
+				RegisterRouteFunc(router, "greeting", func(ctx context.Context, digger *TimeCapsuleDigger[any], capsule *TimeCapsule[testGreeting]) error {
+					routedCapsule = capsule
+					_, hasDeadline = ctx.Deadline()
+
+					return nil
+				})
+
+				digger.SetHandlerFunc(router.HandleFunc)
+
+				capsule, err := NewRoutedCapsule[any]("greeting", testGreeting{Name: "neko"})
+				require.NoError(err)
+
+				scheduledAt := time.Now().UTC().Add(-5 * time.Millisecond).UnixMilli()
+
+				err = digger.BuryCapsule(context.Background(), capsule, scheduledAt)
+				require.NoError(err)
+
+				defer cleanupKey(t, d)
+
+				handled, err := digger.DigOnce(context.Background())
+				require.NoError(err)
+				assert.Equal(1, handled)
+
+				require.NotNil(routedCapsule)
+				assert.Equal("neko", routedCapsule.Payload.Name)
+				assert.Equal(scheduledAt, routedCapsule.ScheduledAt)
+				assert.NotZero(routedCapsule.DugOutAt)
+				assert.NotZero(routedCapsule.leaseDeadline)
+				assert.NotEmpty(routedCapsule.member)
+				assert.True(hasDeadline)
+
+				_, ok, err := d.(SchedulePeeker).NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.False(ok)
+			})
+
+			t.Run("HandleFuncFailurePolicy", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				digger := NewDigger(d, time.Second, TimeCapsuleDiggerOption{FailurePolicy: FailurePolicyDeadLetter})
+				router := NewRouter[any]()
+
+				RegisterRouteFunc(router, "greeting", func(ctx context.Context, digger *TimeCapsuleDigger[any], capsule *TimeCapsule[testGreeting]) error {
+					return nil
+				})
+
+				digger.SetHandlerFunc(router.HandleFunc)
+
+				undecodableCapsule, err := NewRoutedCapsule[any]("greeting", "not a greeting")
+				require.NoError(err)
+
+				unroutedCapsule, err := NewRoutedCapsule[any]("farewell", testFarewell{Name: "neko"})
+				require.NoError(err)
+
+				for _, capsule := range []*TimeCapsule[any]{undecodableCapsule, unroutedCapsule} {
+					err = digger.BuryCapsule(context.Background(), capsule, time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+					require.NoError(err)
+				}
+
+				defer cleanupKey(t, d)
+
+				handled, err := digger.DigOnce(context.Background())
+				require.NoError(err)
+				assert.Equal(2, handled)
+
+				reasons := make(map[string]string)
+				for _, deadLetteredCapsule := range listDeadLetters(t, d) {
+					reasons[deadLetteredCapsule.Content] = deadLetteredCapsule.Reason
+				}
+
+				require.Len(reasons, 2)
+				assert.Contains(reasons[undecodableCapsule.Base64String()], "failed to decode payload of kind greeting")
+				assert.Contains(reasons[unroutedCapsule.Base64String()], ErrNoRoute.Error())
+			})
+
+			t.Run("FallbackHandler", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				digger := NewDigger(d, time.Second)
+				router := NewRouter[any]()
+
+				var fallbackKind string
+
+				router.SetFallbackHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+					fallbackKind = capsule.Kind
+				})
+
+				capsule, err := NewRoutedCapsule[any]("farewell", testFarewell{Name: "neko"})
+				require.NoError(err)
+
+				err = router.HandleFunc(context.Background(), digger, capsule)
+				require.NoError(err)
+				assert.Equal("farewell", fallbackKind)
+			})
+
+			t.Run("DeadLetterUnknownKind", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				// dead lettered even with the default FailurePolicyDrop
+				digger := NewDigger(d, time.Second)
+				router := NewRouter[any]()
+
+				digger.SetHandlerFunc(router.HandleFunc)
+
+				capsule, err := NewRoutedCapsule[any]("farewell", testFarewell{Name: "neko"})
+				require.NoError(err)
+
+				err = digger.BuryCapsule(context.Background(), capsule, time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+				require.NoError(err)
+
+				defer cleanupKey(t, d)
+
+				handled, err := digger.DigOnce(context.Background())
+				require.NoError(err)
+				assert.Equal(1, handled)
+
+				deadLetteredCapsules := listDeadLetters(t, d)
+				require.Len(deadLetteredCapsules, 1)
+				assert.Equal(capsule.Base64String(), deadLetteredCapsules[0].Content)
+				assert.Contains(deadLetteredCapsules[0].Reason, "farewell")
+			})
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package timecapsule
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Upcaster migrates the raw JSON payload of a capsule from one schema version
+// to the next one.
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+type payloadSchema struct {
+	version   int
+	upcasters map[int]Upcaster
+}
+
+var (
+	payloadSchemasMutex sync.RWMutex
+	payloadSchemas      = make(map[reflect.Type]*payloadSchema)
+)
+
+func payloadSchemaOf[P any]() *payloadSchema {
+	payloadType := reflect.TypeFor[P]()
+
+	schema, ok := payloadSchemas[payloadType]
+	if !ok {
+		schema = &payloadSchema{upcasters: make(map[int]Upcaster)}
+		payloadSchemas[payloadType] = schema
+	}
+
+	return schema
+}
+
+// RegisterSchemaVersion sets the current schema version of the payload type P.
+// Newly buried capsules of P will be stamped with the version, and capsules
+// stamped with an older version will be migrated by the registered upcasters
+// before being decoded into P.
+//
+// Capsules buried before any version was registered have the version 0.
+func RegisterSchemaVersion[P any](version int) {
+	payloadSchemasMutex.Lock()
+	defer payloadSchemasMutex.Unlock()
+
+	payloadSchemaOf[P]().version = version
+}
+
+// RegisterUpcaster registers the upcaster which migrates the payload of P
+// from fromVersion to fromVersion + 1.
+func RegisterUpcaster[P any](fromVersion int, upcaster Upcaster) {
+	payloadSchemasMutex.Lock()
+	defer payloadSchemasMutex.Unlock()
+
+	payloadSchemaOf[P]().upcasters[fromVersion] = upcaster
+}
+
+// currentSchemaVersion returns the current schema version of the payload type P.
+func currentSchemaVersion[P any]() int {
+	payloadSchemasMutex.RLock()
+	defer payloadSchemasMutex.RUnlock()
+
+	schema, ok := payloadSchemas[reflect.TypeFor[P]()]
+	if !ok {
+		return 0
+	}
+
+	return schema.version
+}
+
+// upcasterChain returns the upcasters which migrate the payload of P from
+// fromVersion to toVersion in order.
+func upcasterChain[P any](fromVersion int, toVersion int) ([]Upcaster, error) {
+	payloadSchemasMutex.RLock()
+	defer payloadSchemasMutex.RUnlock()
+
+	schema := payloadSchemas[reflect.TypeFor[P]()]
+	chain := make([]Upcaster, 0, toVersion-fromVersion)
+
+	for version := fromVersion; version < toVersion; version++ {
+		upcaster, ok := schema.upcasters[version]
+		if !ok {
+			return nil, fmt.Errorf("no upcaster registered for schema version %d of %v", version, reflect.TypeFor[P]())
+		}
+
+		chain = append(chain, upcaster)
+	}
+
+	return chain, nil
+}
+
+// upcast migrates the encoded capsule of P to the current schema version, the
+// data will be returned as is if it is already up to date. The upcasters are
+// invoked without holding the lock of the schemas, so that they are free to
+// call back into the schemas.
+func upcast[P any](data []byte) ([]byte, error) {
+	// nothing to migrate without a schema version registered for P, skip
+	// reading the header then
+	schemaVersion := currentSchemaVersion[P]()
+	if schemaVersion <= 0 {
+		return data, nil
+	}
+
+	var header struct {
+		Version int `json:"version"`
+	}
+
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Version >= schemaVersion {
+		return data, nil
+	}
+
+	chain, err := upcasterChain[P](header.Version, schemaVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := fields["payload"]
+
+	for i, upcaster := range chain {
+		payload, err = upcaster(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upcast schema version %d of %v: %w", header.Version+i, reflect.TypeFor[P](), err)
+		}
+	}
+
+	fields["payload"] = payload
+	fields["version"], _ = json.Marshal(schemaVersion)
+
+	return json.Marshal(fields)
+}
@@ -0,0 +1,61 @@
+package timecapsule
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// shardKey returns the sorted set key of the shard of a sharded topic. The shard
+// keys only hash to different slots of Redis Cluster when sortedSetKey has no
+// hash tag.
+func shardKey(sortedSetKey string, shard int) string {
+	return sortedSetKey + "/shards/" + strconv.Itoa(shard)
+}
+
+// shardOf returns the shard the capsule with the given ID is routed to.
+func shardOf(capsuleID string, shards int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(capsuleID))
+
+	return int(hash.Sum32() % uint32(shards))
+}
+
+// shardPeek is the earliest member of the sorted set and of the in-flight sorted
+// set of a shard.
+type shardPeek struct {
+	scheduledAt int64
+	scheduled   bool
+
+	leaseDeadline int64
+	leased        bool
+}
+
+// dueShards returns the shards which have capsules due until the given
+// timestamp or leases expired by now, ordered by the earliest of them.
+func dueShards(peeks []shardPeek, nowUnixMilliTimestamp int64, utilUnixMilliTimestamp int64) []int {
+	dueAt := make(map[int]int64, len(peeks))
+	shards := make([]int, 0, len(peeks))
+
+	for shard, peek := range peeks {
+		due := false
+
+		if peek.scheduled && peek.scheduledAt <= utilUnixMilliTimestamp {
+			dueAt[shard] = peek.scheduledAt
+			due = true
+		}
+		if peek.leased && peek.leaseDeadline <= nowUnixMilliTimestamp && (!due || peek.leaseDeadline < dueAt[shard]) {
+			dueAt[shard] = peek.leaseDeadline
+			due = true
+		}
+		if due {
+			shards = append(shards, shard)
+		}
+	}
+
+	sort.SliceStable(shards, func(i, j int) bool {
+		return dueAt[shards[i]] < dueAt[shards[j]]
+	})
+
+	return shards
+}
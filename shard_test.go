@@ -0,0 +1,48 @@
+package timecapsule
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardOf(t *testing.T) {
+	assert := assert.New(t)
+
+	counts := make(map[int]int)
+
+	for i := 0; i < 1000; i++ {
+		capsuleID := strconv.Itoa(i)
+
+		shard := shardOf(capsuleID, 4)
+		assert.Equal(shard, shardOf(capsuleID, 4))
+		assert.GreaterOrEqual(shard, 0)
+		assert.Less(shard, 4)
+
+		counts[shard]++
+	}
+
+	assert.Len(counts, 4)
+
+	for _, count := range counts {
+		assert.Greater(count, 150)
+	}
+}
+
+func TestDueShards(t *testing.T) {
+	assert := assert.New(t)
+
+	peeks := []shardPeek{
+		{scheduledAt: 300, scheduled: true},
+		{},
+		{scheduledAt: 100, scheduled: true},
+		{scheduledAt: 900, scheduled: true, leaseDeadline: 200, leased: true},
+		{scheduledAt: 600, scheduled: true},
+		{leaseDeadline: 800, leased: true},
+	}
+
+	assert.Equal([]int{2, 3, 0}, dueShards(peeks, 500, 500))
+	assert.Equal([]int{2, 3, 0, 4}, dueShards(peeks, 500, 700))
+	assert.Empty(dueShards(peeks, 50, 50))
+}
@@ -1,9 +1,14 @@
 package timecapsule
 
 import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/nekomeowww/xo/exp/channelx"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 )
@@ -20,11 +25,73 @@ type TimeCapsuleLogger interface {
 	Errorf(format string, args ...interface{})
 }
 
+// FailurePolicy is the policy of what to do with a capsule whose handler
+// returned an error or panicked. The capsules whose kind has no route, see
+// ErrNoRoute, are dead lettered whatever the policy is.
+type FailurePolicy int
+
+const (
+	// FailurePolicyDrop drops the capsule.
+	FailurePolicyDrop FailurePolicy = iota + 1
+	// FailurePolicyRetry buries the capsule again for RetryInterval, and dead
+	// letters it once it has failed for RetryLimit times. The dataloader must
+	// implement CapsuleBurier.
+	FailurePolicyRetry
+	// FailurePolicyDeadLetter moves the capsule to the dead letters. The
+	// capsule is dropped if the dataloader does not implement DeadLetterer.
+	FailurePolicyDeadLetter
+)
+
 // TimeCapsuleDiggerOption is the option for TimeCapsuleDigger.
 type TimeCapsuleDiggerOption struct {
 	RetryLimit    int
 	RetryInterval time.Duration
-	Logger        TimeCapsuleLogger
+	FailurePolicy FailurePolicy
+	// HandlerTimeout is the deadline of handling a capsule, it can be
+	// overridden per capsule with the MetadataKeyTimeout metadata. Zero means
+	// no deadline. A handler which exceeds its deadline counts as failed.
+	HandlerTimeout time.Duration
+	// MaxIdleInterval enables adaptive polling when set: the digger keeps
+	// digging while there are due capsules, then sleeps until the earliest
+	// capsule is due, for MaxIdleInterval at most. The dig interval is used
+	// when the earliest capsule is due but could not be dug out, such as when
+	// the topic is paused. Zero means digging once per dig interval. The
+	// dataloader should implement SchedulePeeker, otherwise the digger sleeps
+	// for the dig interval once nothing is due.
+	//
+	// With adaptive polling, the digger subscribes to the notifications of
+	// buried capsules and wakes up once a capsule due earlier is buried, if the
+	// dataloader implements Subscriber.
+	MaxIdleInterval time.Duration
+	// PrefetchWindow enables prefetching when set: the capsules which are due
+	// within the window are leased in advance and handled at the exact time
+	// they are scheduled at. It should be much shorter than the lease duration
+	// of the dataloader. The prefetched capsules are handed back to the
+	// dataloader once the digger is paused or stopped. The dataloader must
+	// implement Prefetcher and Releaser. Zero disables prefetching.
+	PrefetchWindow time.Duration
+	// LeaderLeaseDuration enables leader election when set: among the diggers
+	// of the same topic, only the leader digs capsules, and the others take
+	// over once the leadership of the leader lapses after the lease duration.
+	// The leader renews its leadership every third of the lease duration on its
+	// own timer, independent of the dig interval and of the handlers, and
+	// resigns once it stops. A paused digger stops renewing its leadership. The
+	// dataloader must implement LeaderElector. Zero disables leader election.
+	LeaderLeaseDuration time.Duration
+	// MembershipTTL enables shard ownership when set: the digger registers as a
+	// member of the sharded topic and heartbeats every third of the TTL on its
+	// own timer, independent of the dig interval and of the handlers, the
+	// shards are distributed among the live members, and the digger only digs
+	// the shards it owns. The shards of a member which leaves or misses its
+	// heartbeats for the TTL are taken over by the others. A paused digger stops
+	// heartbeating. The dataloader must implement ShardCoordinator. Zero
+	// disables shard ownership.
+	MembershipTTL time.Duration
+	// Clock tells the time the digger digs and sleeps by, it should be the same
+	// clock as the one of the dataloader. The deadlines of the handlers and of
+	// the calls to the dataloader always follow the real time.
+	Clock  Clock
+	Logger TimeCapsuleLogger
 }
 
 // DefaultTimeCapsuleDiggerOption returns the default option for TimeCapsuleDigger.
@@ -32,6 +99,8 @@ func DefaultTimeCapsuleDiggerOption() TimeCapsuleDiggerOption {
 	return TimeCapsuleDiggerOption{
 		RetryLimit:    100,
 		RetryInterval: 500 * time.Millisecond,
+		FailurePolicy: FailurePolicyDrop,
+		Clock:         RealClock(),
 		Logger:        logrus.New(),
 	}
 }
@@ -49,6 +118,27 @@ func mergeTimeCapsuleDiggerOption(original *TimeCapsuleDiggerOption, options ...
 	if option.RetryInterval > 0 {
 		original.RetryInterval = option.RetryInterval
 	}
+	if option.FailurePolicy > 0 {
+		original.FailurePolicy = option.FailurePolicy
+	}
+	if option.HandlerTimeout > 0 {
+		original.HandlerTimeout = option.HandlerTimeout
+	}
+	if option.MaxIdleInterval > 0 {
+		original.MaxIdleInterval = option.MaxIdleInterval
+	}
+	if option.PrefetchWindow > 0 {
+		original.PrefetchWindow = option.PrefetchWindow
+	}
+	if option.LeaderLeaseDuration > 0 {
+		original.LeaderLeaseDuration = option.LeaderLeaseDuration
+	}
+	if option.MembershipTTL > 0 {
+		original.MembershipTTL = option.MembershipTTL
+	}
+	if option.Clock != nil {
+		original.Clock = option.Clock
+	}
 	if option.Logger != nil {
 		original.Logger = option.Logger
 	}
@@ -57,18 +147,54 @@ func mergeTimeCapsuleDiggerOption(original *TimeCapsuleDiggerOption, options ...
 }
 
 // TimeCapsuleDigger will keep polling from TimeCapsuleDigger instance for new messages
-// once TimeCapsuleDigger.Start() is called, and will stop once TimeCapsuleDigger.Stop()
-// is called.
+// once TimeCapsuleDigger.Start() or TimeCapsuleDigger.Run() is called, and will stop
+// once TimeCapsuleDigger.Stop() is called.
 type TimeCapsuleDigger[P any] struct {
 	dataloader Dataloader[P]
 	option     TimeCapsuleDiggerOption
 
-	handlerFunc func(digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P])
+	handlerFunc HandlerFunc[P]
+	middlewares []Middleware[P]
 
-	// Digging ticker to notify the goroutine to dig a new capsule
-	diggingTicker *time.Ticker
-	// Puller
-	puller *channelx.Puller[*TimeCapsule[P]]
+	// Context of the lifecycle of the digger, which will be cancelled once
+	// the digger is stopped, handlers receive contexts derived from it
+	lifecycleCtx    context.Context
+	lifecycleCancel context.CancelFunc
+
+	// Context of digging, which will be cancelled once the digger is stopped
+	// or shutting down
+	diggingCtx    context.Context
+	diggingCancel context.CancelFunc
+	// Interval of the timer which notifies the goroutine to dig a new capsule,
+	// the timer is created once the digger starts
+	digInterval time.Duration
+	// Closed once the digging goroutine exits
+	diggingDone chan struct{}
+	// The digging goroutine and the running DigOnce calls, which Shutdown waits
+	// for. They are only added with diggersMutex held before digging is
+	// cancelled, so that Shutdown never waits while they are being added
+	diggers      sync.WaitGroup
+	diggersMutex sync.Mutex
+	// Fatal error of the dataloader which stopped the digging goroutine, it
+	// should only be read after diggingDone is closed
+	diggingErr error
+	started    atomic.Bool
+	// Whether digging is paused, no capsules will be dug out while paused
+	paused atomic.Bool
+	// Leader election among the diggers of the same topic, nil if disabled
+	leadership *leadership
+	// Shard ownership among the diggers of the same topic, nil if disabled
+	membership *membership
+
+	// Capsules being handled, the value reports whether the capsule has been
+	// handed back to the dataloader because the digger was shut down
+	inFlightMutex sync.Mutex
+	inFlight      map[*TimeCapsule[P]]bool
+	// Capsules which are prefetched but not due yet
+	prefetched prefetchBuffer[P]
+	// Skew in milliseconds of the clock the dataloader compares the scores
+	// against from the clock of the digger, see ServerClock
+	serverClockSkew atomic.Int64
 }
 
 // Digger creates a new TimeCapsuleDigger instance which derives from the TimeCapsule instance
@@ -85,24 +211,71 @@ type TimeCapsuleDigger[P any] struct {
 //	digInterval: time.Duration
 func NewDigger[P any](dataloader Dataloader[P], digInterval time.Duration, options ...TimeCapsuleDiggerOption) *TimeCapsuleDigger[P] {
 	digger := &TimeCapsuleDigger[P]{
-		dataloader:    dataloader,
-		option:        DefaultTimeCapsuleDiggerOption(),
-		diggingTicker: time.NewTicker(digInterval),
+		dataloader:  dataloader,
+		option:      DefaultTimeCapsuleDiggerOption(),
+		digInterval: digInterval,
+		diggingDone: make(chan struct{}),
+		inFlight:    make(map[*TimeCapsule[P]]bool),
 	}
 
 	mergeTimeCapsuleDiggerOption(&digger.option, options...)
 
-	digger.puller = channelx.NewPuller[*TimeCapsule[P]]().
-		WithTickerChannel(digger.diggingTicker.C, func(_ time.Time) *TimeCapsule[P] { return digger.dig() }).
-		WithHandler(digger.handle)
+	if digger.option.LeaderLeaseDuration > 0 {
+		elector, ok := dataloader.(LeaderElector)
+		if ok {
+			digger.leadership = &leadership{elector: elector, leaseDuration: digger.option.LeaderLeaseDuration}
+		} else {
+			digger.option.Logger.Errorf("[TimeCapsule] dataloader %v does not support leader election, digging without it", dataloader.Type())
+		}
+	}
+	if digger.option.PrefetchWindow > 0 {
+		_, prefetcher := dataloader.(Prefetcher[P])
+		_, releaser := dataloader.(Releaser[P])
+		if !prefetcher || !releaser {
+			digger.option.Logger.Errorf("[TimeCapsule] dataloader %v does not support prefetching, digging without it", dataloader.Type())
+			digger.option.PrefetchWindow = 0
+		}
+	}
+	if digger.option.MembershipTTL > 0 {
+		coordinator, ok := dataloader.(ShardCoordinator)
+		if ok {
+			digger.membership = &membership{coordinator: coordinator, ttl: digger.option.MembershipTTL}
+		} else {
+			digger.option.Logger.Errorf("[TimeCapsule] dataloader %v does not support shard ownership, digging all the shards", dataloader.Type())
+		}
+	}
+
+	digger.lifecycleCtx, digger.lifecycleCancel = context.WithCancel(context.Background())
+	digger.diggingCtx, digger.diggingCancel = context.WithCancel(digger.lifecycleCtx)
 
 	return digger
 }
 
+// SetHandler sets the handler of the dug out capsules.
 func (t *TimeCapsuleDigger[P]) SetHandler(handlerFunc func(digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P])) {
+	if handlerFunc == nil {
+		t.handlerFunc = nil
+		return
+	}
+
+	t.handlerFunc = func(_ context.Context, digger *TimeCapsuleDigger[P], capsule *TimeCapsule[P]) error {
+		handlerFunc(digger, capsule)
+		return nil
+	}
+}
+
+// SetHandlerFunc sets the handler of the dug out capsules, which receives a
+// context and reports the failure of handling as error.
+func (t *TimeCapsuleDigger[P]) SetHandlerFunc(handlerFunc HandlerFunc[P]) {
 	t.handlerFunc = handlerFunc
 }
 
+// Use appends the middlewares which wrap the handler, the middlewares are
+// applied in order, the first one will be the outermost one.
+func (t *TimeCapsuleDigger[P]) Use(middlewares ...Middleware[P]) {
+	t.middlewares = append(t.middlewares, middlewares...)
+}
+
 // BuryFor bury a capsule for a specific time.
 func (t *TimeCapsuleDigger[P]) BuryFor(ctx context.Context, payload P, forTimeRange time.Duration) error {
 	return t.dataloader.BuryFor(ctx, payload, forTimeRange)
@@ -113,17 +286,332 @@ func (t *TimeCapsuleDigger[P]) BuryUtil(ctx context.Context, payload P, utilUnix
 	return t.dataloader.BuryUtil(ctx, payload, utilUnixMilliTimestamp)
 }
 
-func (t *TimeCapsuleDigger[P]) dig() *TimeCapsule[P] {
+// BuryMany bury capsules of the entries in bulk, see the BuryMany of the
+// dataloader for the per-entry errors. The entries are buried one by one if the
+// dataloader does not implement BulkBurier.
+func (t *TimeCapsuleDigger[P]) BuryMany(ctx context.Context, entries []Entry[P]) ([]error, error) {
+	bulkBurier, ok := t.dataloader.(BulkBurier[P])
+	if ok {
+		return bulkBurier.BuryMany(ctx, entries)
+	}
+
+	errs := make([]error, len(entries))
+	for i, entry := range entries {
+		errs[i] = t.dataloader.BuryUtil(ctx, entry.Payload, entry.UtilUnixMilliTimestamp)
+	}
+
+	return errs, buryManyError(errs)
+}
+
+// BuryCapsule bury a capsule until a specific time, it allows to bury a capsule with
+// fields other than the payload, such as Kind. An error wrapping
+// errors.ErrUnsupported is returned if the dataloader does not implement
+// CapsuleBurier.
+func (t *TimeCapsuleDigger[P]) BuryCapsule(ctx context.Context, capsule *TimeCapsule[P], utilUnixMilliTimestamp int64) error {
+	capsuleBurier, ok := t.dataloader.(CapsuleBurier[P])
+	if !ok {
+		return fmt.Errorf("dataloader %v can not bury capsules: %w", t.dataloader.Type(), errors.ErrUnsupported)
+	}
+
+	return capsuleBurier.BuryCapsule(ctx, capsule, utilUnixMilliTimestamp)
+}
+
+// dig digs a capsule from the dataloader, the errors of the dataloader are
+// returned except ErrNotLeader, which gives up the leadership.
+func (t *TimeCapsuleDigger[P]) dig() (*TimeCapsule[P], error) {
+	// the ticker may still deliver a pending tick after the digger is stopped
+	if t.diggingCtx.Err() != nil || t.paused.Load() {
+		return nil, nil
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
-	dugCapsule, err := t.dataloader.Dig(ctx)
+	if t.leadership != nil {
+		fencingToken, leading := t.lead(ctx)
+		if !leading {
+			return nil, nil
+		}
+
+		ctx = withFencingToken(ctx, fencingToken)
+	}
+	if t.membership != nil {
+		ownedShards, owning := t.heartbeat(ctx)
+		if !owning {
+			return nil, nil
+		}
+
+		ctx = withShards(ctx, ownedShards)
+	}
+
+	var dugCapsule *TimeCapsule[P]
+	var err error
+
+	prefetcher, ok := t.dataloader.(Prefetcher[P])
+	if ok && t.option.PrefetchWindow > 0 {
+		dugCapsule, err = prefetcher.DigUtil(ctx, t.option.Clock.Now().Add(t.option.PrefetchWindow).UnixMilli())
+	} else {
+		dugCapsule, err = t.dataloader.Dig(ctx)
+	}
 	if err != nil {
-		t.option.Logger.Errorf("[TimeCapsule] failed to dig time capsule from dataloader %v: %v", t.dataloader.Type(), err)
-		return nil
+		if errors.Is(err, ErrNotLeader) {
+			t.option.Logger.Warnf("[TimeCapsule] lost the leadership of dataloader %v: %v", t.dataloader.Type(), err)
+
+			t.leadership.mutex.Lock()
+			t.leadership.lost()
+			t.leadership.mutex.Unlock()
+
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	// the digger may be stopped while digging, hand the capsule back instead
+	// of handling it
+	if dugCapsule != nil && t.diggingCtx.Err() != nil {
+		t.release(dugCapsule)
+		return nil, nil
+	}
+
+	return dugCapsule, nil
+}
+
+// lead acquires or renews the leadership of the topic when it is due, and
+// returns the fencing token and whether the digger is the leader.
+func (t *TimeCapsuleDigger[P]) lead(ctx context.Context) (int64, bool) {
+	t.leadership.mutex.Lock()
+	defer t.leadership.mutex.Unlock()
+
+	if !t.leadLocked(ctx) {
+		return 0, false
+	}
+
+	return t.leadership.fencingToken, true
+}
+
+func (t *TimeCapsuleDigger[P]) leadLocked(ctx context.Context) bool {
+	if !t.leadership.renewDue(t.option.Clock.Now()) {
+		return true
+	}
+	if t.leadership.holderID == "" {
+		holderID, err := newCapsuleID()
+		if err != nil {
+			t.option.Logger.Errorf("[TimeCapsule] failed to generate the holder id of leadership: %v", err)
+			return false
+		}
+
+		t.leadership.holderID = holderID
+	}
+
+	fencingToken, acquired, err := t.leadership.elector.AcquireLeadership(ctx, t.leadership.holderID, t.leadership.leaseDuration)
+	if err != nil {
+		t.option.Logger.Errorf("[TimeCapsule] failed to acquire the leadership of dataloader %v: %v", t.dataloader.Type(), err)
+		t.leadership.lost()
+
+		return false
+	}
+	if !acquired {
+		if t.leadership.leading {
+			t.option.Logger.Warnf("[TimeCapsule] lost the leadership of dataloader %v", t.dataloader.Type())
+		}
+
+		t.leadership.lost()
+
+		return false
+	}
+	if !t.leadership.leading {
+		t.option.Logger.Debugf("[TimeCapsule] became the leader of dataloader %v, fencing token: %d", t.dataloader.Type(), fencingToken)
+	}
+
+	t.leadership.acquired(fencingToken, t.option.Clock.Now())
+
+	return true
+}
+
+// resign gives up the leadership of the topic if the digger is the leader.
+func (t *TimeCapsuleDigger[P]) resign() {
+	if t.leadership == nil {
+		return
+	}
+
+	t.leadership.mutex.Lock()
+	defer t.leadership.mutex.Unlock()
+
+	if !t.leadership.leading {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := t.leadership.elector.ResignLeadership(ctx, t.leadership.holderID); err != nil {
+		t.option.Logger.Errorf("[TimeCapsule] failed to resign the leadership of dataloader %v: %v", t.dataloader.Type(), err)
+	} else {
+		t.option.Logger.Debugf("[TimeCapsule] resigned the leadership of dataloader %v", t.dataloader.Type())
+	}
+
+	t.leadership.lost()
+}
+
+// heartbeat extends the membership of the topic when it is due, and returns the
+// owned shards and whether the digger owns any shards.
+func (t *TimeCapsuleDigger[P]) heartbeat(ctx context.Context) ([]int, bool) {
+	t.membership.mutex.Lock()
+	defer t.membership.mutex.Unlock()
+
+	if !t.heartbeatLocked(ctx) {
+		return nil, false
+	}
+
+	return t.membership.ownedShards, true
+}
+
+func (t *TimeCapsuleDigger[P]) heartbeatLocked(ctx context.Context) bool {
+	if !t.membership.heartbeatDue(t.option.Clock.Now()) {
+		return len(t.membership.ownedShards) > 0
+	}
+	if t.membership.memberID == "" {
+		memberID, err := newCapsuleID()
+		if err != nil {
+			t.option.Logger.Errorf("[TimeCapsule] failed to generate the member id of shard ownership: %v", err)
+			return false
+		}
+
+		t.membership.memberID = memberID
+	}
+
+	ownedShards, err := t.membership.coordinator.Heartbeat(ctx, t.membership.memberID, t.membership.ttl)
+	if err != nil {
+		t.option.Logger.Errorf("[TimeCapsule] failed to heartbeat to dataloader %v: %v", t.dataloader.Type(), err)
+		t.membership.left()
+
+		return false
+	}
+	if !slices.Equal(ownedShards, t.membership.ownedShards) {
+		t.option.Logger.Debugf("[TimeCapsule] owned shards of dataloader %v changed to %v", t.dataloader.Type(), ownedShards)
+	}
+
+	t.membership.beat(ownedShards, t.option.Clock.Now())
+
+	return len(ownedShards) > 0
+}
+
+// keepAlive starts renewing the leadership and the membership on their own
+// timer, so that they never lapse while the digger sleeps for a long dig
+// interval or waits for a long running handler. The returned function stops
+// renewing and waits for it.
+func (t *TimeCapsuleDigger[P]) keepAlive() (stop func()) {
+	if t.leadership == nil && t.membership == nil {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(t.diggingCtx)
+	done := make(chan struct{})
+
+	go t.keepingAlive(ctx, done)
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (t *TimeCapsuleDigger[P]) keepingAlive(ctx context.Context, done chan<- struct{}) {
+	defer close(done)
+
+	// the leadership and the membership are renewed once a third of the lease
+	// duration or the TTL has passed, checking twice as often keeps them
+	// renewed before half of it
+	var interval time.Duration
+	if t.leadership != nil {
+		interval = t.leadership.leaseDuration / 6
+	}
+	if t.membership != nil && (interval == 0 || t.membership.ttl/6 < interval) {
+		interval = t.membership.ttl / 6
+	}
+
+	timer := t.option.Clock.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+			if !t.paused.Load() {
+				t.renewLeadership(ctx)
+				t.renewMembership(ctx)
+			}
+
+			timer.Reset(interval)
+		}
+	}
+}
+
+// renewLeadership renews the leadership when it is due while the digger is the
+// leader, acquiring the leadership is left to digging.
+func (t *TimeCapsuleDigger[P]) renewLeadership(ctx context.Context) {
+	if t.leadership == nil {
+		return
+	}
+
+	t.leadership.mutex.Lock()
+	defer t.leadership.mutex.Unlock()
+
+	if !t.leadership.leading || ctx.Err() != nil {
+		return
+	}
+
+	callCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	t.leadLocked(callCtx)
+}
+
+// renewMembership extends the membership when it is due while the digger is a
+// member, joining is left to digging.
+func (t *TimeCapsuleDigger[P]) renewMembership(ctx context.Context) {
+	if t.membership == nil {
+		return
+	}
+
+	t.membership.mutex.Lock()
+	defer t.membership.mutex.Unlock()
+
+	if !t.membership.joined || ctx.Err() != nil {
+		return
+	}
+
+	callCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	t.heartbeatLocked(callCtx)
+}
+
+// leave gives up the membership of the topic, so that the shards owned by the
+// digger can be taken over immediately.
+func (t *TimeCapsuleDigger[P]) leave() {
+	if t.membership == nil {
+		return
+	}
+
+	t.membership.mutex.Lock()
+	defer t.membership.mutex.Unlock()
+
+	if !t.membership.joined {
+		return
 	}
 
-	return dugCapsule
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := t.membership.coordinator.Leave(ctx, t.membership.memberID); err != nil {
+		t.option.Logger.Errorf("[TimeCapsule] failed to leave dataloader %v: %v", t.dataloader.Type(), err)
+	} else {
+		t.option.Logger.Debugf("[TimeCapsule] left dataloader %v", t.dataloader.Type())
+	}
+
+	t.membership.left()
 }
 
 func (t *TimeCapsuleDigger[P]) destroy(capsule *TimeCapsule[P]) {
@@ -137,6 +625,44 @@ func (t *TimeCapsuleDigger[P]) destroy(capsule *TimeCapsule[P]) {
 	}
 }
 
+// deadLetter moves the capsule to the dead letters, the capsule is logged and
+// dropped if the dataloader does not implement DeadLetterer.
+func (t *TimeCapsuleDigger[P]) deadLetter(capsule *TimeCapsule[P], reason error) {
+	deadLetterer, ok := t.dataloader.(DeadLetterer[P])
+	if !ok {
+		t.option.Logger.Errorf("[TimeCapsule] dataloader %v does not support dead letters, dropped time capsule: %v", t.dataloader.Type(), reason)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := deadLetterer.DeadLetter(ctx, capsule, reason); err != nil {
+		t.option.Logger.Errorf("[TimeCapsule] failed to dead letter time capsule: %v", err)
+	} else {
+		t.option.Logger.Warnf("[TimeCapsule] dead lettered a capsule from dataloader %v: %v", t.dataloader.Type(), reason)
+	}
+}
+
+// release hands the capsule back to the dataloader, the capsule is left to the
+// dataloader if it does not implement Releaser.
+func (t *TimeCapsuleDigger[P]) release(capsule *TimeCapsule[P]) {
+	releaser, ok := t.dataloader.(Releaser[P])
+	if !ok {
+		t.option.Logger.Warnf("[TimeCapsule] dataloader %v does not support handing capsules back, left time capsule to it", t.dataloader.Type())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := releaser.Release(ctx, capsule); err != nil {
+		t.option.Logger.Errorf("[TimeCapsule] failed to hand time capsule back: %v", err)
+	} else {
+		t.option.Logger.Debugf("[TimeCapsule] handed a capsule back to dataloader %v", t.dataloader.Type())
+	}
+}
+
 func (t *TimeCapsuleDigger[P]) handle(dugCapsule *TimeCapsule[P]) {
 	if dugCapsule == nil {
 		return
@@ -144,19 +670,626 @@ func (t *TimeCapsuleDigger[P]) handle(dugCapsule *TimeCapsule[P]) {
 
 	t.option.Logger.Debugf("[TimeCapsule] dug a new capsule from dataloader %v", t.dataloader.Type())
 
+	t.trackInFlight(dugCapsule)
+
+	ctx, cancel := t.handlerContext(dugCapsule)
+	defer cancel()
+
+	err := t.invokeHandler(ctx, dugCapsule)
+	if err == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("handler exceeded its deadline: %w", ctx.Err())
+	}
+	if !t.settleInFlight(dugCapsule) {
+		return
+	}
+	if dugCapsule.acked {
+		// destroyed along with the writes of the handler, the failed commands
+		// of the transaction can not be rolled back by retrying
+		if err != nil {
+			t.option.Logger.Errorf("[TimeCapsule] time capsule was acked in a transaction with failed commands: %v", err)
+		}
+
+		return
+	}
+	if err != nil && t.lifecycleCtx.Err() != nil {
+		t.option.Logger.Warnf("[TimeCapsule] digger stopped while handling time capsule: %v", err)
+		t.release(dugCapsule)
+
+		return
+	}
+	if err != nil {
+		t.option.Logger.Errorf("[TimeCapsule] failed to handle time capsule: %v", err)
+		t.fail(dugCapsule, err)
+
+		return
+	}
+
 	t.destroy(dugCapsule)
-	if t.handlerFunc != nil {
-		t.handlerFunc(t, dugCapsule)
+}
+
+func (t *TimeCapsuleDigger[P]) trackInFlight(capsule *TimeCapsule[P]) {
+	t.inFlightMutex.Lock()
+	defer t.inFlightMutex.Unlock()
+
+	t.inFlight[capsule] = false
+}
+
+// settleInFlight removes the capsule from the in-flight capsules, and reports
+// whether the capsule is still owned by the digger, false if it has been handed
+// back to the dataloader by Shutdown.
+func (t *TimeCapsuleDigger[P]) settleInFlight(capsule *TimeCapsule[P]) bool {
+	t.inFlightMutex.Lock()
+	defer t.inFlightMutex.Unlock()
+
+	released := t.inFlight[capsule]
+	delete(t.inFlight, capsule)
+
+	return !released
+}
+
+// releaseInFlight hands the capsules which are still being handled back to the
+// dataloader.
+func (t *TimeCapsuleDigger[P]) releaseInFlight() {
+	t.inFlightMutex.Lock()
+
+	capsules := make([]*TimeCapsule[P], 0, len(t.inFlight))
+
+	for capsule, released := range t.inFlight {
+		if !released {
+			t.inFlight[capsule] = true
+			capsules = append(capsules, capsule)
+		}
+	}
+
+	t.inFlightMutex.Unlock()
+
+	for _, capsule := range capsules {
+		t.release(capsule)
+	}
+}
+
+// handlerContext derives the context of handling the capsule from the lifecycle
+// of the digger, with the deadline of HandlerTimeout or the MetadataKeyTimeout
+// metadata of the capsule.
+func (t *TimeCapsuleDigger[P]) handlerContext(capsule *TimeCapsule[P]) (context.Context, context.CancelFunc) {
+	timeout := t.option.HandlerTimeout
+
+	if capsuleTimeout, ok := capsule.Metadata[MetadataKeyTimeout]; ok {
+		parsedTimeout, err := time.ParseDuration(capsuleTimeout)
+		if err != nil {
+			t.option.Logger.Warnf("[TimeCapsule] invalid timeout %q of capsule, fallback to %v: %v", capsuleTimeout, timeout, err)
+		} else {
+			timeout = parsedTimeout
+		}
+	}
+	if timeout <= 0 {
+		return context.WithCancel(t.lifecycleCtx)
+	}
+
+	return context.WithTimeout(t.lifecycleCtx, timeout)
+}
+
+// invokeHandler invokes the handler wrapped with the middlewares, a panic of
+// the handler will be recovered and returned as error.
+func (t *TimeCapsuleDigger[P]) invokeHandler(ctx context.Context, capsule *TimeCapsule[P]) (err error) {
+	if t.handlerFunc == nil {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.option.Logger.Errorf("[TimeCapsule] recovered from panic in handler: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+
+	return chainMiddlewares(t.handlerFunc, t.middlewares...)(ctx, t, capsule)
+}
+
+// fail deals with the capsule whose handler failed according to the failure
+// policy, the capsules which have no route are always dead lettered.
+func (t *TimeCapsuleDigger[P]) fail(capsule *TimeCapsule[P], reason error) {
+	if errors.Is(reason, ErrNoRoute) {
+		t.deadLetter(capsule, reason)
+		t.destroy(capsule)
+
+		return
+	}
+
+	switch t.option.FailurePolicy {
+	case FailurePolicyRetry:
+		if capsule.Attempts+1 < t.option.RetryLimit {
+			err := t.retry(capsule)
+			if err != nil {
+				t.option.Logger.Errorf("[TimeCapsule] failed to bury time capsule for retrying: %v", err)
+				t.release(capsule)
+
+				return
+			}
+
+			break
+		}
+
+		t.deadLetter(capsule, fmt.Errorf("retry limit %d reached: %w", t.option.RetryLimit, reason))
+	case FailurePolicyDeadLetter:
+		t.deadLetter(capsule, reason)
+	default:
+	}
+
+	t.destroy(capsule)
+}
+
+// retry buries a copy of the capsule for RetryInterval with the attempts
+// increased, the original capsule should be destroyed afterwards.
+func (t *TimeCapsuleDigger[P]) retry(capsule *TimeCapsule[P]) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	retriedCapsule := &TimeCapsule[P]{
+		Version:  capsule.Version,
+		Kind:     capsule.Kind,
+		Metadata: capsule.Metadata,
+		Attempts: capsule.Attempts + 1,
+		Payload:  capsule.Payload,
+	}
+
+	err := t.BuryCapsule(ctx, retriedCapsule, t.option.Clock.Now().UTC().Add(t.option.RetryInterval).UnixMilli())
+	if err != nil {
+		return err
+	}
+
+	t.option.Logger.Debugf("[TimeCapsule] buried a capsule for retrying, attempts: %d", retriedCapsule.Attempts)
+
+	return nil
+}
+
+// addDigger counts the digging goroutine or a DigOnce call for Shutdown to
+// wait for, it returns false if the digger has been shut down.
+func (t *TimeCapsuleDigger[P]) addDigger() bool {
+	t.diggersMutex.Lock()
+	defer t.diggersMutex.Unlock()
+
+	if t.diggingCtx.Err() != nil {
+		return false
+	}
+
+	t.diggers.Add(1)
+
+	return true
+}
+
+func (t *TimeCapsuleDigger[P]) digging() {
+	defer close(t.diggingDone)
+	defer t.diggers.Done()
+	// give the leadership and the owned shards up once the digger stops, so
+	// that the other diggers can take over immediately
+	defer t.resign()
+	defer t.leave()
+
+	stopKeepingAlive := t.keepAlive()
+	defer stopKeepingAlive()
+
+	prefetched := &t.prefetched
+	// hand the prefetched capsules back once the digger stops
+	defer t.releasePrefetched(prefetched)
+
+	t.syncServerClock()
+
+	timer := t.option.Clock.NewTimer(t.digInterval)
+	defer timer.Stop()
+
+	digAt := t.option.Clock.Now().Add(t.digInterval)
+
+	var notifications <-chan int64
+
+	if t.option.MaxIdleInterval > 0 {
+		digAt = t.option.Clock.Now()
+		notifications = t.subscribe()
+	}
+
+	for {
+		timer.Reset(t.untilWake(digAt, prefetched))
+
+		select {
+		case <-t.diggingCtx.Done():
+			return
+		case scheduledAt, ok := <-notifications:
+			if !ok {
+				notifications = nil
+				continue
+			}
+
+			// dig earlier if the buried capsule is due before the next dig
+			notifiedDigAt := t.localTime(scheduledAt).Add(-t.option.PrefetchWindow)
+			if notifiedDigAt.Before(digAt) {
+				digAt = notifiedDigAt
+			}
+		case <-timer.C():
+			if t.paused.Load() {
+				t.releasePrefetched(prefetched)
+			}
+
+			t.handlePrefetched(prefetched)
+
+			if t.option.Clock.Now().Before(digAt) {
+				continue
+			}
+
+			dugAt := t.option.Clock.Now()
+
+			dugCapsule, err := t.dig()
+			if dugCapsule != nil && dugCapsule.DugOutAt > 0 {
+				t.observeServerClock(dugCapsule.DugOutAt)
+			}
+			if err != nil {
+				if errors.Is(err, ErrFatal) {
+					t.option.Logger.Errorf("[TimeCapsule] stopped digging time capsules from dataloader %v: %v", t.dataloader.Type(), err)
+					t.diggingErr = err
+
+					return
+				}
+
+				t.option.Logger.Errorf("[TimeCapsule] failed to dig time capsule from dataloader %v: %v", t.dataloader.Type(), err)
+			}
+
+			if dugCapsule != nil && !t.due(dugCapsule) {
+				t.prefetch(prefetched, dugCapsule)
+			} else {
+				t.handle(dugCapsule)
+			}
+
+			digAt = t.option.Clock.Now().Add(t.nextDigInterval(dugCapsule != nil, dugAt))
+		}
+	}
+}
+
+// due reports whether the dug out capsule is due, by the time it was dug out
+// at if the dataloader tells, which follows the clock of Redis with
+// DataloaderOption.ServerTime, or by serverNow otherwise.
+func (t *TimeCapsuleDigger[P]) due(capsule *TimeCapsule[P]) bool {
+	now := capsule.DugOutAt
+	if now <= 0 {
+		now = t.serverNow()
+	}
+
+	return capsule.ScheduledAt <= now
+}
+
+// untilWake returns how long the digging goroutine should sleep until either
+// digging again or handling the earliest prefetched capsule.
+func (t *TimeCapsuleDigger[P]) untilWake(digAt time.Time, prefetched *prefetchBuffer[P]) time.Duration {
+	wakeAt := digAt
+
+	capsule, ok := prefetched.next()
+	if ok && t.localTime(capsule.ScheduledAt).Before(wakeAt) {
+		wakeAt = t.localTime(capsule.ScheduledAt)
+	}
+
+	return max(wakeAt.Sub(t.option.Clock.Now()), 0)
+}
+
+// prefetch keeps the capsule which is not due yet to be handled once it is
+// due, the capsule will be handed back if its lease expires before it is due.
+func (t *TimeCapsuleDigger[P]) prefetch(prefetched *prefetchBuffer[P], capsule *TimeCapsule[P]) {
+	if capsule.leaseDeadline > 0 && capsule.ScheduledAt >= capsule.leaseDeadline {
+		t.option.Logger.Warnf("[TimeCapsule] lease of prefetched capsule expires before it is due, handing it back to dataloader %v", t.dataloader.Type())
+		t.release(capsule)
+
+		return
+	}
+
+	prefetched.push(capsule)
+}
+
+// handlePrefetched handles the prefetched capsules which are due. The capsules
+// whose lease has expired are skipped since they may have been dug out again.
+func (t *TimeCapsuleDigger[P]) handlePrefetched(prefetched *prefetchBuffer[P]) {
+	for {
+		capsule, ok := prefetched.popDue(t.serverNow())
+		if !ok {
+			return
+		}
+		if capsule.leaseDeadline > 0 && t.serverNow() >= capsule.leaseDeadline {
+			t.option.Logger.Warnf("[TimeCapsule] lease of prefetched capsule expired, skipped handling it")
+			continue
+		}
+
+		t.handle(capsule)
+	}
+}
+
+// syncServerClock measures the skew of the clock the dataloader compares the
+// scores against from the clock of the digger, if the dataloader implements
+// ServerClock. The last known skew is kept if it fails.
+func (t *TimeCapsuleDigger[P]) syncServerClock() {
+	serverClock, ok := t.dataloader.(ServerClock)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	serverNow, err := serverClock.ServerNow(ctx)
+	if err != nil {
+		t.option.Logger.Warnf("[TimeCapsule] failed to get the time of dataloader %v, kept the last known skew: %v", t.dataloader.Type(), err)
+		return
+	}
+
+	t.observeServerClock(serverNow)
+}
+
+// observeServerClock records the skew by the time the dataloader compares the
+// scores against, which was read by a request answered now. The time is taken
+// as read once answered, rounded up to the millisecond, so that the capsules
+// are never due earlier by the digger than by the dataloader.
+func (t *TimeCapsuleDigger[P]) observeServerClock(serverNow int64) {
+	if _, ok := t.dataloader.(ServerClock); !ok {
+		return
+	}
+
+	readAt := (t.option.Clock.Now().UnixMicro() + 999) / 1000
+	t.serverClockSkew.Store(serverNow - readAt)
+}
+
+// serverNow returns the unix milli timestamp the dataloader compares the scores
+// against now, by the clock of the digger and the last known skew.
+func (t *TimeCapsuleDigger[P]) serverNow() int64 {
+	return t.option.Clock.Now().UnixMilli() + t.serverClockSkew.Load()
+}
+
+// localTime returns the time by the clock of the digger when the dataloader
+// reaches the given unix milli timestamp, such as the score of a capsule.
+func (t *TimeCapsuleDigger[P]) localTime(unixMilliTimestamp int64) time.Time {
+	return time.UnixMilli(unixMilliTimestamp - t.serverClockSkew.Load())
+}
+
+// releasePrefetched hands the prefetched capsules back to the dataloader.
+func (t *TimeCapsuleDigger[P]) releasePrefetched(prefetched *prefetchBuffer[P]) {
+	for _, capsule := range prefetched.drain() {
+		t.release(capsule)
+	}
+}
+
+// subscribe subscribes to the notifications of buried capsules, nil will be
+// returned if failed or if the dataloader does not implement Subscriber, the
+// digger will then only wake up by polling.
+func (t *TimeCapsuleDigger[P]) subscribe() <-chan int64 {
+	subscriber, ok := t.dataloader.(Subscriber)
+	if !ok {
+		return nil
+	}
+
+	notifications, err := subscriber.Subscribe(t.diggingCtx)
+	if err != nil {
+		t.option.Logger.Warnf("[TimeCapsule] failed to subscribe to buried capsules of dataloader %v, fallback to polling: %v", t.dataloader.Type(), err)
+		return nil
+	}
+
+	return notifications
+}
+
+// nextDigInterval returns how long to wait before digging again. Without
+// adaptive polling it is always the dig interval, otherwise the digger digs
+// again immediately if a capsule was dug out, or sleeps until the earliest
+// capsule is due or within PrefetchWindow, capped by MaxIdleInterval. dugAt is
+// the time of the last dig.
+func (t *TimeCapsuleDigger[P]) nextDigInterval(dugOut bool, dugAt time.Time) time.Duration {
+	if t.option.MaxIdleInterval <= 0 {
+		return t.digInterval
+	}
+	if dugOut {
+		return 0
+	}
+
+	interval := t.idleInterval(dugAt)
+
+	return max(interval, 0)
+}
+
+// idleInterval returns how long to sleep after nothing was dug out. The diggers
+// which are not the leader try to acquire the leadership once per dig interval,
+// and the diggers which own no shards heartbeat once per dig interval. The dig
+// interval is used as well if the dataloader does not implement SchedulePeeker.
+func (t *TimeCapsuleDigger[P]) idleInterval(dugAt time.Time) time.Duration {
+	schedulePeeker, ok := t.dataloader.(SchedulePeeker)
+	if !ok || t.paused.Load() || t.diggingCtx.Err() != nil {
+		return t.digInterval
+	}
+	if t.leadership != nil && !t.leadership.isLeading() {
+		return t.digInterval
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if t.membership != nil {
+		ownedShards := t.membership.owned()
+		if len(ownedShards) == 0 {
+			return t.digInterval
+		}
+
+		ctx = withShards(ctx, ownedShards)
+	}
+
+	scheduledAt, ok, err := schedulePeeker.NextScheduledAt(ctx)
+	if err != nil {
+		t.option.Logger.Errorf("[TimeCapsule] failed to get the next scheduled time of dataloader %v: %v", t.dataloader.Type(), err)
+		return t.digInterval
+	}
+	if !ok {
+		return t.option.MaxIdleInterval
+	}
+
+	// the capsule is due by the clock the dataloader compares the scores
+	// against, which may be skewed from the clock of the digger
+	t.syncServerClock()
+
+	digAt := t.localTime(scheduledAt).Add(-t.option.PrefetchWindow)
+	if !digAt.After(dugAt) {
+		// due by the last dig but not dug out, the topic may be paused
+		return t.digInterval
 	}
+
+	// the capsule may become due while digging, dig again immediately then
+	return min(digAt.Sub(t.option.Clock.Now()), t.option.MaxIdleInterval)
 }
 
 // Start starts the digger, which will keep polling the time capsule for new messages once the interval ticks.
 func (t *TimeCapsuleDigger[P]) Start() {
-	t.puller.StartPull(context.Background())
+	if t.started.Swap(true) {
+		return
+	}
+	if !t.addDigger() {
+		close(t.diggingDone)
+		return
+	}
+
+	go t.digging()
 }
 
-// Stop stops the digger.
+// Run runs the digger and blocks until ctx is cancelled or the dataloader
+// returns an error wrapping ErrFatal. Once ctx is cancelled, the contexts of the
+// handlers which are still running will be cancelled as well, and Run returns
+// nil after they return. The fatal error will be returned otherwise.
+//
+// Run can be used with supervisors such as errgroup:
+//
+//	group.Go(func() error {
+//		return digger.Run(ctx)
+//	})
+func (t *TimeCapsuleDigger[P]) Run(ctx context.Context) error {
+	if t.started.Swap(true) {
+		return errors.New("digger already started")
+	}
+	if !t.addDigger() {
+		close(t.diggingDone)
+		return nil
+	}
+
+	go t.digging()
+
+	select {
+	case <-ctx.Done():
+		t.lifecycleCancel()
+		<-t.diggingDone
+
+		return nil
+	case <-t.diggingDone:
+		return t.diggingErr
+	}
+}
+
+// DigOnce digs and handles the capsules which are due one by one, synchronously
+// without Start, and returns the number of the handled capsules once nothing is
+// due, such as for tests or for the runs triggered by cron. The capsules buried
+// due while digging, such as the retried ones, are handled as well. A
+// prefetched capsule which is not due yet is handed back. Once ctx is cancelled
+// no more capsules are dug and ctx.Err() is returned. The capsules moved to
+// quarantine are logged and skipped, the other errors of the dataloader are
+// returned along with the number of the capsules handled before them.
+//
+// The leadership and the owned shards acquired while digging are given up once
+// DigOnce returns. It can not be called while the digger is started, and
+// Shutdown waits for it to return as well.
+func (t *TimeCapsuleDigger[P]) DigOnce(ctx context.Context) (int, error) {
+	if t.started.Swap(true) {
+		return 0, errors.New("digger already started")
+	}
+
+	defer t.started.Store(false)
+
+	if !t.addDigger() {
+		return 0, nil
+	}
+
+	defer t.diggers.Done()
+	defer t.resign()
+	defer t.leave()
+
+	stopKeepingAlive := t.keepAlive()
+	defer stopKeepingAlive()
+
+	handled := 0
+
+	for {
+		if ctx.Err() != nil {
+			return handled, ctx.Err()
+		}
+
+		dugCapsule, err := t.dig()
+		if errors.Is(err, ErrQuarantined) {
+			t.option.Logger.Errorf("[TimeCapsule] failed to dig time capsule from dataloader %v: %v", t.dataloader.Type(), err)
+			continue
+		}
+		if err != nil {
+			return handled, err
+		}
+		if dugCapsule == nil {
+			return handled, nil
+		}
+		if !t.due(dugCapsule) {
+			t.release(dugCapsule)
+			return handled, nil
+		}
+
+		t.handle(dugCapsule)
+		handled++
+	}
+}
+
+// Pause pauses digging new capsules until Resume is called, the capsules being
+// handled will still be handled, and burying capsules keeps working.
+func (t *TimeCapsuleDigger[P]) Pause() {
+	if !t.paused.Swap(true) {
+		t.option.Logger.Warnf("[TimeCapsule] paused digging time capsules from dataloader %v", t.dataloader.Type())
+	}
+}
+
+// Resume resumes digging new capsules after Pause is called.
+func (t *TimeCapsuleDigger[P]) Resume() {
+	if t.paused.Swap(false) {
+		t.option.Logger.Warnf("[TimeCapsule] resumed digging time capsules from dataloader %v", t.dataloader.Type())
+	}
+}
+
+// Paused reports whether digging is paused.
+func (t *TimeCapsuleDigger[P]) Paused() bool {
+	return t.paused.Load()
+}
+
+// Stop stops digging new capsules and blocks until the capsules being handled,
+// by the digging goroutine and by DigOnce, are handled. Use Shutdown to bound
+// the wait with a context instead.
 func (t *TimeCapsuleDigger[P]) Stop() {
-	t.diggingTicker.Stop()
-	_ = t.puller.StopPull(context.Background())
+	_ = t.Shutdown(context.Background())
+}
+
+// Shutdown stops digging new capsules and waits for the capsules being handled,
+// by the digging goroutine and by DigOnce, until ctx is done. The capsules which
+// are still being handled or prefetched by then will be handed back to the
+// dataloader, and ctx.Err() will be returned.
+func (t *TimeCapsuleDigger[P]) Shutdown(ctx context.Context) error {
+	t.diggersMutex.Lock()
+	t.diggingCancel()
+	t.diggersMutex.Unlock()
+
+	defer t.lifecycleCancel()
+
+	diggersDone := make(chan struct{})
+
+	go func() {
+		defer close(diggersDone)
+		t.diggers.Wait()
+	}()
+
+	select {
+	case <-diggersDone:
+		return nil
+	case <-ctx.Done():
+		t.releaseInFlight()
+		t.releasePrefetched(&t.prefetched)
+
+		return ctx.Err()
+	}
 }
@@ -1,12 +1,19 @@
 package timecapsule
 
 import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
 	"os"
+	"slices"
 	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/rueidis"
 	"github.com/samber/lo"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -16,14 +23,15 @@ import (
 
 var dataloders map[string]Dataloader[any]
 
-func TestMain(m *testing.M) {
-	lo.Must0(redisv5Client.Ping(context.Background()).Err())
-	lo.Must0(redisv6Client.Ping(context.Background()).Err())
-	lo.Must0(redisv7Client.Ping(context.Background()).Err())
+// skipRedisTestsEnv is the environment variable which skips the tests against
+// Redis once set, so that only the memory dataloader is tested.
+const skipRedisTestsEnv = "TIMECAPSULE_SKIP_REDIS_TESTS"
 
-	lo.Must0(rueidisv5Client.Do(context.Background(), rueidisv5Client.B().Ping().Build()).Error())
-	lo.Must0(rueidisv6Client.Do(context.Background(), rueidisv6Client.B().Ping().Build()).Error())
-	lo.Must0(rueidisv7Client.Do(context.Background(), rueidisv7Client.B().Ping().Build()).Error())
+func TestMain(m *testing.M) {
+	if os.Getenv(skipRedisTestsEnv) == "" {
+		setupRedisDataloaders()
+		setupRueidisDataloaders()
+	}
 
 	redisDataloadersSlice := lo.Map(lo.MapToSlice(redisDataloaders, func(k string, v *RedisDataloader[any]) lo.Entry[string, *RedisDataloader[any]] {
 		return lo.Entry[string, *RedisDataloader[any]]{
@@ -53,6 +61,7 @@ func TestMain(m *testing.M) {
 	dataloders = lo.SliceToMap(dataloaderSlices, func(item lo.Entry[string, Dataloader[any]]) (string, Dataloader[any]) {
 		return item.Key, item.Value
 	})
+	dataloders["Memory"] = NewMemoryDataloader[any]()
 
 	os.Exit(m.Run())
 }
@@ -60,17 +69,168 @@ func TestMain(m *testing.M) {
 func cleanupKey(t *testing.T, dataloder Dataloader[any]) {
 	redisDataloader, ok := dataloder.(*RedisDataloader[any])
 	if ok {
-		err := redisDataloader.redisClient.Del(context.Background(), redisDataloader.sortedSetKey).Err()
+		err := redisDataloader.redisClient.Del(
+			context.Background(),
+			redisDataloader.sortedSetKey,
+			redisDataloader.payloadsKey(),
+			redisDataloader.inFlightKey(),
+			redisDataloader.quarantineKey(),
+			redisDataloader.deadLettersKey(),
+			redisDataloader.pausedKey(),
+			redisDataloader.leaderKey(),
+			redisDataloader.fencingTokenKey(),
+			redisDataloader.membersKey(),
+		).Err()
 		assert.NoError(t, err)
 	}
 
 	rueidisDataloader, ok := dataloder.(*RueidisDataloader[any])
 	if ok {
-		err := rueidisDataloader.rueidisClient.Do(context.Background(), rueidisDataloader.rueidisClient.B().Del().Key(rueidisDataloader.sortedSetKey).Build()).Error()
+		delCmd := rueidisDataloader.rueidisClient.
+			B().
+			Del().
+			Key(
+				rueidisDataloader.sortedSetKey,
+				rueidisDataloader.payloadsKey(),
+				rueidisDataloader.inFlightKey(),
+				rueidisDataloader.quarantineKey(),
+				rueidisDataloader.deadLettersKey(),
+				rueidisDataloader.pausedKey(),
+				rueidisDataloader.leaderKey(),
+				rueidisDataloader.fencingTokenKey(),
+				rueidisDataloader.membersKey(),
+			).
+			Build()
+
+		err := rueidisDataloader.rueidisClient.Do(context.Background(), delCmd).Error()
+		assert.NoError(t, err)
+	}
+
+	memoryDataloader, ok := dataloder.(*MemoryDataloader[any])
+	if ok {
+		err := memoryDataloader.DestroyAll(context.Background())
 		assert.NoError(t, err)
+
+		memoryDataloader.mutex.Lock()
+		defer memoryDataloader.mutex.Unlock()
+
+		memoryDataloader.quarantine = make(map[string]string)
+		memoryDataloader.deadLetters = make(map[string]string)
+		memoryDataloader.paused = false
+		memoryDataloader.leaderHolderID = ""
+		memoryDataloader.leaderFencingToken = 0
+		memoryDataloader.leaderLeaseDeadline = time.Time{}
+		memoryDataloader.members = make(map[string]time.Time)
+	}
+}
+
+// shardedDataloader returns a dataloader of a new topic with the given number of
+// shards, backed by the same client as the given dataloader.
+func shardedDataloader(t *testing.T, dataloder Dataloader[any], shards int) Dataloader[any] {
+	randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+	require.NoError(t, err)
+
+	sortedSetKey := fmt.Sprintf("test/timecapsule/zset/sharded/%d", randomSeed.Int64())
+
+	switch d := dataloder.(type) {
+	case *RedisDataloader[any]:
+		return NewRedisDataloader[any](sortedSetKey, d.redisClient, DataloaderOption{Shards: shards})
+	case *RueidisDataloader[any]:
+		return NewRueidisDataloader[any](sortedSetKey, d.rueidisClient, DataloaderOption{Shards: shards})
+	case *MemoryDataloader[any]:
+		return NewMemoryDataloader[any](DataloaderOption{Shards: shards})
+	default:
+		require.FailNow(t, "unexpected dataloader", d.Type())
+		return nil
+	}
+}
+
+// clockedDataloader returns a dataloader of a new topic which tells the time by
+// the clock of the given option, backed by the same client as the given
+// dataloader.
+func clockedDataloader(t *testing.T, dataloder Dataloader[any], option DataloaderOption) Dataloader[any] {
+	randomSeed, err := rand.Int(rand.Reader, big.NewInt(100000))
+	require.NoError(t, err)
+
+	sortedSetKey := fmt.Sprintf("test/timecapsule/zset/clocked/%d", randomSeed.Int64())
+
+	switch d := dataloder.(type) {
+	case *RedisDataloader[any]:
+		return NewRedisDataloader[any](sortedSetKey, d.redisClient, option)
+	case *RueidisDataloader[any]:
+		return NewRueidisDataloader[any](sortedSetKey, d.rueidisClient, option)
+	case *MemoryDataloader[any]:
+		return NewMemoryDataloader[any](option)
+	default:
+		require.FailNow(t, "unexpected dataloader", d.Type())
+		return nil
+	}
+}
+
+// skewedClock is the real clock skewed by the offset.
+type skewedClock struct {
+	offset time.Duration
+}
+
+func (c skewedClock) Now() time.Time {
+	return time.Now().Add(c.offset)
+}
+
+func (c skewedClock) NewTimer(d time.Duration) Timer {
+	return RealClock().NewTimer(d)
+}
+
+func listDeadLetters(t *testing.T, dataloader Dataloader[any]) []*DeadLetteredCapsule {
+	deadLetters, ok := dataloader.(interface {
+		DeadLetters(ctx context.Context) ([]*DeadLetteredCapsule, error)
+	})
+	require.True(t, ok)
+
+	deadLetteredCapsules, err := deadLetters.DeadLetters(context.Background())
+	require.NoError(t, err)
+
+	return deadLetteredCapsules
+}
+
+// buryNotACapsule buries a member which can not be decoded as a capsule into
+// the topic of the given dataloader.
+func buryNotACapsule(t *testing.T, dataloder Dataloader[any], scheduledAt int64) {
+	switch d := dataloder.(type) {
+	case *RedisDataloader[any]:
+		err := d.redisClient.ZAdd(context.Background(), d.sortedSetKey, redis.Z{Score: float64(scheduledAt), Member: "not-a-capsule"}).Err()
+		require.NoError(t, err)
+	case *RueidisDataloader[any]:
+		err := d.rueidisClient.Do(context.Background(), d.rueidisClient.B().Zadd().Key(d.sortedSetKey).ScoreMember().ScoreMember(float64(scheduledAt), "not-a-capsule").Build()).Error()
+		require.NoError(t, err)
+	case *MemoryDataloader[any]:
+		d.bury("not-a-capsule", scheduledAt)
+	default:
+		require.FailNow(t, "unexpected dataloader", d.Type())
 	}
 }
 
+type failingDataloader struct {
+	Dataloader[any]
+}
+
+func (f *failingDataloader) Dig(ctx context.Context) (*TimeCapsule[any], error) {
+	return nil, errors.New("connection refused")
+}
+
+// baselineDataloader exposes only the methods of Dataloader, hiding the
+// optional interfaces implemented by the wrapped dataloader.
+type baselineDataloader struct {
+	Dataloader[any]
+}
+
+type fatalDataloader struct {
+	Dataloader[any]
+}
+
+func (f *fatalDataloader) Dig(ctx context.Context) (*TimeCapsule[any], error) {
+	return nil, fmt.Errorf("%w: client closed", ErrFatal)
+}
+
 func TestTimeCapsule(t *testing.T) {
 	for k, d := range dataloders {
 		d := d
@@ -130,6 +290,1292 @@ func TestTimeCapsule(t *testing.T) {
 				assert.True(handlerProceeded)
 			})
 
+			t.Run("FailurePolicy", func(t *testing.T) {
+				newPanickingDigger := func(policy FailurePolicy) *TimeCapsuleDigger[any] {
+					digger := NewDigger(d, time.Second, TimeCapsuleDiggerOption{
+						RetryLimit:    2,
+						RetryInterval: time.Millisecond,
+						FailurePolicy: policy,
+					})
+
+					digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+						panic("boom")
+					})
+
+					return digger
+				}
+
+				digOut := func(t *testing.T) *TimeCapsule[any] {
+					capsule, err := d.Dig(context.Background())
+					require.NoError(t, err)
+
+					return capsule
+				}
+
+				t.Run("Drop", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := newPanickingDigger(FailurePolicyDrop)
+
+					err := d.BuryFor(context.Background(), "hello", -time.Millisecond)
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					capsule := digOut(t)
+					require.NotNil(capsule)
+
+					assert.NotPanics(func() {
+						digger.handle(capsule)
+					})
+
+					time.Sleep(5 * time.Millisecond)
+					assert.Nil(digOut(t))
+					assert.Empty(listDeadLetters(t, d))
+				})
+
+				t.Run("Retry", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := newPanickingDigger(FailurePolicyRetry)
+
+					err := d.BuryFor(context.Background(), "hello", -time.Millisecond)
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					capsule := digOut(t)
+					require.NotNil(capsule)
+
+					digger.handle(capsule)
+					time.Sleep(5 * time.Millisecond)
+
+					retriedCapsule := digOut(t)
+					require.NotNil(retriedCapsule)
+					assert.Equal("hello", retriedCapsule.Payload)
+					assert.Equal(1, retriedCapsule.Attempts)
+					assert.Empty(listDeadLetters(t, d))
+
+					digger.handle(retriedCapsule)
+					time.Sleep(5 * time.Millisecond)
+
+					assert.Nil(digOut(t))
+
+					deadLetteredCapsules := listDeadLetters(t, d)
+					require.Len(deadLetteredCapsules, 1)
+					assert.Contains(deadLetteredCapsules[0].Reason, "boom")
+				})
+
+				t.Run("DeadLetter", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := newPanickingDigger(FailurePolicyDeadLetter)
+
+					err := d.BuryFor(context.Background(), "hello", -time.Millisecond)
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					capsule := digOut(t)
+					require.NotNil(capsule)
+
+					digger.handle(capsule)
+
+					deadLetteredCapsules := listDeadLetters(t, d)
+					require.Len(deadLetteredCapsules, 1)
+					assert.Equal(capsule.Base64String(), deadLetteredCapsules[0].Content)
+					assert.Contains(deadLetteredCapsules[0].Reason, "boom")
+				})
+			})
+
+			t.Run("HandlerContext", func(t *testing.T) {
+				newBlockingDigger := func(handlerTimeout time.Duration, handlerErr *error) *TimeCapsuleDigger[any] {
+					digger := NewDigger(d, time.Second, TimeCapsuleDiggerOption{
+						HandlerTimeout: handlerTimeout,
+						FailurePolicy:  FailurePolicyDeadLetter,
+					})
+
+					digger.SetHandlerFunc(func(ctx context.Context, digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) error {
+						<-ctx.Done()
+						*handlerErr = ctx.Err()
+
+						return nil
+					})
+
+					return digger
+				}
+
+				t.Run("HandlerTimeout", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					var handlerErr error
+
+					digger := newBlockingDigger(10*time.Millisecond, &handlerErr)
+					defer cleanupKey(t, d)
+
+					capsule := NewTimeCapsule[any]("hello")
+					digger.handle(capsule)
+
+					assert.ErrorIs(handlerErr, context.DeadlineExceeded)
+
+					deadLetteredCapsules := listDeadLetters(t, d)
+					require.Len(deadLetteredCapsules, 1)
+					assert.Contains(deadLetteredCapsules[0].Reason, "deadline")
+				})
+
+				t.Run("CapsuleTimeout", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					var handlerErr error
+
+					digger := newBlockingDigger(time.Hour, &handlerErr)
+					defer cleanupKey(t, d)
+
+					capsule := NewTimeCapsule[any]("hello")
+					capsule.Metadata = map[string]string{MetadataKeyTimeout: "10ms"}
+
+					start := time.Now()
+					digger.handle(capsule)
+
+					assert.ErrorIs(handlerErr, context.DeadlineExceeded)
+					assert.Less(time.Since(start), time.Second)
+					require.Len(listDeadLetters(t, d), 1)
+				})
+
+				t.Run("Stop", func(t *testing.T) {
+					assert := assert.New(t)
+
+					var handlerErr error
+
+					digger := newBlockingDigger(0, &handlerErr)
+					defer cleanupKey(t, d)
+
+					digger.Stop()
+					digger.handle(NewTimeCapsule[any]("hello"))
+
+					assert.ErrorIs(handlerErr, context.Canceled)
+				})
+			})
+
+			t.Run("Shutdown", func(t *testing.T) {
+				t.Run("DrainInFlight", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger(d, 5*time.Millisecond)
+					require.NotNil(digger)
+
+					handlerStarted := make(chan struct{})
+
+					var handlerProceeded bool
+
+					digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+						close(handlerStarted)
+						time.Sleep(50 * time.Millisecond)
+
+						handlerProceeded = true
+					})
+
+					err := d.BuryFor(context.Background(), "hello", -time.Millisecond)
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					digger.Start()
+					<-handlerStarted
+
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+
+					err = digger.Shutdown(ctx)
+					require.NoError(err)
+					assert.True(handlerProceeded)
+
+					capsule, err := d.Dig(context.Background())
+					require.NoError(err)
+					assert.Nil(capsule)
+				})
+
+				t.Run("HandBackUnfinished", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger(d, 5*time.Millisecond)
+					require.NotNil(digger)
+
+					handlerStarted := make(chan struct{})
+
+					digger.SetHandlerFunc(func(ctx context.Context, digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) error {
+						close(handlerStarted)
+						<-ctx.Done()
+
+						return ctx.Err()
+					})
+
+					err := d.BuryFor(context.Background(), "hello", -time.Millisecond)
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					digger.Start()
+					<-handlerStarted
+
+					ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+					defer cancel()
+
+					err = digger.Shutdown(ctx)
+					require.ErrorIs(err, context.DeadlineExceeded)
+
+					<-digger.diggingDone
+
+					capsule, err := d.Dig(context.Background())
+					require.NoError(err)
+					require.NotNil(capsule)
+					assert.Equal("hello", capsule.Payload)
+				})
+
+				t.Run("DrainDigOnce", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger(d, time.Hour)
+					require.NotNil(digger)
+
+					handlerStarted := make(chan struct{})
+
+					var handlerProceeded bool
+
+					digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+						close(handlerStarted)
+						time.Sleep(50 * time.Millisecond)
+
+						handlerProceeded = true
+					})
+
+					for i := 0; i < 2; i++ {
+						err := d.BuryFor(context.Background(), "hello"+strconv.Itoa(i), -time.Millisecond)
+						require.NoError(err)
+					}
+
+					defer cleanupKey(t, d)
+
+					type digOnceResult struct {
+						handled int
+						err     error
+					}
+
+					digOnceDone := make(chan digOnceResult, 1)
+
+					go func() {
+						handled, err := digger.DigOnce(context.Background())
+						digOnceDone <- digOnceResult{handled: handled, err: err}
+					}()
+
+					<-handlerStarted
+
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+
+					err := digger.Shutdown(ctx)
+					require.NoError(err)
+					assert.True(handlerProceeded)
+
+					// no more capsules are dug out once shut down
+					result := <-digOnceDone
+					require.NoError(result.err)
+					assert.Equal(1, result.handled)
+
+					capsule, err := d.Dig(context.Background())
+					require.NoError(err)
+					require.NotNil(capsule)
+					assert.Equal("hello1", capsule.Payload)
+				})
+			})
+
+			t.Run("Run", func(t *testing.T) {
+				t.Run("UntilCancelled", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger(d, 5*time.Millisecond)
+					require.NotNil(digger)
+
+					handled := make(chan string, 1)
+
+					digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+						payload, _ := capsule.Payload.(string)
+						handled <- payload
+					})
+
+					err := d.BuryFor(context.Background(), "hello", -time.Millisecond)
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					ctx, cancel := context.WithCancel(context.Background())
+					runErr := make(chan error, 1)
+
+					go func() {
+						runErr <- digger.Run(ctx)
+					}()
+
+					assert.Equal("hello", <-handled)
+
+					cancel()
+					require.NoError(<-runErr)
+
+					err = digger.Run(context.Background())
+					assert.Error(err)
+				})
+
+				t.Run("FatalError", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger[any](&fatalDataloader{Dataloader: d}, 5*time.Millisecond)
+					require.NotNil(digger)
+
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+
+					err := digger.Run(ctx)
+					require.ErrorIs(err, ErrFatal)
+					assert.NoError(ctx.Err())
+				})
+			})
+
+			t.Run("AdaptivePolling", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				digger := NewDigger(d, time.Hour, TimeCapsuleDiggerOption{MaxIdleInterval: 10 * time.Second})
+				require.NotNil(digger)
+
+				handled := make(chan string, 4)
+
+				digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+					payload, _ := capsule.Payload.(string)
+					handled <- payload
+				})
+
+				for i := 0; i < 3; i++ {
+					err := digger.BuryFor(context.Background(), "due"+strconv.Itoa(i), -time.Millisecond)
+					require.NoError(err)
+				}
+
+				err := digger.BuryFor(context.Background(), "later", 200*time.Millisecond)
+				require.NoError(err)
+
+				defer cleanupKey(t, d)
+
+				startedAt := time.Now()
+
+				digger.Start()
+				defer digger.Stop()
+
+				payloads := make([]string, 0, 4)
+
+				for i := 0; i < 4; i++ {
+					select {
+					case payload := <-handled:
+						payloads = append(payloads, payload)
+					case <-time.After(5 * time.Second):
+						require.Fail("capsules should be dug out without waiting for the dig interval")
+					}
+				}
+
+				assert.ElementsMatch([]string{"due0", "due1", "due2"}, payloads[:3])
+				assert.Equal("later", payloads[3])
+				assert.GreaterOrEqual(time.Since(startedAt), 150*time.Millisecond)
+			})
+
+			t.Run("WakeUp", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				digger := NewDigger(d, time.Hour, TimeCapsuleDiggerOption{MaxIdleInterval: time.Hour})
+				require.NotNil(digger)
+
+				handled := make(chan string, 1)
+
+				digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+					payload, _ := capsule.Payload.(string)
+					handled <- payload
+				})
+
+				digger.Start()
+				defer digger.Stop()
+
+				// wait for the digger to sleep for MaxIdleInterval
+				time.Sleep(50 * time.Millisecond)
+
+				err := d.BuryFor(context.Background(), "hello", 100*time.Millisecond)
+				require.NoError(err)
+
+				defer cleanupKey(t, d)
+
+				select {
+				case payload := <-handled:
+					assert.Equal("hello", payload)
+				case <-time.After(5 * time.Second):
+					assert.Fail("digger should be woken up once an earlier capsule is buried")
+				}
+			})
+
+			t.Run("Prefetch", func(t *testing.T) {
+				t.Run("HandleOnTime", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger(d, 10*time.Millisecond, TimeCapsuleDiggerOption{PrefetchWindow: time.Second})
+					require.NotNil(digger)
+
+					handledAt := make(chan int64, 1)
+
+					digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+						handledAt <- time.Now().UnixMilli()
+					})
+
+					scheduledAt := time.Now().Add(200 * time.Millisecond).UnixMilli()
+
+					err := digger.BuryUtil(context.Background(), "hello", scheduledAt)
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					digger.Start()
+					defer digger.Stop()
+
+					// the capsule should be leased before it is due
+					time.Sleep(100 * time.Millisecond)
+
+					_, ok, err := d.(SchedulePeeker).NextScheduledAt(context.Background())
+					require.NoError(err)
+					assert.False(ok)
+
+					select {
+					case at := <-handledAt:
+						assert.GreaterOrEqual(at, scheduledAt)
+						assert.Less(at, scheduledAt+50)
+					case <-time.After(5 * time.Second):
+						assert.Fail("prefetched capsule should be handled once it is due")
+					}
+				})
+
+				t.Run("HandBackOnShutdown", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger(d, 10*time.Millisecond, TimeCapsuleDiggerOption{PrefetchWindow: time.Minute})
+					require.NotNil(digger)
+
+					digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+						assert.Fail("capsule should not be handled before it is due")
+					})
+
+					scheduledAt := time.Now().Add(30 * time.Second).UnixMilli()
+
+					err := digger.BuryUtil(context.Background(), "hello", scheduledAt)
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					digger.Start()
+					time.Sleep(100 * time.Millisecond)
+
+					_, ok, err := d.(SchedulePeeker).NextScheduledAt(context.Background())
+					require.NoError(err)
+					assert.False(ok)
+
+					digger.Stop()
+
+					nextScheduledAt, ok, err := d.(SchedulePeeker).NextScheduledAt(context.Background())
+					require.NoError(err)
+					assert.True(ok)
+					assert.Equal(scheduledAt, nextScheduledAt)
+				})
+
+				t.Run("HandBackOnShutdownTimeout", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger(d, 10*time.Millisecond, TimeCapsuleDiggerOption{PrefetchWindow: time.Minute})
+					require.NotNil(digger)
+
+					handlerStarted := make(chan struct{})
+					handlerBlocked := make(chan struct{})
+
+					digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+						if !assert.Equal("blocking", capsule.Payload) {
+							return
+						}
+
+						close(handlerStarted)
+						<-handlerBlocked
+					})
+
+					scheduledAt := time.Now().Add(30 * time.Second).UnixMilli()
+
+					err := digger.BuryUtil(context.Background(), "prefetched", scheduledAt)
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					digger.Start()
+
+					defer func() {
+						close(handlerBlocked)
+						<-digger.diggingDone
+					}()
+
+					require.Eventually(func() bool {
+						_, ok, err := d.(SchedulePeeker).NextScheduledAt(context.Background())
+						return err == nil && !ok
+					}, 5*time.Second, 10*time.Millisecond)
+
+					err = digger.BuryFor(context.Background(), "blocking", -time.Millisecond)
+					require.NoError(err)
+
+					<-handlerStarted
+
+					ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+					defer cancel()
+
+					err = digger.Shutdown(ctx)
+					require.ErrorIs(err, context.DeadlineExceeded)
+
+					// handed back while the handler is still blocked
+					payloads := make([]any, 0, 2)
+
+					for {
+						capsule, err := d.(Prefetcher[any]).DigUtil(context.Background(), scheduledAt)
+						require.NoError(err)
+
+						if capsule == nil {
+							break
+						}
+
+						payloads = append(payloads, capsule.Payload)
+					}
+
+					assert.ElementsMatch([]any{"blocking", "prefetched"}, payloads)
+				})
+			})
+
+			t.Run("FakeClock", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				clock := NewFakeClock(time.Now())
+				dataloader := clockedDataloader(t, d, DataloaderOption{Clock: clock})
+
+				digger := NewDigger(dataloader, time.Second, TimeCapsuleDiggerOption{Clock: clock})
+				require.NotNil(digger)
+
+				handledAt := make(chan time.Time, 2)
+
+				digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+					handledAt <- clock.Now()
+				})
+
+				startedAt := clock.Now()
+
+				err := digger.BuryFor(context.Background(), "first", 10*time.Second)
+				require.NoError(err)
+
+				err = digger.BuryFor(context.Background(), "second", 20*time.Second)
+				require.NoError(err)
+
+				defer cleanupKey(t, dataloader)
+
+				digger.Start()
+				defer digger.Stop()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				require.NoError(clock.BlockUntil(ctx, 1))
+
+				// wait for the digger to dig and sleep again after each tick
+				advance := func(d time.Duration) {
+					clock.Advance(d)
+					require.NoError(clock.BlockUntil(ctx, 1))
+				}
+
+				advance(9 * time.Second)
+				assert.Empty(handledAt)
+
+				advance(time.Second)
+				require.Len(handledAt, 1)
+				assert.Equal(startedAt.Add(10*time.Second), <-handledAt)
+
+				for i := 0; i < 9; i++ {
+					advance(time.Second)
+				}
+
+				assert.Empty(handledAt)
+
+				advance(time.Second)
+				require.Len(handledAt, 1)
+				assert.Equal(startedAt.Add(20*time.Second), <-handledAt)
+			})
+
+			t.Run("ServerTime", func(t *testing.T) {
+				if _, ok := d.(ServerClock); !ok {
+					t.Skip("the dataloader has no clock other than the one of the digger")
+				}
+
+				// the clock of the digger runs ahead of or behind Redis by an hour,
+				// the capsule should be handled by the clock of Redis anyway
+				for _, offset := range []time.Duration{time.Hour, -time.Hour} {
+					t.Run(offset.String(), func(t *testing.T) {
+						assert := assert.New(t)
+						require := require.New(t)
+
+						clock := skewedClock{offset: offset}
+						dataloader := clockedDataloader(t, d, DataloaderOption{Clock: clock, ServerTime: true})
+
+						digger := NewDigger(dataloader, 10*time.Millisecond, TimeCapsuleDiggerOption{
+							Clock:           clock,
+							MaxIdleInterval: 30 * time.Second,
+							PrefetchWindow:  time.Second,
+						})
+						require.NotNil(digger)
+
+						handledAt := make(chan int64, 1)
+
+						digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+							handledAt <- time.Now().UnixMilli()
+						})
+
+						scheduledAt := time.Now().Add(500 * time.Millisecond).UnixMilli()
+
+						err := digger.BuryUtil(context.Background(), "hello", scheduledAt)
+						require.NoError(err)
+
+						defer cleanupKey(t, dataloader)
+
+						digger.Start()
+						defer digger.Stop()
+
+						select {
+						case at := <-handledAt:
+							assert.GreaterOrEqual(at, scheduledAt)
+							assert.Less(at, scheduledAt+100)
+						case <-time.After(5 * time.Second):
+							assert.Fail("capsule should be handled once it is due by the clock of Redis")
+						}
+					})
+				}
+			})
+
+			t.Run("Pause", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				digger := NewDigger(d, 5*time.Millisecond)
+				require.NotNil(digger)
+
+				handled := make(chan string, 1)
+
+				digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+					payload, _ := capsule.Payload.(string)
+					handled <- payload
+				})
+
+				digger.Pause()
+				assert.True(digger.Paused())
+
+				digger.Start()
+				defer digger.Stop()
+
+				err := digger.BuryFor(context.Background(), "hello", -time.Millisecond)
+				require.NoError(err)
+
+				defer cleanupKey(t, d)
+
+				select {
+				case <-handled:
+					assert.Fail("capsule should not be dug out while paused")
+				case <-time.After(50 * time.Millisecond):
+				}
+
+				digger.Resume()
+				assert.False(digger.Paused())
+
+				select {
+				case payload := <-handled:
+					assert.Equal("hello", payload)
+				case <-time.After(5 * time.Second):
+					assert.Fail("capsule should be dug out once resumed")
+				}
+			})
+
+			t.Run("LeaderElection", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				handled := make(chan int, 10)
+				diggers := make([]*TimeCapsuleDigger[any], 0, 2)
+
+				for i := 0; i < 2; i++ {
+					digger := NewDigger(d, 5*time.Millisecond, TimeCapsuleDiggerOption{LeaderLeaseDuration: time.Minute})
+					require.NotNil(digger)
+
+					digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+						handled <- i
+					})
+
+					digger.Start()
+					diggers = append(diggers, digger)
+				}
+
+				defer cleanupKey(t, d)
+				defer diggers[1].Stop()
+				defer diggers[0].Stop()
+
+				for i := 0; i < 5; i++ {
+					err := diggers[0].BuryFor(context.Background(), fmt.Sprintf("hello %d", i), -time.Millisecond)
+					require.NoError(err)
+				}
+
+				handledBy := make(map[int]int)
+
+				for i := 0; i < 5; i++ {
+					select {
+					case digger := <-handled:
+						handledBy[digger]++
+					case <-time.After(5 * time.Second):
+						require.Fail("capsule should be dug out by the leader")
+					}
+				}
+
+				require.Len(handledBy, 1, "capsules should only be dug out by the leader")
+
+				leader := lo.Keys(handledBy)[0]
+
+				// the leader resigns once it stops, the follower takes over
+				diggers[leader].Stop()
+
+				err := diggers[1-leader].BuryFor(context.Background(), "hello", -time.Millisecond)
+				require.NoError(err)
+
+				select {
+				case digger := <-handled:
+					assert.Equal(1-leader, digger)
+				case <-time.After(5 * time.Second):
+					assert.Fail("capsule should be dug out by the new leader")
+				}
+			})
+
+			t.Run("LeaderLeaseRenewal", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				fencingToken := func(digger *TimeCapsuleDigger[any]) (int64, bool) {
+					digger.leadership.mutex.Lock()
+					defer digger.leadership.mutex.Unlock()
+
+					return digger.leadership.fencingToken, digger.leadership.leading
+				}
+
+				// the lease is much shorter than the dig interval, the leader
+				// should renew it in between the digs
+				leader := NewDigger(d, 300*time.Millisecond, TimeCapsuleDiggerOption{LeaderLeaseDuration: 150 * time.Millisecond})
+				require.NotNil(leader)
+
+				leader.Start()
+
+				defer cleanupKey(t, d)
+				defer leader.Stop()
+
+				require.Eventually(func() bool {
+					_, leading := fencingToken(leader)
+					return leading
+				}, 5*time.Second, 5*time.Millisecond)
+
+				acquiredToken, _ := fencingToken(leader)
+
+				follower := NewDigger(d, 300*time.Millisecond, TimeCapsuleDiggerOption{LeaderLeaseDuration: 150 * time.Millisecond})
+				require.NotNil(follower)
+
+				follower.Start()
+				defer follower.Stop()
+
+				time.Sleep(time.Second)
+
+				token, leading := fencingToken(leader)
+				assert.True(leading, "leadership should be renewed between the digs")
+				assert.Equal(acquiredToken, token, "leadership should not change hands")
+
+				_, leading = fencingToken(follower)
+				assert.False(leading)
+			})
+
+			t.Run("ShardOwnership", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				sharded := shardedDataloader(t, d, 4)
+
+				defer func() {
+					err := sharded.DestroyAll(context.Background())
+					assert.NoError(err)
+
+					cleanupKey(t, sharded)
+				}()
+
+				var mutex sync.Mutex
+				handledBy := make(map[int]int)
+				handledPayloads := make(map[any]int)
+				diggers := make([]*TimeCapsuleDigger[any], 0, 2)
+
+				for i := 0; i < 2; i++ {
+					digger := NewDigger(sharded, 5*time.Millisecond, TimeCapsuleDiggerOption{MembershipTTL: 150 * time.Millisecond})
+					require.NotNil(digger)
+
+					digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+						mutex.Lock()
+						defer mutex.Unlock()
+
+						handledBy[i]++
+						handledPayloads[capsule.Payload]++
+					})
+
+					digger.Start()
+					diggers = append(diggers, digger)
+				}
+
+				defer diggers[1].Stop()
+
+				// both diggers have joined and rebalanced the shards after a few
+				// heartbeats
+				time.Sleep(500 * time.Millisecond)
+
+				for i := 0; i < 20; i++ {
+					err := diggers[0].BuryFor(context.Background(), fmt.Sprintf("hello %d", i), -time.Millisecond)
+					require.NoError(err)
+				}
+
+				require.Eventually(func() bool {
+					mutex.Lock()
+					defer mutex.Unlock()
+
+					return len(handledPayloads) == 20
+				}, 5*time.Second, 5*time.Millisecond)
+
+				mutex.Lock()
+				assert.Len(handledBy, 2, "capsules should be dug out by both diggers")
+				for _, count := range handledPayloads {
+					assert.Equal(1, count)
+				}
+				mutex.Unlock()
+
+				// the digger leaves once it stops, the other one takes over its shards
+				diggers[0].Stop()
+
+				for i := 0; i < 8; i++ {
+					err := diggers[1].BuryFor(context.Background(), fmt.Sprintf("bye %d", i), -time.Millisecond)
+					require.NoError(err)
+				}
+
+				require.Eventually(func() bool {
+					mutex.Lock()
+					defer mutex.Unlock()
+
+					return len(handledPayloads) == 28
+				}, 5*time.Second, 5*time.Millisecond)
+			})
+
+			t.Run("MembershipHeartbeat", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				sharded := shardedDataloader(t, d, 4)
+
+				defer cleanupKey(t, sharded)
+
+				diggers := make([]*TimeCapsuleDigger[any], 0, 2)
+
+				for i := 0; i < 2; i++ {
+					// the TTL is much shorter than the dig interval, the members
+					// should heartbeat in between the digs
+					digger := NewDigger(sharded, 400*time.Millisecond, TimeCapsuleDiggerOption{MembershipTTL: 150 * time.Millisecond})
+					require.NotNil(digger)
+
+					digger.Start()
+					defer digger.Stop()
+
+					diggers = append(diggers, digger)
+
+					// the diggers heartbeat at different times
+					time.Sleep(200 * time.Millisecond)
+				}
+
+				rebalanced := func() bool {
+					owned := append(diggers[0].membership.owned(), diggers[1].membership.owned()...)
+					slices.Sort(owned)
+
+					return slices.Equal([]int{0, 1, 2, 3}, owned)
+				}
+
+				require.Eventually(rebalanced, 5*time.Second, 5*time.Millisecond)
+
+				time.Sleep(time.Second)
+
+				assert.True(rebalanced(), "shards should be owned by both diggers without overlapping")
+			})
+
+			t.Run("TxHandlerFunc", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				handled := make(chan any, 10)
+
+				var handlerFunc HandlerFunc[any]
+
+				var getState func() (string, error)
+
+				switch dataloader := d.(type) {
+				case *RedisDataloader[any]:
+					stateKey := topicKey(dataloader.sortedSetKey, "state")
+					handlerFunc = dataloader.TxHandlerFunc(func(ctx context.Context, _ *TimeCapsuleDigger[any], capsule *TimeCapsule[any], pipe redis.Pipeliner) error {
+						handled <- capsule.Payload
+
+						pipe.Set(ctx, stateKey, capsule.Payload, 0)
+						if capsule.Payload == "partial" {
+							pipe.HSet(ctx, stateKey, "payload", capsule.Payload)
+						}
+
+						return nil
+					})
+					getState = func() (string, error) {
+						return dataloader.redisClient.Get(context.Background(), stateKey).Result()
+					}
+
+					defer func() {
+						assert.NoError(dataloader.redisClient.Del(context.Background(), stateKey).Err())
+					}()
+				case *RueidisDataloader[any]:
+					stateKey := topicKey(dataloader.sortedSetKey, "state")
+					client := dataloader.rueidisClient
+					handlerFunc = dataloader.TxHandlerFunc(func(ctx context.Context, _ *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) (rueidis.Commands, error) {
+						handled <- capsule.Payload
+
+						cmds := rueidis.Commands{client.B().Set().Key(stateKey).Value(capsule.Payload.(string)).Build()}
+						if capsule.Payload == "partial" {
+							cmds = append(cmds, client.B().Hset().Key(stateKey).FieldValue().FieldValue("payload", capsule.Payload.(string)).Build())
+						}
+
+						return cmds, nil
+					})
+					getState = func() (string, error) {
+						return client.Do(context.Background(), client.B().Get().Key(stateKey).Build()).ToString()
+					}
+
+					defer func() {
+						assert.NoError(client.Do(context.Background(), client.B().Del().Key(stateKey).Build()).Error())
+					}()
+				case *MemoryDataloader[any]:
+					var stateMutex sync.Mutex
+					var state string
+
+					handlerFunc = dataloader.TxHandlerFunc(func(ctx context.Context, _ *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) (func(), error) {
+						handled <- capsule.Payload
+
+						return func() {
+							stateMutex.Lock()
+							defer stateMutex.Unlock()
+
+							state = capsule.Payload.(string)
+						}, nil
+					})
+					getState = func() (string, error) {
+						stateMutex.Lock()
+						defer stateMutex.Unlock()
+
+						return state, nil
+					}
+				default:
+					require.FailNow("unexpected dataloader", d.Type())
+				}
+
+				digger := NewDigger(d, 5*time.Millisecond, TimeCapsuleDiggerOption{
+					FailurePolicy: FailurePolicyRetry,
+					RetryLimit:    3,
+					RetryInterval: time.Millisecond,
+				})
+				require.NotNil(digger)
+
+				digger.SetHandlerFunc(handlerFunc)
+				digger.Start()
+
+				defer cleanupKey(t, d)
+				defer digger.Stop()
+
+				for _, payload := range []string{"hello", "partial"} {
+					err := digger.BuryFor(context.Background(), payload, -time.Millisecond)
+					require.NoError(err)
+
+					select {
+					case handledPayload := <-handled:
+						assert.Equal(payload, handledPayload)
+					case <-time.After(5 * time.Second):
+						require.Fail("capsule should be dug out")
+					}
+
+					require.Eventually(func() bool {
+						state, err := getState()
+						return err == nil && state == payload
+					}, 5*time.Second, 5*time.Millisecond)
+				}
+
+				// acked along with the failed write, the capsule is not retried
+				select {
+				case handledPayload := <-handled:
+					assert.Fail("capsule should not be retried", handledPayload)
+				case <-time.After(100 * time.Millisecond):
+				}
+
+				_, ok, err := d.(SchedulePeeker).NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.False(ok)
+			})
+
+			t.Run("DigOnce", func(t *testing.T) {
+				t.Run("HandleDue", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger(d, time.Minute)
+					require.NotNil(digger)
+
+					var handledPayloads []any
+
+					digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+						handledPayloads = append(handledPayloads, capsule.Payload)
+					})
+
+					for i := 0; i < 3; i++ {
+						err := digger.BuryUtil(context.Background(), fmt.Sprintf("capsule %d", i), time.Now().UTC().Add(time.Duration(i-10)*time.Millisecond).UnixMilli())
+						require.NoError(err)
+					}
+
+					err := digger.BuryFor(context.Background(), "shouldNotBeDugOut", time.Minute)
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					handled, err := digger.DigOnce(context.Background())
+					require.NoError(err)
+					assert.Equal(3, handled)
+					assert.Equal([]any{"capsule 0", "capsule 1", "capsule 2"}, handledPayloads)
+
+					handled, err = digger.DigOnce(context.Background())
+					require.NoError(err)
+					assert.Zero(handled)
+
+					_, ok, err := d.(SchedulePeeker).NextScheduledAt(context.Background())
+					require.NoError(err)
+					assert.True(ok)
+				})
+
+				t.Run("SkipQuarantined", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger(d, time.Minute)
+					require.NotNil(digger)
+
+					var handledPayloads []any
+
+					digger.SetHandler(func(digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) {
+						handledPayloads = append(handledPayloads, capsule.Payload)
+					})
+
+					buryNotACapsule(t, d, time.Now().UTC().Add(-20*time.Millisecond).UnixMilli())
+
+					for i := 0; i < 3; i++ {
+						err := digger.BuryUtil(context.Background(), fmt.Sprintf("capsule %d", i), time.Now().UTC().Add(time.Duration(i-10)*time.Millisecond).UnixMilli())
+						require.NoError(err)
+					}
+
+					defer cleanupKey(t, d)
+
+					// the capsule moved to quarantine does not stop digging
+					handled, err := digger.DigOnce(context.Background())
+					require.NoError(err)
+					assert.Equal(3, handled)
+					assert.Equal([]any{"capsule 0", "capsule 1", "capsule 2"}, handledPayloads)
+				})
+
+				t.Run("ReturnError", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger[any](&failingDataloader{Dataloader: d}, time.Minute)
+					require.NotNil(digger)
+
+					handled, err := digger.DigOnce(context.Background())
+					require.EqualError(err, "connection refused")
+					assert.Zero(handled)
+				})
+
+				t.Run("HandBackPrefetched", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger(d, time.Minute, TimeCapsuleDiggerOption{PrefetchWindow: time.Minute})
+					require.NotNil(digger)
+
+					scheduledAt := time.Now().UTC().Add(30 * time.Second).UnixMilli()
+
+					err := digger.BuryUtil(context.Background(), "shouldBeHandedBack", scheduledAt)
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					handled, err := digger.DigOnce(context.Background())
+					require.NoError(err)
+					assert.Zero(handled)
+
+					nextScheduledAt, ok, err := d.(SchedulePeeker).NextScheduledAt(context.Background())
+					require.NoError(err)
+					assert.True(ok)
+					assert.Equal(scheduledAt, nextScheduledAt)
+				})
+
+				t.Run("ResignLeadership", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					handled := make([]int, 0, 2)
+
+					for i := 0; i < 2; i++ {
+						err := d.BuryUtil(context.Background(), fmt.Sprintf("shouldBeDugOutByLeader %d", i), time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+						require.NoError(err)
+
+						digger := NewDigger(d, time.Minute, TimeCapsuleDiggerOption{LeaderLeaseDuration: time.Minute})
+						require.NotNil(digger)
+
+						diggerHandled, err := digger.DigOnce(context.Background())
+						require.NoError(err)
+
+						handled = append(handled, diggerHandled)
+					}
+
+					defer cleanupKey(t, d)
+
+					// the leadership is given up once DigOnce returns
+					assert.Equal([]int{1, 1}, handled)
+				})
+
+				t.Run("Started", func(t *testing.T) {
+					require := require.New(t)
+
+					digger := NewDigger(d, time.Minute)
+					require.NotNil(digger)
+
+					digger.Start()
+					defer digger.Stop()
+
+					_, err := digger.DigOnce(context.Background())
+					require.Error(err)
+				})
+
+				t.Run("Cancelled", func(t *testing.T) {
+					assert := assert.New(t)
+					require := require.New(t)
+
+					digger := NewDigger(d, time.Minute)
+					require.NotNil(digger)
+
+					err := digger.BuryUtil(context.Background(), "shouldNotBeDugOut", time.Now().UTC().Add(-5*time.Millisecond).UnixMilli())
+					require.NoError(err)
+
+					defer cleanupKey(t, d)
+
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+
+					handled, err := digger.DigOnce(ctx)
+					require.ErrorIs(err, context.Canceled)
+					assert.Zero(handled)
+
+					_, ok, err := d.(SchedulePeeker).NextScheduledAt(context.Background())
+					require.NoError(err)
+					assert.True(ok)
+				})
+			})
+
+			t.Run("BaselineDataloader", func(t *testing.T) {
+				assert := assert.New(t)
+				require := require.New(t)
+
+				digger := NewDigger[any](&baselineDataloader{Dataloader: d}, 20*time.Millisecond, TimeCapsuleDiggerOption{
+					MaxIdleInterval: time.Hour,
+					PrefetchWindow:  time.Second,
+					FailurePolicy:   FailurePolicyDeadLetter,
+				})
+				require.NotNil(digger)
+				assert.Zero(digger.option.PrefetchWindow)
+
+				err := digger.BuryCapsule(context.Background(), NewTimeCapsule[any]("hello"), time.Now().UTC().UnixMilli())
+				require.ErrorIs(err, errors.ErrUnsupported)
+
+				errs, err := digger.BuryMany(context.Background(), []Entry[any]{
+					{Payload: "due", UtilUnixMilliTimestamp: time.Now().UTC().Add(-time.Millisecond).UnixMilli()},
+					{Payload: "failing", UtilUnixMilliTimestamp: time.Now().UTC().Add(-time.Millisecond).UnixMilli()},
+					{Payload: "later", UtilUnixMilliTimestamp: time.Now().UTC().Add(100 * time.Millisecond).UnixMilli()},
+				})
+				require.NoError(err)
+				assert.Equal([]error{nil, nil, nil}, errs)
+
+				defer cleanupKey(t, d)
+
+				handled := make(chan string, 3)
+
+				digger.SetHandlerFunc(func(ctx context.Context, digger *TimeCapsuleDigger[any], capsule *TimeCapsule[any]) error {
+					payload, _ := capsule.Payload.(string)
+					handled <- payload
+
+					if payload == "failing" {
+						return errors.New("failed to handle")
+					}
+
+					return nil
+				})
+
+				digger.Start()
+
+				payloads := make([]string, 0, 3)
+
+				for i := 0; i < 3; i++ {
+					select {
+					case payload := <-handled:
+						payloads = append(payloads, payload)
+					case <-time.After(5 * time.Second):
+						require.Fail("capsules should be dug out by polling once per dig interval")
+					}
+				}
+
+				digger.Stop()
+
+				assert.ElementsMatch([]string{"due", "failing", "later"}, payloads)
+
+				// dropped without DeadLetter
+				assert.Empty(listDeadLetters(t, d))
+
+				_, ok, err := d.(SchedulePeeker).NextScheduledAt(context.Background())
+				require.NoError(err)
+				assert.False(ok)
+			})
+
 			t.Run("Start", func(t *testing.T) {
 				assert := assert.New(t)
 				require := require.New(t)
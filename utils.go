@@ -1,6 +1,8 @@
 package timecapsule
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"sync"
 
 	"golang.org/x/net/context"
@@ -32,3 +34,15 @@ func invoke0(ctx context.Context, funcToBeRan func() error) error {
 
 	return err
 }
+
+// newCapsuleID generates a random capsule ID.
+func newCapsuleID() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}